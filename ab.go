@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// parseFlagSet parses an -ab-a or -ab-b specification of semicolon-
+// separated name=value terms, each setting a flag, into a map from the
+// flags' names to their values.
+func parseFlagSet(spec string) (map[string]string, error) {
+	set := make(map[string]string)
+	for _, term := range strings.Split(spec, ";") {
+		term = strings.TrimSpace(term)
+		if term == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(term, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid term %q, want name=value", term)
+		}
+		name = strings.TrimPrefix(strings.TrimSpace(name), "-")
+		if flag.Lookup(name) == nil {
+			return nil, fmt.Errorf("unknown flag -%s", name)
+		}
+		set[name] = strings.TrimSpace(value)
+	}
+	if len(set) == 0 {
+		return nil, fmt.Errorf("empty flag set %q", spec)
+	}
+	return set, nil
+}
+
+// describeFlagSet returns the flags of set as they would be written on
+// the command line, in order of name.
+func describeFlagSet(set map[string]string) string {
+	var s []string
+	for name, value := range set {
+		s = append(s, fmt.Sprintf("-%s=%s", name, value))
+	}
+	sort.Strings(s)
+	return strings.Join(s, " ")
+}
+
+// runAB runs the client -runs times with each of the flag sets -ab-a and
+// -ab-b, alternating between them so that both see the same changes in
+// the path over time, then prints a table comparing the two. It reports
+// whether every run met the -min-throughput and -max-loss thresholds.
+func runAB(ctx context.Context) bool {
+	sets := make([]map[string]string, 2)
+	for i, spec := range []string{*abA, *abB} {
+		set, err := parseFlagSet(spec)
+		if err != nil {
+			log.Exitf("Fatal error parsing -ab-%c: %v", 'a'+i, err)
+		}
+		sets[i] = set
+	}
+	// Each run sets every flag its side names, so both sides must name
+	// the same flags for the other side's values not to linger.
+	for name := range sets[0] {
+		if _, ok := sets[1][name]; !ok {
+			log.Exitf("Fatal error: -ab-a sets -%s, which -ab-b doesn't", name)
+		}
+	}
+	for name := range sets[1] {
+		if _, ok := sets[0][name]; !ok {
+			log.Exitf("Fatal error: -ab-b sets -%s, which -ab-a doesn't", name)
+		}
+	}
+
+	var (
+		throughputs, rtts, losses [2][]float64
+		ok                        = true
+	)
+	for i := 0; i < *runs; i++ {
+		for side, set := range sets {
+			for name, value := range set {
+				if err := flag.Set(name, value); err != nil {
+					log.Exitf("Fatal error setting -%s for -ab-%c: %v", name, 'a'+side, err)
+				}
+			}
+			fmt.Printf("=== Run %d of %d, %c: %s\n", i+1, *runs, 'A'+side, describeFlagSet(set))
+			r, err := clientMain(ctx)
+			if err != nil {
+				log.Exitf("Fatal error: %v", err)
+			}
+			if !checkThresholds(r) {
+				ok = false
+			}
+			throughputs[side] = append(throughputs[side], r.throughput())
+			rtts[side] = append(rtts[side], float64(r.rtt)/1e6)
+			losses[side] = append(losses[side], r.loss*100)
+		}
+	}
+
+	fmt.Println()
+	fmt.Printf("A: %s\n", describeFlagSet(sets[0]))
+	fmt.Printf("B: %s\n", describeFlagSet(sets[1]))
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "\tA\tB\tchange")
+	for _, m := range []struct {
+		name   string
+		values [2][]float64
+		format func(float64) string
+	}{
+		{"throughput", throughputs, formatRate},
+		{"smoothed RTT", rtts, func(v float64) string { return fmt.Sprintf("%.3f ms", v) }},
+		{"loss", losses, func(v float64) string { return fmt.Sprintf("%.3f%%", v) }},
+	} {
+		var cells [2]string
+		var means [2]float64
+		for side, vs := range m.values {
+			if len(vs) > 1 {
+				s := summarize(vs)
+				means[side] = s.mean
+				cells[side] = fmt.Sprintf("%s ± %s", m.format(s.mean), m.format(s.ci))
+				continue
+			}
+			means[side] = vs[0]
+			cells[side] = m.format(vs[0])
+		}
+		change := "-"
+		if means[0] != 0 {
+			change = fmt.Sprintf("%+.1f%%", (means[1]/means[0]-1)*100)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", m.name, cells[0], cells[1], change)
+	}
+	tw.Flush()
+	if *runs > 1 {
+		fmt.Println("± is half the width of the 95% confidence interval of the mean")
+	}
+	return ok
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/quic-go/quic-go/logging"
+)
+
+// accessLog is a logging.Tracer appending a line to a file for every
+// connection attempt the server sees, successful or not, in the logfmt
+// style of key=value pairs:
+//
+//	time=2023-02-01T12:00:00.000Z remote=192.0.2.1:50000 alpn=qperf handshake=ok sent_bytes=1234 received_bytes=567 duration=30.001s close="..."
+//
+// It is kept apart from the debug log so that it can be rotated, shipped
+// and parsed on its own.
+type accessLog struct {
+	logging.NullTracer
+
+	mu sync.Mutex
+	f  *os.File
+}
+
+// openAccessLog opens the access log at path, appending to it if it
+// exists.
+func openAccessLog(path string) (*accessLog, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &accessLog{f: f}, nil
+}
+
+func (a *accessLog) TracerForConnection(context.Context, logging.Perspective, logging.ConnectionID) logging.ConnectionTracer {
+	return &accessRecord{log: a, start: time.Now()}
+}
+
+func (a *accessLog) write(line string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if _, err := a.f.WriteString(line); err != nil {
+		log.Errorf("Error writing to the access log: %v", err)
+	}
+}
+
+// accessRecord follows one connection for the access log, writing its
+// line when the connection is closed.
+type accessRecord struct {
+	logging.NullConnectionTracer
+
+	log   *accessLog
+	start time.Time
+
+	mu          sync.Mutex
+	remote      net.Addr
+	oneRTTKeys  int
+	sent, recvd logging.ByteCount
+	closeReason string
+}
+
+func (r *accessRecord) StartedConnection(_, remote net.Addr, _, _ logging.ConnectionID) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.remote = remote
+}
+
+func (r *accessRecord) UpdatedKeyFromTLS(level logging.EncryptionLevel, _ logging.Perspective) {
+	if level != logging.Encryption1RTT {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.oneRTTKeys++
+}
+
+func (r *accessRecord) SentLongHeaderPacket(_ *logging.ExtendedHeader, size logging.ByteCount, _ *logging.AckFrame, _ []logging.Frame) {
+	r.addSent(size)
+}
+
+func (r *accessRecord) SentShortHeaderPacket(_ *logging.ShortHeader, size logging.ByteCount, _ *logging.AckFrame, _ []logging.Frame) {
+	r.addSent(size)
+}
+
+func (r *accessRecord) ReceivedLongHeaderPacket(_ *logging.ExtendedHeader, size logging.ByteCount, _ []logging.Frame) {
+	r.addReceived(size)
+}
+
+func (r *accessRecord) ReceivedShortHeaderPacket(_ *logging.ShortHeader, size logging.ByteCount, _ []logging.Frame) {
+	r.addReceived(size)
+}
+
+func (r *accessRecord) addSent(size logging.ByteCount) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.sent += size
+}
+
+func (r *accessRecord) addReceived(size logging.ByteCount) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.recvd += size
+}
+
+func (r *accessRecord) ClosedConnection(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.closeReason = err.Error()
+}
+
+func (r *accessRecord) Close() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// The server accepts no other ALPN protocol, so every connection
+	// that completed the handshake negotiated ours.
+	handshake, alpn := "failed", "-"
+	if r.oneRTTKeys == 2 {
+		handshake, alpn = "ok", alpnNextProto
+	}
+	remote := "-"
+	if r.remote != nil {
+		remote = r.remote.String()
+	}
+	r.log.write(fmt.Sprintf("time=%s remote=%s alpn=%s handshake=%s sent_bytes=%d received_bytes=%d duration=%.3fs close=%s\n",
+		r.start.UTC().Format("2006-01-02T15:04:05.000Z07:00"), remote, alpn, handshake,
+		r.sent, r.recvd, time.Since(r.start).Seconds(), strconv.Quote(r.closeReason)))
+}
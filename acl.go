@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// cidrList is a flag.Value holding a comma-separated list of address
+// ranges in CIDR notation, e.g. 192.0.2.0/24,2001:db8::/32.
+type cidrList []*net.IPNet
+
+func (l *cidrList) String() string {
+	var s []string
+	for _, n := range *l {
+		s = append(s, n.String())
+	}
+	return strings.Join(s, ",")
+}
+
+func (l *cidrList) Set(s string) error {
+	var nets []*net.IPNet
+	for _, f := range strings.Split(s, ",") {
+		_, n, err := net.ParseCIDR(strings.TrimSpace(f))
+		if err != nil {
+			return fmt.Errorf("invalid address range %q, want CIDR notation such as 192.0.2.0/24", f)
+		}
+		nets = append(nets, n)
+	}
+	*l = nets
+	return nil
+}
+
+func (l cidrList) contains(ip net.IP) bool {
+	for _, n := range l {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// filteringAddrs reports whether -allow-cidr or -deny-cidr is set.
+func filteringAddrs() bool {
+	return len(allowCIDRs) > 0 || len(denyCIDRs) > 0
+}
+
+// addrAllowed reports whether the server talks to addr: whether it is in
+// -allow-cidr, if that is set, and not in -deny-cidr.
+func addrAllowed(addr net.Addr) bool {
+	udpAddr, ok := addr.(*net.UDPAddr)
+	if !ok {
+		return false
+	}
+	if len(allowCIDRs) > 0 && !allowCIDRs.contains(udpAddr.IP) {
+		return false
+	}
+	return !denyCIDRs.contains(udpAddr.IP)
+}
+
+// readAllowed reads the next packet from c that comes from an allowed
+// address, dropping the others before quic-go sees them, so that the
+// server spends nothing on handshakes it would refuse.
+func readAllowed(c net.PacketConn, p []byte) (int, net.Addr, error) {
+	for {
+		n, addr, err := c.ReadFrom(p)
+		if err != nil || addrAllowed(addr) {
+			return n, addr, err
+		}
+	}
+}
+
+// filteredConn is a net.PacketConn that drops packets from addresses
+// that aren't allowed.
+type filteredConn struct {
+	net.PacketConn
+}
+
+func (c filteredConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	return readAllowed(c.PacketConn, p)
+}
+
+// filteredUDPConn is a filteredConn for a *net.UDPConn, which keeps
+// letting quic-go configure the socket. Like unbatchedConn, it reads one
+// packet at a time.
+type filteredUDPConn struct {
+	unbatchedConn
+}
+
+func (c filteredUDPConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	return readAllowed(c.unbatchedConn, p)
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"crypto/tls"
+	"net/http"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// newACMEManager returns an autocert.Manager that obtains and renews a
+// certificate for -acme-domain, answering the ACME server's HTTP-01
+// challenges on -acme-http-addr. QUIC can't answer TLS-ALPN-01 challenges,
+// which are made over TCP.
+func newACMEManager() *autocert.Manager {
+	m := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(*acmeDomain),
+		Cache:      autocert.DirCache(*acmeCacheDir),
+		Email:      *acmeEmail,
+	}
+
+	srv := &http.Server{
+		Addr:    *acmeHTTPAddr,
+		Handler: m.HTTPHandler(nil),
+	}
+	go func() {
+		log.Infof("Answering ACME HTTP-01 challenges for %s on %s", *acmeDomain, *acmeHTTPAddr)
+		if err := srv.ListenAndServe(); err != nil {
+			log.Exitf("Fatal error serving ACME HTTP-01 challenges on %s: %v", *acmeHTTPAddr, err)
+		}
+	}()
+	return m
+}
+
+// acmeGetCertificate is a tls.Config.GetCertificate callback for m. The
+// client may not send a server name when it was given an IP address, in
+// which case the certificate for -acme-domain is served anyway.
+func acmeGetCertificate(m *autocert.Manager) func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		if hello.ServerName == "" {
+			h := *hello
+			h.ServerName = *acmeDomain
+			hello = &h
+		}
+		return m.GetCertificate(hello)
+	}
+}
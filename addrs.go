@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"sort"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+// addrProbe is the outcome of a handshake with one of the addresses the
+// server's name resolved to.
+type addrProbe struct {
+	addr      string
+	handshake time.Duration
+	err       error
+}
+
+// pickAddress resolves the host of -c and, if it has more than one
+// address, makes a handshake with each of them, one after another or all
+// at once as -try-addrs says, and prints how long each took. It returns
+// the address, with -c's port, that completed its handshake first, or -c
+// itself if there is nothing to choose from.
+func pickAddress(ctx context.Context, tlsConfig *tls.Config, qconf *quic.Config) (string, error) {
+	host, port, err := net.SplitHostPort(*client)
+	if err != nil {
+		return "", err
+	}
+	ips, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return "", err
+	}
+	if len(ips) < 2 {
+		return *client, nil
+	}
+
+	// The probe connections aren't traced, so that they don't count
+	// towards the test's statistics. Otherwise they are made the way dial
+	// makes the test's connection.
+	conf := qconf.Clone()
+	conf.Tracer = nil
+	probe := func(addr string) addrProbe {
+		dialCtx, cancel := ctx, context.CancelFunc(func() {})
+		if *connectTimeout > 0 {
+			dialCtx, cancel = context.WithTimeout(ctx, *connectTimeout)
+		}
+		defer cancel()
+		pconn, raddr, err := clientPacketConn(dialCtx, addr)
+		if err != nil {
+			return addrProbe{addr: addr, err: err}
+		}
+		start := time.Now()
+		var conn quic.Connection
+		if pconn == nil {
+			conn, err = quic.DialAddrContext(dialCtx, addr, tlsConfig, conf)
+		} else {
+			defer pconn.Close()
+			conn, err = quic.DialContext(dialCtx, pconn, raddr, addr, tlsConfig, conf)
+		}
+		if err != nil {
+			return addrProbe{addr: addr, err: err}
+		}
+		d := time.Since(start)
+		conn.CloseWithError(quic.ApplicationErrorCode(quic.NoError), "address probe")
+		return addrProbe{addr: addr, handshake: d}
+	}
+
+	probes := make([]addrProbe, len(ips))
+	if *tryAddrs == "race" {
+		done := make(chan struct{}, len(ips))
+		for i, ip := range ips {
+			go func(i int, addr string) {
+				probes[i] = probe(addr)
+				done <- struct{}{}
+			}(i, net.JoinHostPort(ip.String(), port))
+		}
+		for range ips {
+			<-done
+		}
+	} else {
+		for i, ip := range ips {
+			probes[i] = probe(net.JoinHostPort(ip.String(), port))
+		}
+	}
+
+	sort.SliceStable(probes, func(i, j int) bool {
+		if (probes[i].err == nil) != (probes[j].err == nil) {
+			return probes[i].err == nil
+		}
+		return probes[i].handshake < probes[j].handshake
+	})
+	fmt.Printf("%s resolved to %d addresses, tried %s:\n", host, len(ips), map[string]string{"race": "at once", "sequential": "one after another"}[*tryAddrs])
+	for _, p := range probes {
+		if p.err != nil {
+			fmt.Printf("  %s: %v\n", p.addr, p.err)
+			continue
+		}
+		fmt.Printf("  %s: handshake %.3f ms\n", p.addr, float64(p.handshake)/1e6)
+	}
+	if probes[0].err != nil {
+		return "", fmt.Errorf("no address of %s could be reached", host)
+	}
+	fmt.Printf("Testing with %s\n", probes[0].addr)
+	return probes[0].addr, nil
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// parseCPUList parses a list of CPUs in the format of cpuset(7), e.g.
+// "0-3,8".
+func parseCPUList(s string) ([]int, error) {
+	var cpus []int
+	for _, f := range strings.Split(s, ",") {
+		lo, hi, isRange := strings.Cut(strings.TrimSpace(f), "-")
+		first, err := strconv.Atoi(lo)
+		if err != nil || first < 0 {
+			return nil, fmt.Errorf("invalid CPU %q", f)
+		}
+		last := first
+		if isRange {
+			if last, err = strconv.Atoi(hi); err != nil || last < first {
+				return nil, fmt.Errorf("invalid CPU range %q", f)
+			}
+		}
+		for cpu := first; cpu <= last; cpu++ {
+			cpus = append(cpus, cpu)
+		}
+	}
+	return cpus, nil
+}
+
+// pinCPUs restricts the process to the CPUs in the list s, and runs as
+// many Go threads at once as there are CPUs in it.
+func pinCPUs(s string) error {
+	cpus, err := parseCPUList(s)
+	if err != nil {
+		return err
+	}
+	if err := setAffinity(cpus); err != nil {
+		return err
+	}
+	runtime.GOMAXPROCS(len(cpus))
+	return nil
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+// setAffinity isn't supported on this platform.
+func setAffinity(cpus []int) error {
+	return errors.New("pinning to CPUs isn't supported on this platform")
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"net"
+	"runtime"
+	"syscall"
+)
+
+// The version of quic-go we use reads from a *net.UDPConn in batches,
+// using recvmmsg where the platform has it, but sends every packet with a
+// sendmsg call of its own. It supports neither sendmmsg nor GSO, and
+// writes each packet synchronously from the connection's run loop, reusing
+// its buffer as soon as the write returns, so a net.PacketConn can't batch
+// the writes behind its back without holding packets back for a timer.
+
+// recvBatchSize returns the number of packets quic-go reads from a
+// *net.UDPConn with each system call on this platform.
+func recvBatchSize() int {
+	switch runtime.GOOS {
+	case "linux", "freebsd":
+		return 8
+	default:
+		return 1
+	}
+}
+
+// logBatching logs the server's UDP backend and whether its sends and
+// receives are batched.
+func logBatching() {
+	if *udpBackend == "iouring" {
+		log.Infof("UDP backend: io_uring (experimental), with one send or receive in flight at a time")
+		return
+	}
+	log.Infof("UDP backend: standard system calls")
+	log.Infof("UDP send batching: inactive, quic-go sends each packet with its own system call")
+	if !*recvBatch {
+		log.Infof("UDP receive batching: inactive, turned off with -recv-batch=false")
+	} else if filteringAddrs() {
+		log.Infof("UDP receive batching: inactive, packets are read one at a time to filter them by -allow-cidr and -deny-cidr")
+	} else if emulating() {
+		log.Infof("UDP receive batching: inactive, packets are read one at a time while emulating impairments")
+	} else if n := recvBatchSize(); n > 1 {
+		log.Infof("UDP receive batching: active, reading up to %d packets with each recvmmsg call", n)
+	} else {
+		log.Infof("UDP receive batching: inactive, not supported on %s", runtime.GOOS)
+	}
+}
+
+// unbatchedConn hides the methods of a *net.UDPConn that quic-go reads
+// batches of packets with, so that it reads one packet with each
+// ReadFrom call instead. quic-go can't read the ECN bits of the packets
+// either without them.
+type unbatchedConn struct {
+	net.PacketConn
+	udpConn *net.UDPConn
+}
+
+func unbatched(c *net.UDPConn) net.PacketConn {
+	return unbatchedConn{PacketConn: c, udpConn: c}
+}
+
+// SyscallConn lets quic-go set the Don't Fragment bit as it would on c.
+func (c unbatchedConn) SyscallConn() (syscall.RawConn, error) {
+	return c.udpConn.SyscallConn()
+}
+
+// SetReadBuffer lets quic-go size the receive buffer as it would for c.
+func (c unbatchedConn) SetReadBuffer(bytes int) error {
+	return c.udpConn.SetReadBuffer(bytes)
+}
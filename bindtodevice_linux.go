@@ -0,0 +1,26 @@
+//go:build linux
+
+package main
+
+import (
+	"net"
+
+	"golang.org/x/sys/unix"
+)
+
+// bindToDevice binds c to the network device name, so that its packets
+// are sent and received only through it, whatever the routing tables say.
+func bindToDevice(c *net.UDPConn, name string) error {
+	rawConn, err := c.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var sockErr error
+	if err := rawConn.Control(func(fd uintptr) {
+		sockErr = unix.SetsockoptString(int(fd), unix.SOL_SOCKET, unix.SO_BINDTODEVICE, name)
+	}); err != nil {
+		return err
+	}
+	return sockErr
+}
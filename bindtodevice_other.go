@@ -0,0 +1,13 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"net"
+)
+
+// bindToDevice isn't supported on this platform.
+func bindToDevice(c *net.UDPConn, name string) error {
+	return errors.New("binding to a network device isn't supported on this platform")
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+// burstStats describes how a single burst arrived.
+type burstStats struct {
+	bytes uint64
+	// Time from sending the request to the first byte, to half of the
+	// burst and to all of it.
+	firstByte, half, total time.Duration
+}
+
+// runBursts asks the server for -burst-size bytes at a time, idling for
+// -burst-gap between bursts, for the duration of the test. Each burst is
+// an RPC, so the server sends it at full rate.
+func runBursts(ctx context.Context, conn quic.Connection) testResult {
+	cancelBulkStream(ctx, conn)
+
+	req := newRPCRequest(rpcHeaderLen, *burstSize)
+
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(*durationInSecs)*time.Second)
+	defer cancel()
+
+	var (
+		total  uint64
+		busy   time.Duration
+		bursts []burstStats
+	)
+	for ctx.Err() == nil {
+		b, err := doBurst(ctx, conn, req)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Errorf("Error receiving burst from %s: %v", conn.RemoteAddr(), err)
+			}
+			break
+		}
+		bursts = append(bursts, b)
+		total += b.bytes
+		busy += b.total
+
+		firstHalf := b.half - b.firstByte
+		secondHalf := b.total - b.half
+		fmt.Printf("Burst %d: %d bytes in %.3f ms (%s), first byte after %.3f ms, first half at %s, second half at %s\n",
+			len(bursts), b.bytes, float64(b.total)/1e6,
+			rate(b.bytes, b.total),
+			float64(b.firstByte)/1e6,
+			rate(b.bytes/2, firstHalf),
+			rate(b.bytes-b.bytes/2, secondHalf))
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(*burstGap):
+		}
+	}
+
+	fmt.Printf("Received: %d bytes in %d bursts, %.3f seconds busy (%s while busy)\n",
+		total, len(bursts), busy.Seconds(), rate(total, busy))
+	return testResult{bytes: total, duration: busy}
+}
+
+// doBurst requests a burst with req and times its arrival.
+func doBurst(ctx context.Context, conn quic.Connection, req []byte) (burstStats, error) {
+	start := time.Now()
+	s, err := openRPC(ctx, conn, req)
+	if err != nil {
+		return burstStats{}, err
+	}
+
+	var (
+		b    burstStats
+		buf  [readChunkSize]byte
+		want = uint64(*burstSize)
+	)
+	for b.bytes < want {
+		n, err := s.Read(buf[:])
+		if n > 0 && b.bytes == 0 {
+			b.firstByte = time.Since(start)
+		}
+		b.bytes += uint64(n)
+		if b.half == 0 && b.bytes >= want/2 {
+			b.half = time.Since(start)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			s.CancelRead(quic.StreamErrorCode(quic.NoError))
+			return b, err
+		}
+	}
+	if b.bytes != want {
+		return b, fmt.Errorf("got a %d byte burst, want %d bytes", b.bytes, want)
+	}
+	b.total = time.Since(start)
+	return b, nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"crypto/tls"
+	"sync"
+)
+
+// certReloader serves the certificate in -cert and -key, reloading it from
+// disk on SIGHUP, or on Windows when the files change, so that a long
+// running server can pick up a renewed certificate without dropping the
+// connections it's serving.
+type certReloader struct {
+	certFile, keyFile string
+
+	mu   sync.Mutex
+	cert *tls.Certificate
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// reload loads the key pair from disk, keeping the current one if that
+// fails.
+func (r *certReloader) reload() error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.cert = &cert
+	return nil
+}
+
+// reloadLogged reloads the key pair, logging the outcome.
+func (r *certReloader) reloadLogged() {
+	if err := r.reload(); err != nil {
+		log.Errorf("Error reloading TLS key pair, keeping the current one: %v", err)
+		return
+	}
+	log.Infof("Reloaded TLS key pair from %s and %s", r.certFile, r.keyFile)
+}
+
+// getCertificate is a tls.Config.GetCertificate callback returning the
+// current certificate.
+func (r *certReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.cert, nil
+}
@@ -0,0 +1,37 @@
+//go:build windows
+
+package main
+
+import (
+	"os"
+	"time"
+)
+
+// certPollInterval is how often the key pair files are checked for
+// changes on Windows, which has no SIGHUP.
+const certPollInterval = time.Minute
+
+// watch reloads the key pair whenever the modification time of either
+// file changes.
+func (r *certReloader) watch() {
+	lastCert, lastKey := r.modTimes()
+	for range time.Tick(certPollInterval) {
+		cert, key := r.modTimes()
+		if !cert.Equal(lastCert) || !key.Equal(lastKey) {
+			lastCert, lastKey = cert, key
+			r.reloadLogged()
+		}
+	}
+}
+
+// modTimes returns the modification times of the key pair files, with
+// the zero time for a file that can't be read.
+func (r *certReloader) modTimes() (cert, key time.Time) {
+	if fi, err := os.Stat(r.certFile); err == nil {
+		cert = fi.ModTime()
+	}
+	if fi, err := os.Stat(r.keyFile); err == nil {
+		key = fi.ModTime()
+	}
+	return cert, key
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// timedProbe is a latency probe answered with the server's receive time.
+// All three times are wall clock times in nanoseconds since the Unix
+// epoch, the first and last by the client's clock and the second by the
+// server's.
+type timedProbe struct {
+	sent, server, received int64
+}
+
+// offset returns the offset of the server's clock from the client's that
+// the probe suggests, assuming the path is equally long both ways. The
+// true offset lies within half the probe's RTT of it.
+func (t timedProbe) offset() float64 {
+	return float64(t.server-t.sent) - float64(t.rtt())/2
+}
+
+func (t timedProbe) rtt() int64 {
+	return t.received - t.sent
+}
+
+// clockWindows is the number of windows the probes are split into to
+// estimate the clock offset. As in NTP, only the probe with the smallest
+// RTT in each window is used, since it was the least delayed by queues.
+const clockWindows = 8
+
+// clockEstimate is the estimated offset of the server's clock from the
+// client's, in nanoseconds, at the client time ref, and how fast it
+// drifts.
+type clockEstimate struct {
+	ref         int64
+	offset      float64
+	drift       float64 // nanoseconds per nanosecond
+	uncertainty float64 // nanoseconds either way
+}
+
+// at returns the estimated offset at client time t.
+func (c clockEstimate) at(t int64) float64 {
+	return c.offset + c.drift*float64(t-c.ref)
+}
+
+// estimateClock estimates the server's clock offset and drift by fitting
+// a line through the offsets of the best probe in each window.
+func estimateClock(probes []timedProbe) (clockEstimate, bool) {
+	if len(probes) == 0 {
+		return clockEstimate{}, false
+	}
+	probes = append([]timedProbe(nil), probes...)
+	sort.Slice(probes, func(i, j int) bool { return probes[i].sent < probes[j].sent })
+
+	var best []timedProbe
+	windows := clockWindows
+	if len(probes) < windows {
+		windows = len(probes)
+	}
+	for w := 0; w < windows; w++ {
+		window := probes[w*len(probes)/windows : (w+1)*len(probes)/windows]
+		b := window[0]
+		for _, t := range window[1:] {
+			if t.rtt() < b.rtt() {
+				b = t
+			}
+		}
+		best = append(best, b)
+	}
+
+	c := clockEstimate{ref: best[0].sent}
+	minRTT := best[0].rtt()
+	var sx, sy, sxx, sxy float64
+	for _, t := range best {
+		if t.rtt() < minRTT {
+			minRTT = t.rtt()
+		}
+		x, y := float64(t.sent-c.ref), t.offset()
+		sx += x
+		sy += y
+		sxx += x * x
+		sxy += x * y
+	}
+	n := float64(len(best))
+	if d := n*sxx - sx*sx; len(best) > 1 && d != 0 {
+		c.drift = (n*sxy - sx*sy) / d
+	}
+	c.offset = (sy - c.drift*sx) / n
+
+	// Widen the bound from the best probe's RTT by how far the probes
+	// stray from the fitted line.
+	var sq float64
+	for _, t := range best {
+		r := t.offset() - c.at(t.sent)
+		sq += r * r
+	}
+	c.uncertainty = float64(minRTT)/2 + math.Sqrt(sq/n)
+	return c, true
+}
+
+// reportOneWay prints the estimated clock offset and the median one-way
+// delays in each phase, corrected by it. p.mu must be held.
+func (p *latencyProber) reportOneWay() {
+	all := append(append([]timedProbe(nil), p.timed[phaseIdle]...), p.timed[phaseLoaded]...)
+	c, ok := estimateClock(all)
+	if !ok {
+		fmt.Println("One-way delay: no probes were timestamped; is the server running a version of qperf that supports -one-way?")
+		return
+	}
+	fmt.Printf("Clock offset: server clock is %+.3f ms from the client's (±%.3f ms), drifting %+.3f ppm\n",
+		c.offset/1e6, c.uncertainty/1e6, c.drift*1e6)
+
+	for phase, name := range []string{"Idle", "Loaded"} {
+		timed := p.timed[phase]
+		if len(timed) == 0 {
+			continue
+		}
+		up := make([]time.Duration, len(timed))
+		down := make([]time.Duration, len(timed))
+		for i, t := range timed {
+			offset := c.at(t.sent)
+			up[i] = time.Duration(float64(t.server-t.sent) - offset)
+			down[i] = time.Duration(float64(t.received-t.server) + offset)
+		}
+		fmt.Printf("%s one-way delay: median %.3f ms to the server, %.3f ms from the server (±%.3f ms)\n",
+			name, float64(median(up))/1e6, float64(median(down))/1e6, c.uncertainty/1e6)
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+// runComparison runs the client against each of the comma-separated
+// servers in -c in turn, then prints a table comparing them. The servers
+// are tested one at a time so that they don't compete for the client's
+// own link. It reports whether every run met the -min-throughput and
+// -max-loss thresholds.
+func runComparison(ctx context.Context) bool {
+	servers := strings.Split(*client, ",")
+
+	var (
+		results = make([]testResult, len(servers))
+		ok      = true
+	)
+	for i, server := range servers {
+		server = strings.TrimSpace(server)
+		servers[i] = server
+		if err := flag.Set("c", server); err != nil {
+			log.Exitf("Fatal error: %v", err)
+		}
+
+		fmt.Printf("=== Server %d: %s\n", i+1, server)
+		r, err := clientMain(ctx)
+		if err != nil {
+			log.Exitf("Fatal error: %v", err)
+		}
+		results[i] = r
+		if !checkThresholds(results[i]) {
+			ok = false
+		}
+	}
+
+	fmt.Println()
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "server\tbytes\tseconds\tthroughput\tloss")
+	for i, r := range results {
+		fmt.Fprintf(tw, "%s\t%d\t%.3f\t%s\t%.3f%%\n",
+			servers[i], r.bytes, r.duration.Seconds(), formatRate(r.throughput()), r.loss*100)
+	}
+	tw.Flush()
+	return ok
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+// tlsVersionName returns the name of a TLS version as written in its
+// RFC.
+func tlsVersionName(v uint16) string {
+	switch v {
+	case tls.VersionTLS10:
+		return "TLS 1.0"
+	case tls.VersionTLS11:
+		return "TLS 1.1"
+	case tls.VersionTLS12:
+		return "TLS 1.2"
+	case tls.VersionTLS13:
+		return "TLS 1.3"
+	}
+	return fmt.Sprintf("TLS 0x%04x", v)
+}
+
+// keyShareGroup returns the key exchange group the client sends a key
+// share for, the first of its curve preferences. Go's TLS doesn't report
+// the group that was negotiated, which is a different one if the server
+// doesn't accept this one and asks for another with a HelloRetryRequest,
+// e.g. when it runs with -tls-groups.
+func keyShareGroup(c *tls.Config) tls.CurveID {
+	if len(c.CurvePreferences) > 0 {
+		return c.CurvePreferences[0]
+	}
+	return tls.X25519
+}
+
+// printConnectionDetails prints what was negotiated for conn, so results
+// record the protocols that were actually tested.
+func printConnectionDetails(conn quic.Connection, tlsConf *tls.Config) {
+	cs := conn.ConnectionState()
+	resumed := ""
+	if cs.TLS.DidResume {
+		resumed = ", resumed"
+	}
+	datagrams := "no datagrams"
+	if cs.SupportsDatagrams {
+		datagrams = "datagrams"
+	}
+	fmt.Printf("Connection: QUIC %s, ALPN %s, %s with %s, offered key share %s%s, %s\n",
+		cs.Version, cs.TLS.NegotiatedProtocol, tlsVersionName(cs.TLS.Version),
+		tls.CipherSuiteName(cs.TLS.CipherSuite), keyShareGroup(tlsConf), resumed, datagrams)
+}
+
+// printPeerCertificates prints the subject, issuer, validity and
+// fingerprints of each certificate in the chain the server presented, so
+// that a client that skipped verifying them knows what it connected to.
+func printPeerCertificates(certs []*x509.Certificate) {
+	fmt.Println("Server certificate chain (not verified):")
+	now := time.Now()
+	for i, c := range certs {
+		validity := ""
+		if now.Before(c.NotBefore) || now.After(c.NotAfter) {
+			validity = " (expired or not yet valid)"
+		}
+		fmt.Printf("  %d: subject %s\n", i, c.Subject)
+		fmt.Printf("     issuer %s\n", c.Issuer)
+		fmt.Printf("     valid from %s to %s%s\n",
+			c.NotBefore.UTC().Format(time.RFC3339), c.NotAfter.UTC().Format(time.RFC3339), validity)
+		fmt.Printf("     SHA-256 fingerprint %x\n", sha256.Sum256(c.Raw))
+		fmt.Printf("     public key pin %s\n", spkiPin(c))
+	}
+}
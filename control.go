@@ -0,0 +1,212 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+// Application error codes the server closes connections with when it
+// turns them away: while it's controlled through -control, or when
+// -accept-rate is exceeded. A -relay closes them with errorCodeUpstream
+// when it can't connect to its upstream server.
+const (
+	errorCodeNoTest      = 1
+	errorCodeTestStopped = 2
+	errorCodeBusy        = 3
+	errorCodeUpstream    = 4
+)
+
+// controlServer lets an external scheduler decide when the server runs
+// tests, through a JSON over HTTP API:
+//
+//	POST /start    start a test, accepting clients until it's stopped
+//	GET  /status   whether a test is running, and its connections so far
+//	GET  /results  the same, with the throughput of each connection
+//	POST /stop     stop the test, closing the connections in progress
+//
+// While no test is running, connecting clients are turned away.
+type controlServer struct {
+	mu      sync.Mutex
+	testID  int
+	running bool
+	started time.Time
+	conns   []*controlledConn
+}
+
+// controlledConn is a connection accepted during a test.
+type controlledConn struct {
+	conn  quic.Connection
+	start time.Time
+	sent  *sendCounter // nil until the bulk transfer starts
+
+	end time.Time // zero while the connection is open
+}
+
+// connStatus describes a connection in the control API's responses.
+type connStatus struct {
+	Conn       uint64  `json:"conn"`
+	Remote     string  `json:"remote"`
+	BytesSent  uint64  `json:"bytes_sent"`
+	Seconds    float64 `json:"seconds"`
+	Done       bool    `json:"done"`
+	Throughput float64 `json:"throughput_bps,omitempty"`
+	// The send rate over the last second, for open connections.
+	CurrentRate float64 `json:"current_rate_bps,omitempty"`
+}
+
+// testStatus describes the current or last test in the control API's
+// responses.
+type testStatus struct {
+	Test        int          `json:"test"`
+	Running     bool         `json:"running"`
+	Started     time.Time    `json:"started"`
+	Connections []connStatus `json:"connections"`
+}
+
+// control is the server's control API, or nil if -control isn't set.
+var control *controlServer
+
+// serve serves the control API on addr until it fails.
+func (c *controlServer) serve(addr string) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/start", c.post(func() {
+		c.testID++
+		c.running = true
+		c.started = time.Now()
+		c.conns = nil
+		log.Infof("Started test %d", c.testID)
+	}))
+	mux.HandleFunc("/stop", c.post(func() {
+		if !c.running {
+			return
+		}
+		c.running = false
+		for _, cc := range c.conns {
+			if cc.end.IsZero() {
+				cc.conn.CloseWithError(errorCodeTestStopped, "test stopped")
+			}
+		}
+		log.Infof("Stopped test %d", c.testID)
+	}))
+	mux.HandleFunc("/status", c.get(false))
+	mux.HandleFunc("/results", c.get(true))
+	return http.ListenAndServe(addr, mux)
+}
+
+// post returns a handler that runs f with c locked and replies with the
+// status.
+func (c *controlServer) post(f func()) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "use POST", http.StatusMethodNotAllowed)
+			return
+		}
+		if !controlAuthorized(w, r) {
+			return
+		}
+		c.mu.Lock()
+		f()
+		status := c.status(false)
+		c.mu.Unlock()
+		writeJSON(w, status)
+	}
+}
+
+// get returns a handler that replies with the status, including the
+// throughput of each connection if results is set.
+func (c *controlServer) get(results bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "use GET", http.StatusMethodNotAllowed)
+			return
+		}
+		if !controlAuthorized(w, r) {
+			return
+		}
+		c.mu.Lock()
+		status := c.status(results)
+		c.mu.Unlock()
+		writeJSON(w, status)
+	}
+}
+
+// status returns the state of the current test. c.mu must be held.
+func (c *controlServer) status(results bool) testStatus {
+	s := testStatus{
+		Test:        c.testID,
+		Running:     c.running,
+		Started:     c.started,
+		Connections: []connStatus{},
+	}
+	for _, cc := range c.conns {
+		end := cc.end
+		if end.IsZero() {
+			end = time.Now()
+		}
+		cs := connStatus{
+			Conn:    cc.conn.Context().Value(quic.ConnectionTracingKey).(uint64),
+			Remote:  cc.conn.RemoteAddr().String(),
+			Seconds: end.Sub(cc.start).Seconds(),
+			Done:    !cc.end.IsZero(),
+		}
+		if cc.sent != nil {
+			cs.BytesSent = cc.sent.bytes()
+		}
+		if results && cs.Seconds > 0 {
+			cs.Throughput = float64(cs.BytesSent) * 8 / cs.Seconds
+		}
+		s.Connections = append(s.Connections, cs)
+	}
+	return s
+}
+
+// admit reports whether conn may take part in a test, and if so tracks
+// it until it's closed.
+func (c *controlServer) admit(conn quic.Connection) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.running {
+		return false
+	}
+	cc := &controlledConn{conn: conn, start: time.Now()}
+	c.conns = append(c.conns, cc)
+	go func() {
+		<-conn.Context().Done()
+		c.mu.Lock()
+		cc.end = time.Now()
+		c.mu.Unlock()
+	}()
+	return true
+}
+
+// track records w as the counter of the bytes sent on conn.
+func (c *controlServer) track(conn quic.Connection, w *sendCounter) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, cc := range c.conns {
+		if cc.conn == conn {
+			cc.sent = w
+		}
+	}
+}
+
+// controlAuthorized checks the request's token against -agent-token,
+// replying with an error if it doesn't match.
+func controlAuthorized(w http.ResponseWriter, r *http.Request) bool {
+	if *agentToken != "" && r.Header.Get("Authorization") != "Bearer "+*agentToken {
+		http.Error(w, "bad token", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// convergeWindow is the number of seconds of throughput averaged by
+// -converge.
+const convergeWindow = 5
+
+// convergence watches the throughput of a transfer second by second for
+// -converge, deciding when its moving average has settled: when the
+// average over the last convergeWindow seconds is within the tolerance of
+// the average over the convergeWindow seconds before.
+type convergence struct {
+	tolerance float64
+	start     time.Time
+	tick      time.Time // end of the second being counted
+	last      uint64    // bytes received at the start of that second
+	perSecond []float64 // bytes received in each full second
+	done      time.Duration
+}
+
+func newConvergence(tolerance float64, start time.Time) *convergence {
+	return &convergence{tolerance: tolerance, start: start, tick: start.Add(time.Second)}
+}
+
+// add records that total bytes had been received at now and reports
+// whether the throughput has converged.
+func (c *convergence) add(total uint64, now time.Time) bool {
+	if c.done > 0 {
+		return true
+	}
+	for !now.Before(c.tick) {
+		c.perSecond = append(c.perSecond, float64(total-c.last))
+		c.last = total
+		c.tick = c.tick.Add(time.Second)
+	}
+	n := len(c.perSecond)
+	if n < 2*convergeWindow {
+		return false
+	}
+	prev, cur := mean(c.perSecond[n-2*convergeWindow:n-convergeWindow]), mean(c.perSecond[n-convergeWindow:])
+	if prev > 0 && math.Abs(cur-prev) <= c.tolerance*prev {
+		c.done = now.Sub(c.start)
+		return true
+	}
+	return false
+}
+
+// report prints whether and when the throughput converged.
+func (c *convergence) report() {
+	if c.done > 0 {
+		fmt.Printf("Throughput converged within %g%% after %.1f seconds\n", c.tolerance*100, c.done.Seconds())
+		return
+	}
+	fmt.Printf("Throughput didn't converge within %g%% before the %d second limit\n", c.tolerance*100, *durationInSecs)
+}
+
+// mean returns the mean of vs.
+func mean(vs []float64) float64 {
+	var sum float64
+	for _, v := range vs {
+		sum += v
+	}
+	return sum / float64(len(vs))
+}
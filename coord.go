@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// agentStartDelay is how far in the future the coordinator schedules the
+// start of a test, to give every agent time to get the request. The
+// agents' clocks are assumed to be synchronized, e.g. with NTP.
+const agentStartDelay = 2 * time.Second
+
+// agentRequest asks an agent to run a test against Server, starting at
+// StartAt.
+type agentRequest struct {
+	Server  string    `json:"server"`
+	Seconds int64     `json:"seconds"`
+	StartAt time.Time `json:"start_at"`
+}
+
+// agentResult is an agent's report of a test.
+type agentResult struct {
+	Bytes      uint64       `json:"bytes"`
+	Seconds    float64      `json:"seconds"`
+	Throughput float64      `json:"throughput_bps"`
+	Loss       float64      `json:"loss"`
+	Tags       tagList      `json:"tags,omitempty"`
+	Env        *environment `json:"env,omitempty"`
+}
+
+// runAgent serves the coordinator's requests to run tests on -agent until
+// the process is killed. Tests are run one at a time with the agent's own
+// flags, apart from the server and duration given in the request.
+func runAgent() {
+	var mu sync.Mutex
+	http.HandleFunc("/run", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "use POST", http.StatusMethodNotAllowed)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer "+*agentToken {
+			http.Error(w, "bad token", http.StatusUnauthorized)
+			return
+		}
+		var req agentRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		flag.Set("c", req.Server)
+		flag.Set("seconds", strconv.FormatInt(req.Seconds, 10))
+		log.Infof("Running a %d second test against %s for %s at %s", req.Seconds, req.Server, r.RemoteAddr, req.StartAt)
+		time.Sleep(time.Until(req.StartAt))
+		res, err := clientMain(r.Context())
+		if err != nil {
+			log.Errorf("Test against %s failed: %v", req.Server, err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(agentResult{
+			Bytes:      res.bytes,
+			Seconds:    res.duration.Seconds(),
+			Throughput: res.throughput(),
+			Loss:       res.loss,
+			Tags:       resultTags,
+			Env:        res.env,
+		})
+	})
+
+	log.Infof("Waiting for tests to run on %s", *agent)
+	log.Exitf("Fatal error serving agent requests: %v", http.ListenAndServe(*agent, nil))
+}
+
+// runCoordinator has each of the agents in -agents run the test against
+// the server in -c at the same time, then prints their results and the
+// aggregate throughput. It reports whether the aggregate met the
+// -min-throughput threshold and every agent's loss met -max-loss.
+func runCoordinator() bool {
+	agents := strings.Split(*agentList, ",")
+	req := agentRequest{
+		Server:  *client,
+		Seconds: *durationInSecs,
+		StartAt: time.Now().Add(agentStartDelay),
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		log.Exitf("Fatal error encoding agent request: %v", err)
+	}
+
+	var (
+		results = make([]agentResult, len(agents))
+		errs    = make([]error, len(agents))
+		wg      sync.WaitGroup
+	)
+	hc := &http.Client{Timeout: agentStartDelay + time.Duration(*durationInSecs)*time.Second + *connectTimeout + time.Minute}
+	for i, a := range agents {
+		agents[i] = strings.TrimSpace(a)
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], errs[i] = callAgent(hc, agents[i], body)
+		}(i)
+	}
+	wg.Wait()
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "agent\tbytes\tseconds\tthroughput\tloss")
+	var (
+		total testResult
+		ok    = true
+	)
+	for i, res := range results {
+		if errs[i] != nil {
+			fmt.Fprintf(tw, "%s\terror: %v\t\t\t\n", agents[i], errs[i])
+			ok = false
+			continue
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%.3f\t%s\t%.3f%%\n",
+			agents[i], res.Bytes, res.Seconds, formatRate(res.Throughput), res.Loss*100)
+		total.bytes += res.Bytes
+		if d := time.Duration(res.Seconds * float64(time.Second)); d > total.duration {
+			total.duration = d
+		}
+		if res.Loss > total.loss {
+			total.loss = res.Loss
+		}
+	}
+	tw.Flush()
+
+	if total.duration > 0 {
+		fmt.Printf("Aggregate: %d bytes in %.3f seconds (%s)\n", total.bytes, total.duration.Seconds(), rate(total.bytes, total.duration))
+	}
+	return checkThresholds(total) && ok
+}
+
+// callAgent asks the agent at addr to run the test described by body and
+// returns its result.
+func callAgent(hc *http.Client, addr string, body []byte) (agentResult, error) {
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, "http://"+addr+"/run", bytes.NewReader(body))
+	if err != nil {
+		return agentResult{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if *agentToken != "" {
+		req.Header.Set("Authorization", "Bearer "+*agentToken)
+	}
+
+	resp, err := hc.Do(req)
+	if err != nil {
+		return agentResult{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<10))
+		return agentResult{}, fmt.Errorf("agent returned %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+
+	var res agentResult
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return agentResult{}, err
+	}
+	return res, nil
+}
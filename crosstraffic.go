@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+// crossPacketSize is the size of the UDP datagrams sent as cross traffic.
+const crossPacketSize = 1200
+
+// phaseStats describes the bulk transfer during one half of a cross
+// traffic test.
+type phaseStats struct {
+	bytes    uint64
+	duration time.Duration
+	loss     float64
+	srtt     time.Duration
+}
+
+// runCrossTraffic receives the bulk transfer on its own for the first half
+// of the test and alongside a competing flow for the second half, then
+// reports how the throughput, loss and RTT of the bulk transfer changed.
+// The competing flow is either a second QUIC connection receiving a bulk
+// transfer, made with dialAnother, or UDP datagrams sent to the server at
+// -cross-rate, which it ignores.
+func runCrossTraffic(ctx context.Context, conn quic.Connection, stats *connStats, dialAnother func() (quic.Connection, error)) testResult {
+	s, err := conn.AcceptUniStream(ctx)
+	if err != nil {
+		log.Exitf("Fatal error accepting unidirectional stream from %s: %v", conn.RemoteAddr(), err)
+	}
+	defer s.CancelRead(quic.StreamErrorCode(quic.NoError))
+
+	half := time.Duration(*durationInSecs) * time.Second / 2
+	start := time.Now()
+	alone, err := readPhase(s, stats, start.Add(half))
+	if err != nil {
+		log.Exitf("Fatal error reading from stream: %v", err)
+	}
+
+	crossCtx, cancel := context.WithTimeout(ctx, half)
+	defer cancel()
+	var crossBytes uint64 // accessed atomically
+	switch *crossTraffic {
+	case "quic":
+		c, err := dialAnother()
+		if err != nil {
+			log.Exitf("Fatal error establishing cross traffic connection: %v", err)
+		}
+		defer c.CloseWithError(quic.ApplicationErrorCode(quic.NoError), "done")
+		go func() {
+			if err := receiveBulk(crossCtx, c, &crossBytes); err != nil && crossCtx.Err() == nil {
+				log.forConn(c).Errorf("Error reading cross traffic: %v", err)
+			}
+		}()
+	case "udp":
+		go func() {
+			if err := sendUDPCrossTraffic(crossCtx, float64(crossRate), &crossBytes); err != nil {
+				log.Errorf("Error sending cross traffic: %v", err)
+			}
+		}()
+	}
+
+	contended, err := readPhase(s, stats, time.Now().Add(half))
+	if err != nil {
+		log.Exitf("Fatal error reading from stream: %v", err)
+	}
+	cancel()
+
+	for _, p := range []struct {
+		name string
+		phaseStats
+	}{{"Alone", alone}, {"With cross traffic", contended}} {
+		fmt.Printf("%s: %d bytes in %.3f seconds (%s), estimated loss %.3f%%, smoothed RTT %.3f ms\n",
+			p.name, p.bytes, p.duration.Seconds(), rate(p.bytes, p.duration), p.loss*100, float64(p.srtt)/1e6)
+	}
+	cross := atomic.LoadUint64(&crossBytes)
+	fmt.Printf("Cross traffic (%s): %d bytes (%s)\n", *crossTraffic, cross, rate(cross, contended.duration))
+
+	aloneRate := float64(alone.bytes) / alone.duration.Seconds()
+	contendedRate := float64(contended.bytes) / contended.duration.Seconds()
+	if aloneRate > 0 {
+		fmt.Printf("Change under cross traffic: throughput %+.1f%%, smoothed RTT %+.3f ms\n",
+			(contendedRate/aloneRate-1)*100, float64(contended.srtt-alone.srtt)/1e6)
+	}
+
+	total := alone.bytes + contended.bytes
+	loss, _, _ := stats.receiveLoss()
+	return testResult{bytes: total, duration: time.Since(start), loss: loss}
+}
+
+// readPhase reads s until end, returning the phase's statistics.
+func readPhase(s quic.ReceiveStream, stats *connStats, end time.Time) (phaseStats, error) {
+	if err := s.SetReadDeadline(end); err != nil {
+		return phaseStats{}, err
+	}
+	prevPackets, prevPN := stats.received()
+
+	var (
+		p     phaseStats
+		buf   [readChunkSize]byte
+		start = time.Now()
+	)
+	for {
+		n, err := s.Read(buf[:])
+		p.bytes += uint64(n)
+		if err != nil {
+			if e, ok := err.(net.Error); !ok || !e.Timeout() {
+				if err != io.EOF {
+					return p, err
+				}
+			}
+			break
+		}
+	}
+	p.duration = time.Since(start)
+
+	packets, pn := stats.received()
+	if pn > prevPN {
+		p.loss = 1 - float64(packets-prevPackets)/float64(pn-prevPN)
+	}
+	p.srtt, _ = stats.rtt()
+	return p, nil
+}
+
+// sendUDPCrossTraffic sends datagrams to the server at bps bits per second
+// until ctx is done, adding the number of bytes sent to *n atomically.
+func sendUDPCrossTraffic(ctx context.Context, bps float64, n *uint64) error {
+	raddr, err := net.ResolveUDPAddr("udp", *client)
+	if err != nil {
+		return err
+	}
+	c, err := net.DialUDP("udp", nil, raddr)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	var (
+		pkt   [crossPacketSize]byte
+		sent  uint64
+		start = time.Now()
+		t     = time.NewTicker(time.Millisecond)
+	)
+	defer t.Stop()
+	for {
+		for float64(sent+crossPacketSize)*8 <= bps*time.Since(start).Seconds() {
+			// The server isn't listening for these, so errors
+			// such as ICMP port unreachable are to be expected.
+			c.Write(pkt[:])
+			sent += crossPacketSize
+			atomic.AddUint64(n, crossPacketSize)
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-t.C:
+		}
+	}
+}
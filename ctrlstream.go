@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+// A client asks the server for tests other than the bulk download on a
+// control stream: a bidirectional stream starting with controlMagic,
+// which serveRPCs tells apart from the size in an RPC header. Read as a
+// size, controlMagic is controlMagicSize, which is reserved: clients never
+// ask for a response of that size.
+//
+// The control stream starts with a hello. The client sends controlMagic
+// followed by the highest version of the protocol it speaks, as a byte,
+// and the server answers with controlMagic and the version the two will
+// use: the lower of the client's and its own. What follows is up to that
+// version, so that either end can grow new requests without confusing
+// the other. The client then sends a request byte:
+//
+//   - in version 1 and later, the directions of a test, as described in
+//     upload.go;
+//   - in version 2 and later, requestResults, asking for the server's
+//     view of the bulk transfer, as described in results.go;
+//   - in version 3 and later, requestDownload, asking for the download
+//     on the control stream, as described in download.go;
+//   - in version 4 and later, requestEcho, asking the server to echo
+//     the stream back, as described in echo.go;
+//   - in version 5 and later, requestDownloadBytes, asking for a
+//     download of a given size on the control stream, as described in
+//     download.go;
+//   - in version 6 and later, requestPause, asking the server to pause
+//     the bulk transfer until the stream is closed, as described in
+//     pause.go.
+//
+// A server that predates the control stream takes the hello for an RPC
+// and never answers it, so the client gives up after controlHelloTimeout
+// rather than hanging.
+const (
+	controlMagic     = "QPRF"
+	controlMagicSize = 0x51505246
+	controlVersion   = 6
+)
+
+// requestResults is the request for the server's results. It has a bit
+// no set of directions has.
+const requestResults = 0x80
+
+// controlHelloTimeout is how long the client waits for the server's
+// hello.
+const controlHelloTimeout = 5 * time.Second
+
+var errNoControl = errors.New("the server didn't answer on the control stream; it may be running a version of qperf that doesn't support this test")
+
+// openControl opens a control stream to the server on conn and exchanges
+// hellos, returning the stream ready for a request and the version of
+// the protocol agreed on.
+func openControl(ctx context.Context, conn quic.Connection) (quic.Stream, byte, error) {
+	s, err := conn.OpenStreamSync(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+	if _, err := s.Write(append([]byte(controlMagic), controlVersion)); err != nil {
+		return nil, 0, err
+	}
+
+	s.SetReadDeadline(time.Now().Add(controlHelloTimeout))
+	var hello [len(controlMagic) + 1]byte
+	if _, err := io.ReadFull(s, hello[:]); err != nil {
+		s.CancelRead(quic.StreamErrorCode(quic.NoError))
+		s.CancelWrite(quic.StreamErrorCode(quic.NoError))
+		return nil, 0, errNoControl
+	}
+	s.SetReadDeadline(time.Time{})
+	if string(hello[:len(controlMagic)]) != controlMagic {
+		s.CancelRead(quic.StreamErrorCode(quic.NoError))
+		s.CancelWrite(quic.StreamErrorCode(quic.NoError))
+		return nil, 0, errNoControl
+	}
+	v := hello[len(controlMagic)]
+	if v == 0 || v > controlVersion {
+		s.CancelRead(quic.StreamErrorCode(quic.NoError))
+		s.CancelWrite(quic.StreamErrorCode(quic.NoError))
+		return nil, 0, fmt.Errorf("the server chose version %d of the control protocol, which this client doesn't speak", v)
+	}
+	return s, v, nil
+}
+
+// serveControl handles a control stream whose magic has been read,
+// answering the client's hello and then its request.
+func serveControl(conn quic.Connection, s quic.Stream, clog *logger) {
+	var b [1]byte
+	if _, err := io.ReadFull(s, b[:]); err != nil {
+		clog.Errorf("Error reading control hello from client: %s: %v", conn.RemoteAddr(), err)
+		s.CancelRead(quic.StreamErrorCode(quic.NoError))
+		return
+	}
+	version := b[0]
+	if version > controlVersion {
+		version = controlVersion
+	}
+	if version == 0 {
+		clog.Errorf("Invalid control protocol version 0 from client: %s", conn.RemoteAddr())
+		s.CancelRead(quic.StreamErrorCode(quic.NoError))
+		return
+	}
+	if _, err := s.Write(append([]byte(controlMagic), version)); err != nil {
+		clog.Errorf("Error writing control hello to client: %s: %v", conn.RemoteAddr(), err)
+		return
+	}
+
+	if _, err := io.ReadFull(s, b[:]); err != nil {
+		clog.Errorf("Error reading control request from client: %s: %v", conn.RemoteAddr(), err)
+		s.CancelRead(quic.StreamErrorCode(quic.NoError))
+		return
+	}
+	if b[0] == requestResults && version >= 2 {
+		serveResults(conn, s, clog)
+		return
+	}
+	if b[0] == requestDownload && version >= 3 {
+		serveDownload(conn, s, clog, 0)
+		return
+	}
+	if b[0] == requestDownloadBytes && version >= 5 {
+		var limit [8]byte
+		if _, err := io.ReadFull(s, limit[:]); err != nil {
+			clog.Errorf("Error reading download size from client: %s: %v", conn.RemoteAddr(), err)
+			s.CancelRead(quic.StreamErrorCode(quic.NoError))
+			return
+		}
+		serveDownload(conn, s, clog, binary.BigEndian.Uint64(limit[:]))
+		return
+	}
+	if b[0] == requestPause && version >= 6 {
+		servePause(conn, s, clog)
+		return
+	}
+	if b[0] == requestEcho && version >= 4 {
+		serveEcho(conn, s, clog)
+		return
+	}
+	serveUpload(conn, s, clog, b[0])
+}
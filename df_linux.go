@@ -0,0 +1,31 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"net"
+
+	"golang.org/x/sys/unix"
+)
+
+// setDF sets the Don't Fragment bit on packets sent from c, for both IPv4
+// and IPv6 since c may be a dual stack socket.
+func setDF(c *net.UDPConn) error {
+	rawConn, err := c.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var errIPv4, errIPv6 error
+	if err := rawConn.Control(func(fd uintptr) {
+		errIPv4 = unix.SetsockoptInt(int(fd), unix.IPPROTO_IP, unix.IP_MTU_DISCOVER, unix.IP_PMTUDISC_DO)
+		errIPv6 = unix.SetsockoptInt(int(fd), unix.IPPROTO_IPV6, unix.IPV6_MTU_DISCOVER, unix.IPV6_PMTUDISC_DO)
+	}); err != nil {
+		return err
+	}
+	if errIPv4 != nil && errIPv6 != nil {
+		return fmt.Errorf("setting DF failed for both IPv4 (%v) and IPv6 (%v)", errIPv4, errIPv6)
+	}
+	return nil
+}
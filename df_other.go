@@ -0,0 +1,13 @@
+//go:build !linux && !windows && !darwin
+
+package main
+
+import (
+	"errors"
+	"net"
+)
+
+// setDF isn't supported on this platform.
+func setDF(c *net.UDPConn) error {
+	return errors.New("setting the Don't Fragment bit isn't supported on this platform")
+}
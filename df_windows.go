@@ -0,0 +1,37 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"net"
+
+	"golang.org/x/sys/windows"
+)
+
+// The socket options are the same for IPv4 and IPv6.
+const (
+	ipDontFragment = 14
+	ipv6DontFrag   = 14
+)
+
+// setDF sets the Don't Fragment bit on packets sent from c, for both IPv4
+// and IPv6 since c may be a dual stack socket.
+func setDF(c *net.UDPConn) error {
+	rawConn, err := c.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var errIPv4, errIPv6 error
+	if err := rawConn.Control(func(fd uintptr) {
+		errIPv4 = windows.SetsockoptInt(windows.Handle(fd), windows.IPPROTO_IP, ipDontFragment, 1)
+		errIPv6 = windows.SetsockoptInt(windows.Handle(fd), windows.IPPROTO_IPV6, ipv6DontFrag, 1)
+	}); err != nil {
+		return err
+	}
+	if errIPv4 != nil && errIPv6 != nil {
+		return fmt.Errorf("setting DF failed for both IPv4 (%v) and IPv6 (%v)", errIPv4, errIPv6)
+	}
+	return nil
+}
@@ -15,34 +15,258 @@ Usage of qperf:
 
 The flags are:
 
+	-ab-a string
+	      when running as a client, compare runs with these semicolon-separated flag settings, e.g. "transport-params=initial_max_data=1048576", against runs with -ab-b
+	-ab-b string
+	      the flag settings for the B side of an -ab-a comparison, setting the same flags
+	-accept-rate float
+	      when running as a server, accept at most this many new connections per second on average, in bursts of up to a second's worth, refusing the rest
+	-access-log string
+	      when running as a server, append a line describing every connection attempt to this file
+	-acme-cache-dir string
+	      directory to keep the ACME account key and certificates in (default "qperf-acme")
+	-acme-domain string
+	      when running as a server, obtain and renew a certificate for this domain from Let's Encrypt instead of using -cert and -key
+	-acme-email string
+	      contact address to register with the ACME server
+	-acme-http-addr string
+	      answer ACME HTTP-01 challenges on this TCP address (default ":80")
 	-addr string
 	      listen on this address (default ":32850")
+	-advertise
+	      when running as a server, advertise the server on the local network with mDNS
+	-agent string
+	      run as an agent, running tests for a coordinator that connects to this address
+	-agent-token string
+	      token that the coordinator, agents and control API clients use to authenticate requests; required with -agent
+	-agents string
+	      run as a coordinator, having each of these comma-separated agents run the test against the server in -c at the same time
+	-allow-cidr value
+	      when running as a server, ignore packets from addresses outside these comma-separated ranges, e.g. 192.0.2.0/24,2001:db8::/32
 	-alsologtostderr
 	      log to standard error as well as files
+	-burst-gap duration
+	      idle for this long between bursts (default 1s)
+	-burst-size int
+	      when running as a client, receive bursts of this many bytes at full rate instead of a bulk transfer
+	-bytes
+	      report rates in bytes rather than bits per second
 	-c string
-	      run as a client to specified remote (default "localhost:32850")
+	      run as a client to specified remote, or to each of a comma-separated list of remotes in turn (default "localhost:32850")
 	-cert string
 	      path to the tls certificate file
+	-cid-length int
+	      use connection IDs of this many bytes, from 4 to 18, for the connection IDs this end chooses; 0 leaves it to quic-go
+	-client-port int
+	      send from this local UDP port when running as a client (default: an ephemeral port)
+	-client-stream
+	      when running as a client, open the stream the download is sent on instead of accepting the one the server opens
+	-connect-timeout duration
+	      when running as a client, give up if the connection isn't established within this time (0 for no limit) (default 10s)
+	-connections int
+	      when running as a client, receive the bulk transfer on this many connections at once and report how fairly they share the path (default 1)
+	-control string
+	      when running as a server, only run tests when told to through a control API, JSON over HTTP rather than gRPC, served on this address
+	-converge value
+	      when running as a client, end the download once its throughput over the last 5 seconds is within this percentage of that over the 5 seconds before, e.g. 5%, or after -seconds at most
+	-cpus string
+	      run only on these CPUs, e.g. 0-3,8 (Linux only)
+	-cross-rate value
+	      rate of the udp -cross-traffic, e.g. 50Mbps
+	-cross-traffic string
+	      when running as a client, add a competing flow for the second half of the test: quic for a second bulk transfer or udp for datagrams sent to the server at -cross-rate
+	-datagrams
+	      negotiate support for QUIC datagrams (RFC 9221), which the latency probes need; a server without it doesn't answer probes (default true)
+	-deny-cidr value
+	      when running as a server, ignore packets from addresses in these comma-separated ranges, even if -allow-cidr includes them
+	-df
+	      report packets that appear to be dropped for being too large, setting the Don't Fragment bit on outgoing packets where quic-go doesn't already
+	-direction string
+	      when running as a client, test the download from the server, the upload to it, or both at once (default "download")
+	-disable-pmtud
+	      don't probe for a larger path MTU, capping packets at 1252 (IPv4) or 1232 (IPv6) bytes
+	-discover
+	      list the qperf servers advertised on the local network with mDNS, and exit
+	-echo
+	      when running as a client, send on a bidirectional stream that the server echoes back and report the echoed throughput instead of a bulk transfer
+	-emulate-delay duration
+	      delay the packets sent by this long, e.g. 80ms
+	-emulate-jitter duration
+	      vary the delay of the packets sent randomly by up to this much either way, keeping them in order
+	-emulate-loss value
+	      randomly drop this percentage of the packets sent, e.g. 1%
+	-emulate-queue int
+	      bytes that may wait for the -emulate-rate link before packets sent are dropped (default 65536)
+	-emulate-rate value
+	      send packets through an emulated link of this rate, queueing up to -emulate-queue bytes for it, e.g. 20Mbps
+	-emulate-reorder value
+	      send this percentage of the packets sent after the -emulate-reorder-gap packets that follow them, e.g. 1%
+	-emulate-reorder-gap int
+	      number of later packets that overtake each packet reordered by -emulate-reorder (default 3)
+	-env
+	      when running as a client, record the kernel version, CPU, UDP buffer sysctls and the NIC's offload settings, print them and add them to the JSON results
+	-fct value
+	      when running as a client, request objects of each of these comma-separated sizes in turn, one at a time, instead of a bulk transfer and report their completion times, e.g. 50KB,1MB,10MB
+	-gnuplot string
+	      when running as a client, write the time, throughput, smoothed RTT and loss of every 100ms of the transfer to this file as whitespace-separated columns for gnuplot, adding -2, -3 and so on to the name for later runs
+	-handshake-idle-timeout duration
+	      abort a connection attempt if nothing is heard from the peer for this long during the handshake (default 5s)
+	-health-addr string
+	      when running as a server or relay, answer HTTP health checks on this address, with 200 once the QUIC listener is up
+	-heartbeat duration
+	      when running as a client, send a heartbeat at this interval if nothing else was sent, keeping a quiet connection from timing out, and report when the server stops answering them
+	-hgrm string
+	      write the distribution of request latencies to this file in HdrHistogram's .hgrm format
 	-insecure
 	      don't verify TLS certificate details
+	-interface string
+	      bind the UDP socket to this network device, e.g. eth1, so that packets go through it whatever the routes say (Linux only)
 	-key string
 	      path to the tls private key file
+	-latency-under-load
+	      when running as a client, measure the RTT with datagram probes before and during the transfer
+	-log-format string
+	      write logs to stderr as text or JSON lines (json), or through glog (glog) (default "text")
 	-log_backtrace_at value
 	      when logging hits line file:N, emit a stack trace
 	-log_dir string
 	      If non-empty, write log files in this directory
 	-logtostderr
 	      log to standard error instead of files
+	-low-memory
+	      use little memory, for routers and other small devices: advertise small flow control windows, collect garbage more often and log only warnings and errors
+	-masque-proxy string
+	      tunnel the client's QUIC connection through the MASQUE proxy at this https URL with HTTP/3 CONNECT-UDP; a URL without a {target_host} template gets /.well-known/masque/udp/{target_host}/{target_port}/ added
+	-max-bytes int
+	      when running as a server, end each download with a FIN after this many bytes, stop reading each upload after this many, and reset the RPC responses and echoes that take a connection past this many, whatever the client asks for (0 for no limit)
+	-max-conn-rate value
+	      when running as a server, send to each client at no more than this rate, e.g. 1Gbps
+	-max-loss value
+	      when running as a client, exit with status 3 if the estimated packet loss is above this percentage, e.g. 0.5%
+	-min-throughput value
+	      when running as a client, exit with status 3 if the throughput is below this rate, e.g. 500Mbps
+	-mixed
+	      when running as a client, make requests as -rpc does, alone for the first half of the test and alongside a bulk transfer on the same connection for the second half, and report how their latency changed
+	-ndjson
+	      when running as a client, write the throughput, smoothed RTT and loss of every second of the download, and then of the whole of it, to standard output as lines of JSON, moving the usual output to standard error
+	-nic-stats
+	      when running as a client, report how much the byte, packet, error and drop counters of the network interface used grew during the test (Linux only)
+	-one-way
+	      also estimate the offset between the client's and server's clocks from the latency probes and report the one-way delay in each direction
+	-packet-stats
+	      print the packets sent, received, acknowledged and lost and the PTO count in each packet number space
+	-pause-at duration
+	      when running as a client, have the server pause the download this far into it, for -pause-for, leaving the pause out of the throughput
+	-pause-for duration
+	      how long a -pause-at pause lasts (default 5s)
+	-pin value
+	      when running as a client, require the server's certificate chain to include one of these comma-separated public key pins, e.g. sha256/...; with -insecure, check only the pin
+	-poisson-rate float
+	      when running as a client, start requests as a Poisson process with this mean rate per second instead of a bulk transfer
+	-poisson-sizes value
+	      distribution of response sizes for -poisson-rate: fixed:N, uniform:MIN-MAX or exp:MEAN bytes (default fixed:1024)
+	-probe-interval duration
+	      send latency probes at this interval (default 100ms)
+	-proxy string
+	      relay the client's UDP traffic through this SOCKS5 proxy (host:port or socks5://[user:password@]host:port)
 	-qlog-dest-dir string
 	      activate qlog writing and write the qlogs in this directory
+	-qlog-summary
+	      when running as a client, print a digest of each qlog written to -qlog-dest-dir once the test is over
+	-ramp value
+	      when running as a client, receive at each of these comma-separated rates in turn instead of at full rate, e.g. 10M,50M,100M
+	-ramp-step duration
+	      time spent at each rate of a -ramp (default 10s)
+	-recv-batch
+	      read packets in batches with recvmmsg where the platform supports it; -recv-batch=false reads one packet with each system call, to measure the difference (default true)
+	-recvfile string
+	      when running as a client, write the received data to this file and print its SHA-256
+	-relay string
+	      run as a relay, accepting connections on -addr like a server and forwarding their streams and datagrams to the qperf server at this address
+	-replay string
+	      when running as a client, make the requests in this schedule file of timestamp and size lines instead of a bulk transfer
+	-report string
+	      when running as a client, chart the throughput, RTT, loss and congestion window of the transfer in this standalone HTML file
+	-retries int
+	      when running as a client, retry connecting this many times if the server can't be reached
+	-retry
+	      when running as a server, validate every client's address with a Retry packet before the handshake
+	-retry-interval duration
+	      wait this long before the first retry, doubling the wait for each retry after that (default 1s)
+	-retry-token-age duration
+	      when running as a server with -retry, accept the tokens of Retry packets for this long (default 5s)
+	-rpc
+	      when running as a client, make request/response round trips instead of a bulk transfer
+	-rpc-concurrency int
+	      number of requests to keep outstanding (default 1)
+	-rpc-request-size int
+	      size of each request in bytes (default 64)
+	-rpc-response-size int
+	      size of each response in bytes (default 1024)
+	-runs int
+	      when running as a client, run the test this many times and print the mean, 95% confidence interval and coefficient of variation of the throughput and RTT (default 1)
 	-s	run as a server
+	-samples string
+	      when running as a client, write the bytes received, RTT, loss and congestion window of every 100ms of the transfer to this CSV file
 	-seconds int
 	      run the test for this number of seconds. (default 30)
+	-seed int
+	      seed for the random payload and client workloads, so that runs with the same seed are repeatable (default: a time-based seed)
+	-selftest
+	      run a server on an ephemeral loopback port and a client against it in this process, to check the host's UDP stack and the build without a second machine
+	-send-log-interval duration
+	      when running as a server, log the bytes sent to each client, and how fairly they were shared, at this interval, e.g. 1s
+	-sendfile string
+	      when running as a server, send the contents of this file instead of random data
+	-show-transport-params
+	      when running as a client, print the transport parameters the server advertised
+	-sni string
+	      when running as a client, send this TLS server name and verify the server's certificate for it instead of the host given with -c
+	-sockets int
+	      when running as a server, listen on this many sockets sharing -addr with SO_REUSEPORT, each with its own QUIC listener, to spread the load across cores (Linux only) (default 1)
+	-sparkline
+	      when running as a client, draw the throughput of each second of the download as a sparkline on the terminal, updated in place
+	-status string
+	      when running as a server, serve the active connections, their send rates and recently finished connections as JSON at /status, and stream live stats at /events, on this address
 	-stderrthreshold value
 	      logs at or above this threshold go to stderr
+	-stop string
+	      when running as a client, how to end the download: stop reading after -seconds (deadline), also cancel the stream with STOP_SENDING then (reset), or have the server close it with a FIN after -stop-bytes (fin) (default "deadline")
+	-stop-bytes int
+	      with -stop fin, the number of bytes the server sends before closing the stream
+	-streams int
+	      when running as a client, receive on this many parallel streams and report on each of them (default 1)
+	-sweep string
+	      when running as a client, run the test once for every combination of flag values in this list, e.g. "streams=1,2,4,8;seconds=10,30", and print a table of the results
+	-sweep-json string
+	      also write the -sweep results to this file as JSON
+	-tag value
+	      attach this key=value tag, e.g. site=ams1, to the JSON results of -ndjson, -sweep-json, the agent and the status API; may be repeated
+	-tls-ciphers value
+	      offer or accept only these comma-separated TLS 1.3 cipher suites, in order of preference, e.g. TLS_CHACHA20_POLY1305_SHA256
+	-tls-groups value
+	      offer or accept only these comma-separated key exchange groups, in order of preference: X25519, P256, P384 or P521
+	-total-rate value
+	      when running as a server, share this total send rate equally between the clients being served, e.g. 10Gbps
+	-transport-params value
+	      advertise these comma-separated transport parameters: max_idle_timeout (a duration), initial_max_data, initial_max_stream_data, initial_max_streams_bidi or initial_max_streams_uni, e.g. initial_max_data=1048576; the flow control windows set this way are initial ones, which auto-tuning can still grow to quic-go's maximum (or to the value set, if larger)
+	-try-addrs string
+	      when running as a client to a name with several addresses, first make a handshake with each of them, one after another (sequential) or all at once (race), and test with the fastest
+	-udp-backend string
+	      send and receive UDP with the standard system calls (std) or, experimentally and on Linux only, with io_uring (iouring) (default "std")
+	-units string
+	      prefixes for rates: k for kilo, si to scale by powers of 1000 or iec to scale by powers of 1024 (default "k")
 	-v value
 	      log level for V logs
+	-verify
+	      send (server) or expect (client) sequence numbered, checksummed blocks and report any corruption; must be set on both sides
+	-version
+	      print the versions of qperf, its git commit, Go and quic-go, and exit
 	-vmodule value
 	      comma-separated list of pattern=N settings for file-filtered logging
+	-web int
+	      when running as a client, load pages of this many objects, each requested at once on its own stream, one page after another instead of a bulk transfer
+	-web-sizes value
+	      distribution of object sizes for -web: fixed:N, uniform:MIN-MAX or exp:MEAN bytes (default exp:20000)
 */
 package main
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/quic-go/quic-go"
+)
+
+// With -client-stream the client opens the stream the download is sent
+// on: it cancels the server's bulk transfer stream, opens a control
+// stream, sends requestDownload and closes its side, and the server then
+// writes the payload on the control stream instead.
+//
+// With -stop fin the client sends requestDownloadBytes instead, followed
+// by a big-endian 64 bit number of bytes, and the server closes the
+// stream with a FIN once it has written that many.
+//
+// requestDownload and requestDownloadBytes are the requests for the
+// download on the control stream. Like requestResults, they have a bit
+// no set of directions has.
+const (
+	requestDownload      = 0x81
+	requestDownloadBytes = 0x83
+)
+
+// serveDownload answers a request for the download on the control stream
+// s by sending the payload on it: limit bytes of it, or all of it if
+// limit is 0.
+func serveDownload(conn quic.Connection, s quic.Stream, clog *logger, limit uint64) {
+	s.CancelRead(quic.StreamErrorCode(quic.NoError))
+	clog.Infof("Sending on the stream opened by client: %s", conn.RemoteAddr())
+	sendBulk(conn.Context(), conn, s, clog, limit)
+}
+
+// openDownload asks the server on conn to send the download on a stream
+// the client opens, ending it after limit bytes unless limit is 0, and
+// returns that stream. The caller cancels the server's bulk transfer
+// stream.
+func openDownload(ctx context.Context, conn quic.Connection, limit uint64) (quic.ReceiveStream, error) {
+	s, version, err := openControl(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+	req := []byte{requestDownload}
+	need := byte(3)
+	if limit > 0 {
+		req = binary.BigEndian.AppendUint64([]byte{requestDownloadBytes}, limit)
+		need = 5
+	}
+	if version < need {
+		s.CancelRead(quic.StreamErrorCode(quic.NoError))
+		s.CancelWrite(quic.StreamErrorCode(quic.NoError))
+		if limit > 0 {
+			return nil, fmt.Errorf("the server speaks version %d of the control protocol, which can't end the download after a number of bytes", version)
+		}
+		return nil, fmt.Errorf("the server speaks version %d of the control protocol, which can't send on a stream the client opens", version)
+	}
+	if _, err := s.Write(req); err != nil {
+		return nil, err
+	}
+	s.Close()
+	return s, nil
+}
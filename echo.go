@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+// With -echo the client sends data on a control stream after
+// requestEcho and the server writes every byte it reads back on the
+// same stream, closing its side once the client closes its own. Both
+// directions are driven by the one stream, as in a proxy or tunnel.
+//
+// requestEcho is the request for an echo test on the control stream.
+// Like requestResults, it has a bit no set of directions has.
+const requestEcho = 0x82
+
+// serveEcho answers a request for an echo test on the control stream s.
+func serveEcho(conn quic.Connection, s quic.Stream, clog *logger) {
+	clog.Infof("Echoing stream from client: %s", conn.RemoteAddr())
+	w, done := sendTo(conn, s)
+	defer done()
+	var (
+		buf [readChunkSize]byte
+		n   uint64
+	)
+	defer func() {
+		clog.Infof("Echoed %d bytes to client: %s", n, conn.RemoteAddr())
+	}()
+	for {
+		m, err := s.Read(buf[:])
+		if m > 0 {
+			if !spendMaxBytes(conn, uint64(m)) {
+				clog.Infof("Stopping the echo to client at -max-bytes: %s", conn.RemoteAddr())
+				s.CancelRead(errorCodeMaxBytes)
+				s.CancelWrite(errorCodeMaxBytes)
+				return
+			}
+			if _, werr := w.Write(buf[:m]); werr != nil {
+				if !closedByPeer(werr) {
+					clog.Errorf("Error echoing to client: %s: %v", conn.RemoteAddr(), werr)
+				}
+				s.CancelRead(quic.StreamErrorCode(quic.NoError))
+				return
+			}
+			n += uint64(m)
+		}
+		if err == io.EOF {
+			s.Close()
+			return
+		}
+		if err != nil {
+			if !closedByPeer(err) {
+				clog.Errorf("Error reading echo stream from client: %s: %v", conn.RemoteAddr(), err)
+			}
+			s.CancelWrite(quic.StreamErrorCode(quic.NoError))
+			return
+		}
+	}
+}
+
+// runEcho sends data to the server on a control stream for -seconds,
+// reading the echo back as it goes, and reports the echoed throughput.
+func runEcho(ctx context.Context, conn quic.Connection) testResult {
+	cancelBulkStream(ctx, conn)
+
+	s, version, err := openControl(ctx, conn)
+	if err != nil {
+		log.Exitf("Fatal error starting echo test with %s: %v", conn.RemoteAddr(), err)
+	}
+	if version < 4 {
+		s.CancelRead(quic.StreamErrorCode(quic.NoError))
+		s.CancelWrite(quic.StreamErrorCode(quic.NoError))
+		log.Exitf("Fatal error: %s speaks version %d of the control protocol, which has no echo test", conn.RemoteAddr(), version)
+	}
+	if _, err := s.Write([]byte{requestEcho}); err != nil {
+		log.Exitf("Fatal error starting echo test with %s: %v", conn.RemoteAddr(), err)
+	}
+
+	var sent uint64 // accessed atomically
+	go func() {
+		s.SetWriteDeadline(time.Now().Add(time.Duration(*durationInSecs) * time.Second))
+		for ctx.Err() == nil {
+			n, err := s.Write(data[:])
+			atomic.AddUint64(&sent, uint64(n))
+			if e, ok := err.(net.Error); ok && e.Timeout() {
+				break
+			}
+			if err != nil {
+				return
+			}
+		}
+		s.Close()
+	}()
+
+	var (
+		buf         [readChunkSize]byte
+		echoed      uint64
+		first, last time.Time
+	)
+	s.SetReadDeadline(time.Now().Add(time.Duration(*durationInSecs)*time.Second + uploadDrainTimeout))
+	for {
+		n, err := s.Read(buf[:])
+		if n > 0 {
+			if echoed == 0 {
+				first = time.Now()
+			}
+			last = time.Now()
+			echoed += uint64(n)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if isMaxBytes(err) {
+				err = errMaxBytes
+			}
+			log.Errorf("Error reading echo from %s: %v", conn.RemoteAddr(), err)
+			s.CancelRead(quic.StreamErrorCode(quic.NoError))
+			break
+		}
+	}
+
+	dur := last.Sub(first)
+	fmt.Printf("Sent: %d bytes\n", atomic.LoadUint64(&sent))
+	fmt.Printf("Echoed: %d bytes in %.3f seconds (%s)\n", echoed, dur.Seconds(), rate(echoed, dur))
+	return testResult{bytes: echoed, duration: dur}
+}
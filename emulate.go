@@ -0,0 +1,247 @@
+package main
+
+import (
+	"math/rand"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// emulating reports whether any impairment of the outgoing packets is to
+// be emulated.
+func emulating() bool {
+	return emulateLoss.value > 0 || *emulateDelay > 0 || *emulateJitter > 0 || emulateRate > 0 || emulateReorder.value > 0
+}
+
+// logEmulation logs the impairments emulated, if any.
+func logEmulation() {
+	if emulateLoss.value > 0 {
+		log.Infof("Emulating loss of %g%% of the packets sent", emulateLoss.value)
+	}
+	if *emulateDelay > 0 || *emulateJitter > 0 {
+		log.Infof("Emulating a delay of %v ± %v on the packets sent", *emulateDelay, *emulateJitter)
+	}
+	if emulateRate > 0 {
+		log.Infof("Emulating a link of %s with a queue of %d bytes", formatRate(float64(emulateRate)), *emulateQueue)
+	}
+	if emulateReorder.value > 0 {
+		log.Infof("Emulating reordering of %g%% of the packets sent behind the %d packets after them", emulateReorder.value, *emulateReorderGap)
+	}
+}
+
+// emulatedConn is a net.PacketConn that impairs the packets written to
+// it before sending them, to exercise loss recovery and congestion
+// control on a clean lab path without netem or root privileges. Only
+// outgoing packets are affected, so both ends emulate their own
+// direction.
+//
+// Packets that are delayed are copied into a queue, which a goroutine
+// sends from as their time comes. They leave in the order they were
+// written, as on most real links, so jitter delays the packets behind a
+// late one rather than overtaking it.
+//
+// With -emulate-rate, packets first pass through a bottleneck link of
+// that rate, queueing for it behind the packets sent before them. Like a
+// router's tail-drop queue, a packet arriving when the bytes waiting
+// exceed -emulate-queue is dropped, so that a deep queue builds up delay
+// the way a bloated buffer does.
+//
+// With -emulate-reorder, a packet may instead be held back until
+// -emulate-reorder-gap packets sent after it have overtaken it. If
+// nothing more is sent it stays held, as if lost, until the sender's
+// probe timeout sends another packet.
+type emulatedConn struct {
+	net.PacketConn
+
+	mu       sync.Mutex
+	queue    []delayedPacket
+	last     time.Time // when the last packet queued is due
+	linkFree time.Time // when the emulated link is done sending
+	held     []heldPacket
+	wake     chan struct{}
+	closed   chan struct{}
+	once     sync.Once
+}
+
+// delayedPacket is a packet waiting in an emulatedConn's queue.
+type delayedPacket struct {
+	p    []byte
+	addr net.Addr
+	due  time.Time
+}
+
+// heldPacket is a packet held back for reordering until gap more
+// packets have been sent.
+type heldPacket struct {
+	delayedPacket
+	gap int
+}
+
+// emulated wraps c in an emulatedConn. Since quic-go writes to a
+// *net.UDPConn with calls of its own, c's batched reads and writes are
+// hidden from quic-go as by unbatched.
+func emulated(c net.PacketConn) net.PacketConn {
+	var udpConn *net.UDPConn
+	switch u := c.(type) {
+	case *net.UDPConn:
+		c, udpConn = unbatched(u), u
+	case unbatchedConn:
+		udpConn = u.udpConn
+	case filteredUDPConn:
+		udpConn = u.udpConn
+	}
+
+	e := &emulatedConn{
+		PacketConn: c,
+		wake:       make(chan struct{}, 1),
+		closed:     make(chan struct{}),
+	}
+	go e.sendDelayed()
+	if udpConn != nil {
+		return emulatedUDPConn{e, udpConn}
+	}
+	return e
+}
+
+// delay returns how long to hold a packet back: -emulate-delay, plus or
+// minus up to -emulate-jitter.
+func delay() time.Duration {
+	d := *emulateDelay
+	if *emulateJitter > 0 {
+		d += time.Duration((2*rand.Float64() - 1) * float64(*emulateJitter))
+	}
+	if d < 0 {
+		d = 0
+	}
+	return d
+}
+
+func (c *emulatedConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	if emulateLoss.value > 0 && rand.Float64()*100 < emulateLoss.value {
+		return len(p), nil
+	}
+	d := delay()
+
+	c.mu.Lock()
+	now := time.Now()
+	if emulateRate > 0 {
+		start := c.linkFree
+		if start.Before(now) {
+			start = now
+		}
+		backlog := start.Sub(now).Seconds() * float64(emulateRate) / 8
+		if backlog > 0 && backlog+float64(len(p)) > float64(*emulateQueue) {
+			c.mu.Unlock()
+			return len(p), nil
+		}
+		c.linkFree = start.Add(time.Duration(float64(len(p)) * 8 / float64(emulateRate) * float64(time.Second)))
+		d += c.linkFree.Sub(now)
+	}
+	if emulateReorder.value > 0 && rand.Float64()*100 < emulateReorder.value {
+		c.held = append(c.held, heldPacket{delayedPacket{p: append([]byte(nil), p...), addr: addr}, *emulateReorderGap})
+		c.mu.Unlock()
+		return len(p), nil
+	}
+	released := c.overtake()
+	if d == 0 && len(c.queue) == 0 {
+		c.mu.Unlock()
+		n, err := c.PacketConn.WriteTo(p, addr)
+		for _, pkt := range released {
+			c.PacketConn.WriteTo(pkt.p, pkt.addr)
+		}
+		return n, err
+	}
+	due := now.Add(d)
+	if due.Before(c.last) {
+		due = c.last
+	}
+	c.last = due
+	c.queue = append(c.queue, delayedPacket{p: append([]byte(nil), p...), addr: addr, due: due})
+	for _, pkt := range released {
+		pkt.due = due
+		c.queue = append(c.queue, pkt)
+	}
+	c.mu.Unlock()
+
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+	return len(p), nil
+}
+
+// overtake counts a packet sent past the packets held back, returning
+// those it was the last to overtake, which are to be sent right after it.
+func (c *emulatedConn) overtake() []delayedPacket {
+	var released []delayedPacket
+	held := c.held[:0]
+	for _, h := range c.held {
+		h.gap--
+		if h.gap > 0 {
+			held = append(held, h)
+		} else {
+			released = append(released, h.delayedPacket)
+		}
+	}
+	c.held = held
+	return released
+}
+
+// sendDelayed sends the queued packets when they are due, until the
+// connection is closed.
+func (c *emulatedConn) sendDelayed() {
+	t := time.NewTimer(time.Hour)
+	defer t.Stop()
+	for {
+		c.mu.Lock()
+		for len(c.queue) > 0 && !time.Now().Before(c.queue[0].due) {
+			pkt := c.queue[0]
+			c.queue = c.queue[1:]
+			c.mu.Unlock()
+			// As on a real link, a packet that can't be sent is
+			// lost.
+			c.PacketConn.WriteTo(pkt.p, pkt.addr)
+			c.mu.Lock()
+		}
+		next := time.Hour
+		if len(c.queue) > 0 {
+			next = time.Until(c.queue[0].due)
+		}
+		c.mu.Unlock()
+
+		if !t.Stop() {
+			select {
+			case <-t.C:
+			default:
+			}
+		}
+		t.Reset(next)
+		select {
+		case <-c.closed:
+			return
+		case <-c.wake:
+		case <-t.C:
+		}
+	}
+}
+
+func (c *emulatedConn) Close() error {
+	c.once.Do(func() { close(c.closed) })
+	return c.PacketConn.Close()
+}
+
+// emulatedUDPConn is an emulatedConn for a *net.UDPConn, which keeps
+// letting quic-go configure the socket.
+type emulatedUDPConn struct {
+	*emulatedConn
+	udpConn *net.UDPConn
+}
+
+func (c emulatedUDPConn) SyscallConn() (syscall.RawConn, error) {
+	return c.udpConn.SyscallConn()
+}
+
+func (c emulatedUDPConn) SetReadBuffer(bytes int) error {
+	return c.udpConn.SetReadBuffer(bytes)
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// envSysctls are the sysctls that bear on UDP throughput, read from
+// /proc/sys where there is one.
+var envSysctls = []string{
+	"net.core.rmem_default",
+	"net.core.rmem_max",
+	"net.core.wmem_default",
+	"net.core.wmem_max",
+	"net.core.netdev_max_backlog",
+	"net.ipv4.udp_mem",
+}
+
+// envOffloads are the ethtool features that change how UDP packets are
+// sent and received.
+var envOffloads = []string{
+	"rx-checksumming",
+	"tx-checksumming",
+	"generic-segmentation-offload",
+	"generic-receive-offload",
+	"tx-udp-segmentation",
+	"rx-udp-gro-forwarding",
+}
+
+// environment describes the host a test ran on, for comparing runs made
+// on different hosts.
+type environment struct {
+	OS        string            `json:"os"`
+	Arch      string            `json:"arch"`
+	Kernel    string            `json:"kernel,omitempty"`
+	CPU       string            `json:"cpu,omitempty"`
+	CPUs      int               `json:"cpus"`
+	GoVersion string            `json:"go_version"`
+	Sysctls   map[string]string `json:"sysctls,omitempty"`
+	Interface string            `json:"interface,omitempty"`
+	Offloads  map[string]string `json:"offloads,omitempty"`
+}
+
+// captureEnvironment describes this host and the interface packets to
+// raddr are sent from. What can't be found out, such as the sysctls on a
+// platform without /proc or the offloads without ethtool, is left out.
+func captureEnvironment(raddr net.Addr) *environment {
+	e := &environment{
+		OS:        runtime.GOOS,
+		Arch:      runtime.GOARCH,
+		CPUs:      runtime.NumCPU(),
+		GoVersion: runtime.Version(),
+	}
+	if b, err := os.ReadFile("/proc/sys/kernel/osrelease"); err == nil {
+		e.Kernel = strings.TrimSpace(string(b))
+	}
+	e.CPU = cpuModel()
+
+	for _, name := range envSysctls {
+		b, err := os.ReadFile(filepath.Join("/proc/sys", strings.ReplaceAll(name, ".", "/")))
+		if err != nil {
+			continue
+		}
+		if e.Sysctls == nil {
+			e.Sysctls = make(map[string]string)
+		}
+		e.Sysctls[name] = strings.Join(strings.Fields(string(b)), " ")
+	}
+
+	e.Interface = *bindDevice
+	if e.Interface == "" {
+		e.Interface, _ = interfaceTo(raddr)
+	}
+	if e.Interface != "" {
+		e.Offloads = offloads(e.Interface)
+	}
+	return e
+}
+
+// cpuModel returns the model name of the first CPU in /proc/cpuinfo, or
+// "" if there is none.
+func cpuModel() string {
+	f, err := os.Open("/proc/cpuinfo")
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		if k, v, ok := strings.Cut(sc.Text(), ":"); ok && strings.TrimSpace(k) == "model name" {
+			return strings.TrimSpace(v)
+		}
+	}
+	return ""
+}
+
+// offloads returns the state of the envOffloads features of iface as
+// reported by ethtool -k, or nil if ethtool can't be run.
+func offloads(iface string) map[string]string {
+	out, err := exec.Command("ethtool", "-k", iface).Output()
+	if err != nil {
+		return nil
+	}
+	m := make(map[string]string)
+	for _, line := range strings.Split(string(out), "\n") {
+		k, v, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		k = strings.TrimSpace(k)
+		for _, name := range envOffloads {
+			if k == name {
+				m[k] = strings.TrimSpace(v)
+			}
+		}
+	}
+	return m
+}
+
+// print prints the environment on one line per kind of detail.
+func (e *environment) print() {
+	fmt.Printf("Environment: %s/%s, kernel %s, %d CPUs (%s), %s\n",
+		e.OS, e.Arch, orUnknown(e.Kernel), e.CPUs, orUnknown(e.CPU), e.GoVersion)
+	if len(e.Sysctls) > 0 {
+		fmt.Printf("Sysctls: %s\n", joinSorted(e.Sysctls))
+	}
+	if len(e.Offloads) > 0 {
+		fmt.Printf("Offloads of %s: %s\n", e.Interface, joinSorted(e.Offloads))
+	}
+}
+
+// orUnknown returns s, or "unknown" if it is empty.
+func orUnknown(s string) string {
+	if s == "" {
+		return "unknown"
+	}
+	return s
+}
+
+// joinSorted returns the key=value pairs of m in order of key.
+func joinSorted(m map[string]string) string {
+	var s []string
+	for k, v := range m {
+		s = append(s, k+"="+v)
+	}
+	sort.Strings(s)
+	return strings.Join(s, ", ")
+}
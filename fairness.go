@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+// fairnessInterval is how often the shares of the connections are
+// reported.
+const fairnessInterval = time.Second
+
+// runConnections receives the bulk transfer on conn and on -connections-1
+// more connections made with dialAnother, all at once, for the duration of
+// the test. Every fairnessInterval it prints each connection's share of
+// the data received and Jain's fairness index, and at the end the same for
+// the whole test.
+func runConnections(ctx context.Context, conn quic.Connection, dialAnother func() (quic.Connection, error)) testResult {
+	conns := []quic.Connection{conn}
+	for len(conns) < *connections {
+		c, err := dialAnother()
+		if err != nil {
+			log.Exitf("Fatal error establishing connection %d: %v", len(conns)+1, err)
+		}
+		defer c.CloseWithError(quic.ApplicationErrorCode(quic.NoError), "done")
+		conns = append(conns, c)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(*durationInSecs)*time.Second)
+	defer cancel()
+
+	var (
+		received = make([]uint64, len(conns)) // accessed atomically
+		wg       sync.WaitGroup
+	)
+	start := time.Now()
+	for i, c := range conns {
+		wg.Add(1)
+		go func(c quic.Connection, n *uint64) {
+			defer wg.Done()
+			if err := receiveBulk(ctx, c, n); err != nil && ctx.Err() == nil {
+				log.forConn(c).Errorf("Error reading from stream: %v", err)
+			}
+		}(c, &received[i])
+	}
+
+	prev := make([]uint64, len(conns))
+	t := time.NewTicker(fairnessInterval)
+loop:
+	for interval := 1; ; interval++ {
+		select {
+		case <-ctx.Done():
+			break loop
+		case <-t.C:
+		}
+
+		delta := make([]uint64, len(conns))
+		for i := range received {
+			cur := atomic.LoadUint64(&received[i])
+			delta[i] = cur - prev[i]
+			prev[i] = cur
+		}
+		fmt.Printf("Interval %d: %s\n", interval, fairnessReport(delta))
+	}
+	t.Stop()
+	wg.Wait()
+	dur := time.Since(start)
+
+	var total uint64
+	for i, n := range received {
+		total += n
+		fmt.Printf("Connection %d: %d bytes (%s)\n", i+1, n, rate(n, dur))
+	}
+	fmt.Printf("Received: %d bytes on %d connections in %.3f seconds (%s), %s\n",
+		total, len(conns), dur.Seconds(), rate(total, dur), fairnessReport(received))
+
+	return testResult{bytes: total, duration: dur}
+}
+
+// receiveBulk reads the bulk transfer stream on conn until ctx is done,
+// adding the number of bytes read to *n atomically.
+func receiveBulk(ctx context.Context, conn quic.Connection, n *uint64) error {
+	s, err := conn.AcceptUniStream(ctx)
+	if err != nil {
+		return err
+	}
+	defer s.CancelRead(quic.StreamErrorCode(quic.NoError))
+	if deadline, ok := ctx.Deadline(); ok {
+		s.SetReadDeadline(deadline)
+	}
+
+	var buf [readChunkSize]byte
+	for {
+		i, err := s.Read(buf[:])
+		atomic.AddUint64(n, uint64(i))
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			if e, ok := err.(net.Error); ok && e.Timeout() {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+// fairnessReport describes each flow's share of the bytes in flows, and
+// their Jain's fairness index.
+func fairnessReport(flows []uint64) string {
+	var total uint64
+	for _, n := range flows {
+		total += n
+	}
+	shares := make([]string, len(flows))
+	for i, n := range flows {
+		share := 0.0
+		if total > 0 {
+			share = float64(n) / float64(total) * 100
+		}
+		shares[i] = fmt.Sprintf("%.1f%%", share)
+	}
+	return fmt.Sprintf("shares %s, Jain's fairness index %.3f", strings.Join(shares, " "), jainIndex(flows))
+}
+
+// jainIndex returns Jain's fairness index of the throughputs in flows,
+// which ranges from 1/len(flows) when one flow gets everything to 1 when
+// they all get the same. It's 1 if nothing was received at all.
+func jainIndex(flows []uint64) float64 {
+	var sum, sumSquares float64
+	for _, n := range flows {
+		sum += float64(n)
+		sumSquares += float64(n) * float64(n)
+	}
+	if sumSquares == 0 {
+		return 1
+	}
+	return sum * sum / (float64(len(flows)) * sumSquares)
+}
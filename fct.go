@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+// runFCT requests objects of each of the -fct sizes in turn, one at a
+// time, for the duration of the test, then prints the distribution of
+// their completion times for each size: from opening the request's stream
+// to reading the last byte of the object.
+func runFCT(ctx context.Context, conn quic.Connection) testResult {
+	cancelBulkStream(ctx, conn)
+
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(*durationInSecs)*time.Second)
+	defer cancel()
+
+	times := make([]*histogram, len(fctSizes))
+	reqs := make([][]byte, len(fctSizes))
+	for i, size := range fctSizes {
+		times[i] = newHistogram()
+		reqs[i] = newRPCRequest(*rpcRequestSize, int(size))
+	}
+
+	var (
+		received uint64
+		busy     time.Duration
+	)
+	for ctx.Err() == nil {
+		for i := range fctSizes {
+			t := time.Now()
+			n, err := doRPC(ctx, conn, reqs[i])
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Errorf("Error requesting a %d byte object from %s: %v", fctSizes[i], conn.RemoteAddr(), err)
+					cancel()
+				}
+				break
+			}
+			d := time.Since(t)
+			times[i].record(d)
+			received += n
+			busy += d
+		}
+	}
+
+	for i, size := range fctSizes {
+		h := times[i]
+		if h.count() == 0 {
+			fmt.Printf("%d bytes: no objects completed\n", size)
+			continue
+		}
+		mean, _ := h.mean()
+		fmt.Printf("%d bytes: %d objects, completion p50 %.3f ms, p90 %.3f ms, p99 %.3f ms, max %.3f ms, mean %.3f ms (%s)\n",
+			size, h.count(),
+			float64(h.percentile(50))/1e6,
+			float64(h.percentile(90))/1e6,
+			float64(h.percentile(99))/1e6,
+			float64(h.percentile(100))/1e6,
+			mean/1e3,
+			rate(size, time.Duration(mean*1e3)))
+	}
+	fmt.Printf("Received: %d bytes, %.3f seconds busy (%s while busy)\n", received, busy.Seconds(), rate(received, busy))
+	return testResult{bytes: received, duration: busy}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"math/bits"
+	"os"
+	"time"
+)
+
+// histogram is a high dynamic range histogram of latencies, recorded in
+// microseconds with three significant digits of precision, as in
+// HdrHistogram. Values below subBuckets are counted exactly; above that,
+// each power of two is split into subBuckets/2 equal parts.
+type histogram struct {
+	counts []uint64
+	total  uint64
+	max    int64
+	sum    float64
+	sumSq  float64
+}
+
+// subBuckets is the smallest power of two above 2 * 10^3, which keeps the
+// width of every bucket below 0.1% of the values in it.
+const subBuckets = 2048
+
+func newHistogram() *histogram {
+	return &histogram{counts: make([]uint64, subBuckets)}
+}
+
+// bucketIndex returns the index of the bucket counting v.
+func bucketIndex(v int64) int {
+	if v < subBuckets {
+		return int(v)
+	}
+	shift := bits.Len64(uint64(v)) - bits.Len64(subBuckets-1)
+	return subBuckets + (shift-1)*subBuckets/2 + int(v>>shift) - subBuckets/2
+}
+
+// bucketRange returns the lowest and highest values counted in the bucket
+// at index i.
+func bucketRange(i int) (lowest, highest int64) {
+	if i < subBuckets {
+		return int64(i), int64(i)
+	}
+	shift := (i-subBuckets)/(subBuckets/2) + 1
+	sub := int64((i-subBuckets)%(subBuckets/2) + subBuckets/2)
+	return sub << shift, (sub+1)<<shift - 1
+}
+
+// record adds a latency to the histogram.
+func (h *histogram) record(d time.Duration) {
+	v := d.Microseconds()
+	if v < 0 {
+		v = 0
+	}
+	i := bucketIndex(v)
+	for i >= len(h.counts) {
+		h.counts = append(h.counts, make([]uint64, subBuckets/2)...)
+	}
+	h.counts[i]++
+
+	if v > h.max {
+		h.max = v
+	}
+	h.total++
+	h.sum += float64(v)
+	h.sumSq += float64(v) * float64(v)
+}
+
+// count returns the number of latencies recorded.
+func (h *histogram) count() uint64 {
+	return h.total
+}
+
+// percentile returns the latency at or below which p percent of those
+// recorded fall, to within the histogram's precision.
+func (h *histogram) percentile(p float64) time.Duration {
+	if h.total == 0 {
+		return 0
+	}
+	want := uint64(math.Ceil(p / 100 * float64(h.total)))
+	if want == 0 {
+		want = 1
+	}
+	var n uint64
+	for i, c := range h.counts {
+		n += c
+		if n >= want {
+			_, v := bucketRange(i)
+			if v > h.max {
+				v = h.max
+			}
+			return time.Duration(v) * time.Microsecond
+		}
+	}
+	return time.Duration(h.max) * time.Microsecond
+}
+
+// mean returns the mean and standard deviation of the latencies, in
+// microseconds.
+func (h *histogram) mean() (mean, stddev float64) {
+	if h.total == 0 {
+		return 0, 0
+	}
+	n := float64(h.total)
+	mean = h.sum / n
+	return mean, math.Sqrt(math.Max(h.sumSq/n-mean*mean, 0))
+}
+
+// hgrmTicksPerHalfDistance is how many percentiles are written for every
+// halving of the distance to 100%, as in HdrHistogram's default output.
+const hgrmTicksPerHalfDistance = 5
+
+// writeHgrm writes the percentile distribution of the histogram in the
+// .hgrm text format written by HdrHistogram's
+// outputPercentileDistribution, with values in milliseconds, so that it
+// can be plotted with the HdrHistogram tools.
+func (h *histogram) writeHgrm(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "%12s %14s %10s %14s\n\n", "Value", "Percentile", "TotalCount", "1/(1-Percentile)")
+
+	level := 0.0
+	var n uint64
+	for i := 0; i < len(h.counts) && h.total > 0; i++ {
+		n += h.counts[i]
+		if h.counts[i] == 0 {
+			continue
+		}
+		_, v := bucketRange(i)
+		if v > h.max {
+			v = h.max
+		}
+		ms := float64(v) / 1e3
+		for 100*float64(n)/float64(h.total) >= level {
+			if n == h.total {
+				fmt.Fprintf(bw, "%12.3f %2.12f %10d\n", ms, 1.0, n)
+				break
+			}
+			fmt.Fprintf(bw, "%12.3f %2.12f %10d %14.2f\n", ms, level/100, n, 1/(1-level/100))
+			ticks := hgrmTicksPerHalfDistance * math.Pow(2, math.Floor(math.Log2(100/(100-level)))+1)
+			level += 100 / ticks
+		}
+	}
+
+	mean, stddev := h.mean()
+	fmt.Fprintf(bw, "#[Mean    = %12.3f, StdDeviation   = %12.3f]\n", mean/1e3, stddev/1e3)
+	fmt.Fprintf(bw, "#[Max     = %12.3f, Total count    = %12d]\n", float64(h.max)/1e3, h.total)
+	fmt.Fprintf(bw, "#[Buckets = %12d, SubBuckets     = %12d]\n",
+		(len(h.counts)-subBuckets)/(subBuckets/2)+1, subBuckets)
+	return bw.Flush()
+}
+
+// reportLatencies prints the percentiles of the latencies recorded in h
+// and, with -hgrm, writes their full distribution to a file.
+func reportLatencies(h *histogram) {
+	if h.count() == 0 {
+		return
+	}
+	fmt.Printf("Latency: p50 %.3f ms, p90 %.3f ms, p99 %.3f ms, p99.9 %.3f ms, p99.99 %.3f ms, max %.3f ms\n",
+		float64(h.percentile(50))/1e6,
+		float64(h.percentile(90))/1e6,
+		float64(h.percentile(99))/1e6,
+		float64(h.percentile(99.9))/1e6,
+		float64(h.percentile(99.99))/1e6,
+		float64(h.percentile(100))/1e6)
+
+	if *hgrmFile == "" {
+		return
+	}
+	f, err := os.Create(*hgrmFile)
+	if err != nil {
+		log.Exitf("Fatal error creating %s: %v", *hgrmFile, err)
+	}
+	if err := h.writeHgrm(f); err != nil {
+		f.Close()
+		log.Exitf("Fatal error writing %s: %v", *hgrmFile, err)
+	}
+	if err := f.Close(); err != nil {
+		log.Exitf("Fatal error writing %s: %v", *hgrmFile, err)
+	}
+	fmt.Printf("Wrote the latency distribution to %s\n", *hgrmFile)
+}
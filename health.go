@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync/atomic"
+)
+
+// listenersUp is set to 1, atomically, once the QUIC listeners of the
+// server or relay are listening.
+var listenersUp int32
+
+// startHealth starts answering health checks on -health-addr, if set.
+// Any path is answered, with 503 until the QUIC listeners are up and 200
+// after that, so that container probes can supervise a long-running
+// server.
+func startHealth() {
+	if *healthAddr == "" {
+		return
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&listenersUp) == 0 {
+			http.Error(w, "not listening yet", http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+	go func() {
+		log.Exitf("Fatal error serving health checks on %s: %v", *healthAddr, http.ListenAndServe(*healthAddr, mux))
+	}()
+}
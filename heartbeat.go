@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// heartbeatsMissed is the number of heartbeat periods without a packet
+// from the server after which the path is taken to be down. Every
+// heartbeat is a PING the server must acknowledge, so on a working path
+// something arrives within a period and a round trip.
+const heartbeatsMissed = 3
+
+// pathWatcher tells a dead path from a quiet one during tests that send
+// little, by watching for the acknowledgments of the heartbeats quic-go
+// sends every -heartbeat.
+type pathWatcher struct {
+	stats  *connStats
+	period time.Duration
+
+	mu       sync.Mutex
+	outages  int
+	longest  time.Duration
+	downFrom time.Time // zero while the path is up
+}
+
+func newPathWatcher(stats *connStats, period time.Duration) *pathWatcher {
+	return &pathWatcher{stats: stats, period: period}
+}
+
+// run checks the path every heartbeat period until ctx is done, logging
+// when it goes down and comes back.
+func (w *pathWatcher) run(ctx context.Context) {
+	t := time.NewTicker(w.period)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+
+		silent := w.stats.sinceReceived()
+		w.mu.Lock()
+		switch down := !w.downFrom.IsZero(); {
+		case !down && silent > heartbeatsMissed*w.period:
+			w.downFrom = time.Now().Add(-silent)
+			w.outages++
+			log.Warningf("Nothing heard from %s for %.1f seconds despite heartbeats; the path may be down", *client, silent.Seconds())
+		case down && silent < w.period:
+			d := time.Since(w.downFrom) - silent
+			if d > w.longest {
+				w.longest = d
+			}
+			w.downFrom = time.Time{}
+			log.Infof("Heard from %s again after %.1f seconds of silence", *client, d.Seconds())
+		}
+		w.mu.Unlock()
+	}
+}
+
+// report prints how often and for how long the path was down.
+func (w *pathWatcher) report() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	longest := w.longest
+	if !w.downFrom.IsZero() {
+		if d := time.Since(w.downFrom); d > longest {
+			longest = d
+		}
+	}
+	if w.outages == 0 {
+		fmt.Printf("Path: never silent for %d heartbeats (every %v)\n", heartbeatsMissed, w.period)
+		return
+	}
+	fmt.Printf("Path: down %d times, for up to %.1f seconds, judging by %v heartbeats\n",
+		w.outages, longest.Seconds(), w.period)
+}
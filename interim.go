@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// interimReporters print the counters of the tests in progress in this
+// process when it gets a SIGUSR1.
+var interimReporters = struct {
+	sync.Mutex
+	m    map[int]func()
+	next int
+}{m: make(map[int]func())}
+
+// onInterim adds f to the reporters run on a SIGUSR1 until the returned
+// function is called.
+func onInterim(f func()) (remove func()) {
+	interimReporters.Lock()
+	defer interimReporters.Unlock()
+
+	id := interimReporters.next
+	interimReporters.next++
+	interimReporters.m[id] = f
+	return func() {
+		interimReporters.Lock()
+		defer interimReporters.Unlock()
+
+		delete(interimReporters.m, id)
+	}
+}
+
+// reportInterim prints the counters of the client's transfer and of the
+// server's bulk transfers in progress, without disturbing them.
+func reportInterim() {
+	interimReporters.Lock()
+	fs := make([]func(), 0, len(interimReporters.m))
+	for _, f := range interimReporters.m {
+		fs = append(fs, f)
+	}
+	interimReporters.Unlock()
+
+	for _, f := range fs {
+		f()
+	}
+	reportServerInterim()
+}
+
+// interimCounter counts the bytes the client has received for
+// reportInterim, which reads it from another goroutine.
+type interimCounter struct {
+	received uint64 // accessed atomically
+}
+
+func (c *interimCounter) set(n uint64) {
+	atomic.StoreUint64(&c.received, n)
+}
+
+// report prints the bytes received since start, their rate, the smoothed
+// RTT and the estimated loss so far.
+func (c *interimCounter) report(stats *connStats, start time.Time) {
+	n := atomic.LoadUint64(&c.received)
+	d := time.Since(start)
+	srtt, _ := stats.rtt()
+	loss, _, _ := stats.receiveLoss()
+	fmt.Printf("Interim: %d bytes in %.3f seconds (%s), smoothed RTT %.3f ms, estimated loss %.3f%%\n",
+		n, d.Seconds(), rate(n, d), float64(srtt)/1e6, loss*100)
+}
+
+// reportServerInterim logs what the server has sent so far on each bulk
+// transfer in progress.
+func reportServerInterim() {
+	serverConns.Lock()
+	conns := make([]*serverConn, 0, len(serverConns.m))
+	for _, sc := range serverConns.m {
+		conns = append(conns, sc)
+	}
+	serverConns.Unlock()
+
+	for _, sc := range conns {
+		sc.mu.Lock()
+		sent, start, remote, sending := sc.sent, sc.start, sc.remote, sc.sending
+		sc.mu.Unlock()
+		if !sending {
+			continue
+		}
+
+		n, d := sent.bytes(), time.Since(start)
+		srtt, _ := sc.stats.rtt()
+		sc.stats.mu.Lock()
+		packets := sc.stats.spaces[spaceAppData].sent
+		lost := sc.stats.spaces[spaceAppData].lost
+		sc.stats.mu.Unlock()
+		loss := 0.0
+		if packets > 0 {
+			loss = float64(lost) / float64(packets) * 100
+		}
+		log.with("remote", remote).Infof(
+			"Interim for %s: sent %d bytes in %.3f seconds (%s), smoothed RTT %.3f ms, %d of %d packets declared lost (%.3f%%)",
+			remote, n, d.Seconds(), rate(n, d), float64(srtt)/1e6, lost, packets, loss)
+	}
+}
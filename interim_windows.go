@@ -0,0 +1,6 @@
+//go:build windows
+
+package main
+
+// watchInterim does nothing on Windows, which has no SIGUSR1.
+func watchInterim() {}
@@ -0,0 +1,391 @@
+//go:build linux
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// The parts of the io_uring interface in linux/io_uring.h that we use.
+// golang.org/x/sys/unix only has the system call numbers.
+const (
+	ioringOffSQRing = 0
+	ioringOffCQRing = 0x8000000
+	ioringOffSQEs   = 0x10000000
+
+	ioringOpSendmsg = 9
+	ioringOpRecvmsg = 10
+
+	ioringEnterGetevents = 1
+)
+
+type ioSQRingOffsets struct {
+	head, tail, ringMask, ringEntries, flags, dropped, array, resv1 uint32
+	resv2                                                           uint64
+}
+
+type ioCQRingOffsets struct {
+	head, tail, ringMask, ringEntries, overflow, cqes, flags, resv1 uint32
+	resv2                                                           uint64
+}
+
+type ioUringParams struct {
+	sqEntries, cqEntries, flags, sqThreadCPU, sqThreadIdle, features, wqFD uint32
+	resv                                                                   [3]uint32
+	sqOff                                                                  ioSQRingOffsets
+	cqOff                                                                  ioCQRingOffsets
+}
+
+type ioUringSQE struct {
+	opcode      uint8
+	flags       uint8
+	ioprio      uint16
+	fd          int32
+	off         uint64
+	addr        uint64
+	len         uint32
+	msgFlags    uint32
+	userData    uint64
+	bufIndex    uint16
+	personality uint16
+	spliceFDIn  int32
+	addr3       uint64
+	_           uint64
+}
+
+type ioUringCQE struct {
+	userData uint64
+	res      int32
+	flags    uint32
+}
+
+// ioRing is an io_uring instance used for one operation at a time, by
+// whoever holds mu. The rings are tiny, since we never have more than one
+// submission in flight.
+type ioRing struct {
+	mu sync.Mutex
+	fd int
+
+	sqMem, cqMem, sqeMem []byte
+
+	sqHead, sqTail, sqMask *uint32
+	sqArray                *uint32
+	sqes                   *ioUringSQE
+	cqHead, cqTail, cqMask *uint32
+	cqes                   *ioUringCQE
+
+	// The message of the operation in flight. The kernel may read and
+	// write it at any time until the operation completes, so it lives
+	// here on the heap where it won't move.
+	msg  unix.Msghdr
+	iov  unix.Iovec
+	name [unix.SizeofSockaddrAny]byte
+}
+
+const ioRingEntries = 4
+
+func newIORing() (*ioRing, error) {
+	var p ioUringParams
+	fd, _, errno := unix.Syscall(unix.SYS_IO_URING_SETUP, ioRingEntries, uintptr(unsafe.Pointer(&p)), 0)
+	if errno != 0 {
+		return nil, fmt.Errorf("io_uring_setup: %w", errno)
+	}
+	r := &ioRing{fd: int(fd)}
+
+	mmap := func(off int64, size int) ([]byte, error) {
+		return unix.Mmap(r.fd, off, size, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED|unix.MAP_POPULATE)
+	}
+	var err error
+	if r.sqMem, err = mmap(ioringOffSQRing, int(p.sqOff.array+p.sqEntries*4)); err != nil {
+		r.close()
+		return nil, err
+	}
+	if r.cqMem, err = mmap(ioringOffCQRing, int(p.cqOff.cqes+p.cqEntries*uint32(unsafe.Sizeof(ioUringCQE{})))); err != nil {
+		r.close()
+		return nil, err
+	}
+	if r.sqeMem, err = mmap(ioringOffSQEs, int(p.sqEntries*uint32(unsafe.Sizeof(ioUringSQE{})))); err != nil {
+		r.close()
+		return nil, err
+	}
+
+	u32 := func(mem []byte, off uint32) *uint32 { return (*uint32)(unsafe.Pointer(&mem[off])) }
+	r.sqHead, r.sqTail, r.sqMask = u32(r.sqMem, p.sqOff.head), u32(r.sqMem, p.sqOff.tail), u32(r.sqMem, p.sqOff.ringMask)
+	r.sqArray = u32(r.sqMem, p.sqOff.array)
+	r.sqes = (*ioUringSQE)(unsafe.Pointer(&r.sqeMem[0]))
+	r.cqHead, r.cqTail, r.cqMask = u32(r.cqMem, p.cqOff.head), u32(r.cqMem, p.cqOff.tail), u32(r.cqMem, p.cqOff.ringMask)
+	r.cqes = (*ioUringCQE)(unsafe.Pointer(&r.cqMem[p.cqOff.cqes]))
+	return r, nil
+}
+
+// do sends p to the address in name, or receives into p and name,
+// depending on opcode, on the socket fd, and waits for the operation to
+// complete. It returns its result and the length of the address. r.mu
+// must be held.
+func (r *ioRing) do(opcode uint8, fd int, p, name []byte) (n, nameLen int, err error) {
+	r.iov = unix.Iovec{}
+	if len(p) > 0 {
+		r.iov.Base = &p[0]
+		r.iov.SetLen(len(p))
+	}
+	r.msg = unix.Msghdr{Name: &r.name[0], Iov: &r.iov}
+	r.msg.Namelen = uint32(copy(r.name[:], name))
+	if opcode == ioringOpRecvmsg {
+		r.msg.Namelen = uint32(len(r.name))
+	}
+	r.msg.SetIovlen(1)
+
+	tail := atomic.LoadUint32(r.sqTail)
+	i := tail & *r.sqMask
+	sqe := (*ioUringSQE)(unsafe.Add(unsafe.Pointer(r.sqes), uintptr(i)*unsafe.Sizeof(ioUringSQE{})))
+	*sqe = ioUringSQE{
+		opcode: opcode,
+		fd:     int32(fd),
+		addr:   uint64(uintptr(unsafe.Pointer(&r.msg))),
+		len:    1,
+	}
+	*(*uint32)(unsafe.Add(unsafe.Pointer(r.sqArray), uintptr(i)*4)) = i
+	atomic.StoreUint32(r.sqTail, tail+1)
+	defer runtime.KeepAlive(p)
+
+	for {
+		head := atomic.LoadUint32(r.cqHead)
+		if head != atomic.LoadUint32(r.cqTail) {
+			cqe := (*ioUringCQE)(unsafe.Add(unsafe.Pointer(r.cqes), uintptr(head&*r.cqMask)*unsafe.Sizeof(ioUringCQE{})))
+			res := cqe.res
+			atomic.StoreUint32(r.cqHead, head+1)
+			if res < 0 {
+				return 0, 0, unix.Errno(-res)
+			}
+			return int(res), int(r.msg.Namelen), nil
+		}
+
+		// Asking to submit an entry that has already been submitted
+		// is harmless: the kernel only submits what is in the ring.
+		_, _, errno := unix.Syscall6(unix.SYS_IO_URING_ENTER, uintptr(r.fd), 1, 1, ioringEnterGetevents, 0, 0)
+		if errno != 0 && errno != unix.EINTR && errno != unix.EAGAIN {
+			return 0, 0, fmt.Errorf("io_uring_enter: %w", errno)
+		}
+	}
+}
+
+func (r *ioRing) close() {
+	for _, mem := range [][]byte{r.sqMem, r.cqMem, r.sqeMem} {
+		if mem != nil {
+			unix.Munmap(mem)
+		}
+	}
+	unix.Close(r.fd)
+}
+
+// ioUringConn is an experimental net.PacketConn that sends and receives
+// datagrams with io_uring rather than with system calls of their own. It
+// uses one ring for receiving and another for sending, each with a single
+// operation in flight, so the number of times the kernel is entered is
+// the same as with sendmsg and recvmsg; what differs is the interface.
+type ioUringConn struct {
+	fd    int
+	laddr *net.UDPAddr
+	ipv6  bool
+
+	recv, send *ioRing
+	closed     uint32 // accessed atomically
+}
+
+// listenIOUring opens a UDP socket on address for use with io_uring.
+// Unlike the sockets from package net it is blocking, since io_uring
+// waits for the socket to become ready itself.
+func listenIOUring(address string) (net.PacketConn, error) {
+	laddr, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &ioUringConn{ipv6: laddr.IP == nil || laddr.IP.To4() == nil}
+	if c.ipv6 {
+		c.fd, err = unix.Socket(unix.AF_INET6, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, 0)
+		if err == nil && laddr.IP == nil {
+			if err = unix.SetsockoptInt(c.fd, unix.IPPROTO_IPV6, unix.IPV6_V6ONLY, 0); err != nil {
+				unix.Close(c.fd)
+			}
+		}
+	} else {
+		c.fd, err = unix.Socket(unix.AF_INET, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, 0)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := unix.Bind(c.fd, c.unixSockaddr(laddr)); err != nil {
+		unix.Close(c.fd)
+		return nil, err
+	}
+	bound, err := unix.Getsockname(c.fd)
+	if err != nil {
+		unix.Close(c.fd)
+		return nil, err
+	}
+	switch bound := bound.(type) {
+	case *unix.SockaddrInet6:
+		c.laddr = &net.UDPAddr{IP: net.IP(bound.Addr[:]), Port: bound.Port}
+	case *unix.SockaddrInet4:
+		c.laddr = &net.UDPAddr{IP: net.IP(bound.Addr[:]), Port: bound.Port}
+	}
+
+	if c.recv, err = newIORing(); err != nil {
+		unix.Close(c.fd)
+		return nil, err
+	}
+	if c.send, err = newIORing(); err != nil {
+		c.recv.close()
+		unix.Close(c.fd)
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *ioUringConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	c.recv.mu.Lock()
+	defer c.recv.mu.Unlock()
+
+	for {
+		if atomic.LoadUint32(&c.closed) != 0 {
+			return 0, nil, net.ErrClosed
+		}
+		n, nameLen, err := c.recv.do(ioringOpRecvmsg, c.fd, p, nil)
+		if err != nil {
+			return 0, nil, &net.OpError{Op: "read", Net: "udp", Addr: c.laddr, Err: err}
+		}
+		// Close wakes us up with an empty datagram.
+		if atomic.LoadUint32(&c.closed) != 0 {
+			return 0, nil, net.ErrClosed
+		}
+		if addr := sockaddrToUDPAddr(c.recv.name[:nameLen]); addr != nil {
+			return n, addr, nil
+		}
+	}
+}
+
+func (c *ioUringConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	if atomic.LoadUint32(&c.closed) != 0 {
+		return 0, net.ErrClosed
+	}
+	uaddr, ok := addr.(*net.UDPAddr)
+	if !ok {
+		return 0, fmt.Errorf("unsupported address type %T", addr)
+	}
+	name, err := c.sockaddr(uaddr)
+	if err != nil {
+		return 0, err
+	}
+
+	c.send.mu.Lock()
+	defer c.send.mu.Unlock()
+
+	n, _, err := c.send.do(ioringOpSendmsg, c.fd, p, name)
+	if err != nil {
+		return 0, &net.OpError{Op: "write", Net: "udp", Addr: addr, Err: err}
+	}
+	return n, nil
+}
+
+// sockaddr returns addr in the form the kernel expects for the socket's
+// address family, mapping IPv4 addresses into IPv6 for IPv6 sockets.
+func (c *ioUringConn) sockaddr(addr *net.UDPAddr) ([]byte, error) {
+	port := [2]byte{byte(addr.Port >> 8), byte(addr.Port)}
+	if c.ipv6 {
+		var sa unix.RawSockaddrInet6
+		sa.Family = unix.AF_INET6
+		*(*[2]byte)(unsafe.Pointer(&sa.Port)) = port
+		copy(sa.Addr[:], addr.IP.To16())
+		return (*[unix.SizeofSockaddrInet6]byte)(unsafe.Pointer(&sa))[:], nil
+	}
+
+	ip := addr.IP.To4()
+	if ip == nil {
+		return nil, fmt.Errorf("can't send to %s from IPv4 address %s", addr, c.laddr)
+	}
+	var sa unix.RawSockaddrInet4
+	sa.Family = unix.AF_INET
+	*(*[2]byte)(unsafe.Pointer(&sa.Port)) = port
+	copy(sa.Addr[:], ip)
+	return (*[unix.SizeofSockaddrInet4]byte)(unsafe.Pointer(&sa))[:], nil
+}
+
+// unixSockaddr returns addr in the form package unix takes for the
+// socket's address family.
+func (c *ioUringConn) unixSockaddr(addr *net.UDPAddr) unix.Sockaddr {
+	if c.ipv6 {
+		sa := &unix.SockaddrInet6{Port: addr.Port}
+		copy(sa.Addr[:], addr.IP.To16())
+		return sa
+	}
+	sa := &unix.SockaddrInet4{Port: addr.Port}
+	copy(sa.Addr[:], addr.IP.To4())
+	return sa
+}
+
+// sockaddrToUDPAddr converts an address filled in by the kernel, returning
+// IPv4 addresses mapped into IPv6 as plain IPv4 addresses.
+func sockaddrToUDPAddr(b []byte) *net.UDPAddr {
+	if len(b) < 4 {
+		return nil
+	}
+	port := int(b[2])<<8 | int(b[3])
+	switch family := *(*uint16)(unsafe.Pointer(&b[0])); {
+	case family == unix.AF_INET6 && len(b) >= unix.SizeofSockaddrInet6:
+		sa := (*unix.RawSockaddrInet6)(unsafe.Pointer(&b[0]))
+		ip := net.IP(append([]byte(nil), sa.Addr[:]...))
+		if ip4 := ip.To4(); ip4 != nil {
+			ip = ip4
+		}
+		return &net.UDPAddr{IP: ip, Port: port}
+	case family == unix.AF_INET && len(b) >= unix.SizeofSockaddrInet4:
+		sa := (*unix.RawSockaddrInet4)(unsafe.Pointer(&b[0]))
+		return &net.UDPAddr{IP: net.IP(append([]byte(nil), sa.Addr[:]...)), Port: port}
+	}
+	return nil
+}
+
+func (c *ioUringConn) Close() error {
+	if !atomic.CompareAndSwapUint32(&c.closed, 0, 1) {
+		return net.ErrClosed
+	}
+
+	// A receive waiting in the kernel isn't woken by closing the
+	// socket, so send it an empty datagram.
+	wake := *c.laddr
+	if wake.IP.IsUnspecified() {
+		wake.IP = net.IPv6loopback
+		if !c.ipv6 {
+			wake.IP = net.IPv4(127, 0, 0, 1)
+		}
+	}
+	unix.Sendto(c.fd, nil, 0, c.unixSockaddr(&wake))
+
+	c.recv.mu.Lock()
+	c.send.mu.Lock()
+	c.recv.close()
+	c.send.close()
+	c.recv.mu.Unlock()
+	c.send.mu.Unlock()
+	return unix.Close(c.fd)
+}
+
+func (c *ioUringConn) LocalAddr() net.Addr { return c.laddr }
+
+// Deadlines aren't supported; quic-go doesn't set any on the connections
+// it's given.
+func (c *ioUringConn) SetDeadline(time.Time) error      { return errIOUringDeadline }
+func (c *ioUringConn) SetReadDeadline(time.Time) error  { return errIOUringDeadline }
+func (c *ioUringConn) SetWriteDeadline(time.Time) error { return errIOUringDeadline }
+
+var errIOUringDeadline = errors.New("deadlines aren't supported with the io_uring backend")
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"net"
+)
+
+// listenIOUring isn't supported on this platform.
+func listenIOUring(address string) (net.PacketConn, error) {
+	return nil, errors.New("the io_uring backend is only supported on Linux")
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+// A latency probe is a datagram made up of the phase of the test it was
+// sent in, a big-endian 64 bit sequence number, and the big-endian time it
+// was sent, in nanoseconds since the prober started. The server echoes
+// probes back unchanged.
+const probeLen = 1 + 8 + 8
+
+// A timed probe, sent for -one-way, has room for another big-endian 64
+// bit time after that. The server fills it in with the wall clock time it
+// received the probe, in nanoseconds since the Unix epoch.
+const timedProbeLen = probeLen + 8
+
+// idleProbes is the number of probes sent to measure the idle RTT.
+const idleProbes = 10
+
+const (
+	phaseIdle = iota
+	phaseLoaded
+	numPhases
+)
+
+// latencyProber measures round trip times using datagrams echoed by the
+// server.
+type latencyProber struct {
+	conn     quic.Connection
+	interval time.Duration
+	start    time.Time
+	oneWay   bool
+
+	mu      sync.Mutex
+	sent    [numPhases]uint64
+	samples [numPhases][]time.Duration
+	timed   [numPhases][]timedProbe
+}
+
+func newLatencyProber(conn quic.Connection, interval time.Duration, oneWay bool) *latencyProber {
+	return &latencyProber{
+		conn:     conn,
+		interval: interval,
+		start:    time.Now(),
+		oneWay:   oneWay,
+	}
+}
+
+// probeLen returns the length of the probes p sends.
+func (p *latencyProber) probeLen() int {
+	if p.oneWay {
+		return timedProbeLen
+	}
+	return probeLen
+}
+
+// receive records the RTT of every echoed probe until the connection is
+// closed.
+func (p *latencyProber) receive() {
+	for {
+		msg, err := p.conn.ReceiveMessage()
+		if err != nil {
+			return
+		}
+		if len(msg) != p.probeLen() || msg[0] >= numPhases {
+			log.Warningf("Ignoring unexpected datagram of %d bytes from %s", len(msg), p.conn.RemoteAddr())
+			continue
+		}
+
+		sent := time.Duration(binary.BigEndian.Uint64(msg[9:]))
+		received := time.Since(p.start)
+		rtt := received - sent
+
+		p.mu.Lock()
+		p.samples[msg[0]] = append(p.samples[msg[0]], rtt)
+		if p.oneWay {
+			// Servers that predate -one-way echo the probe
+			// unchanged.
+			if server := int64(binary.BigEndian.Uint64(msg[probeLen:])); server != 0 {
+				p.timed[msg[0]] = append(p.timed[msg[0]], timedProbe{
+					sent:     p.start.UnixNano() + int64(sent),
+					server:   server,
+					received: p.start.UnixNano() + int64(received),
+				})
+			}
+		}
+		p.mu.Unlock()
+	}
+}
+
+// run sends a probe every p.interval until ctx is done or, if n is
+// positive, n probes have been sent.
+func (p *latencyProber) run(ctx context.Context, phase byte, n int) {
+	t := time.NewTicker(p.interval)
+	defer t.Stop()
+
+	msg := make([]byte, p.probeLen())
+	for seq := 0; n <= 0 || seq < n; seq++ {
+		msg[0] = phase
+		binary.BigEndian.PutUint64(msg[1:], uint64(seq))
+		binary.BigEndian.PutUint64(msg[9:], uint64(time.Since(p.start)))
+		if err := p.conn.SendMessage(msg); err != nil {
+			log.Errorf("Error sending latency probe: %v", err)
+			return
+		}
+
+		p.mu.Lock()
+		p.sent[phase]++
+		p.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// report prints the idle and loaded RTTs and the difference between them.
+func (p *latencyProber) report() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var medians [numPhases]time.Duration
+	for phase, name := range []string{"Idle", "Loaded"} {
+		samples := p.samples[phase]
+		if len(samples) == 0 {
+			fmt.Printf("%s RTT: no probes answered (%d sent)\n", name, p.sent[phase])
+			continue
+		}
+		medians[phase] = median(samples)
+		fmt.Printf("%s RTT: median %.3f ms (%d of %d probes answered)\n",
+			name, float64(medians[phase])/1e6, len(samples), p.sent[phase])
+	}
+
+	if medians[phaseIdle] != 0 && medians[phaseLoaded] != 0 {
+		fmt.Printf("Latency increase under load: %.3f ms (%.0f round trips per minute under load)\n",
+			float64(medians[phaseLoaded]-medians[phaseIdle])/1e6,
+			float64(time.Minute)/float64(medians[phaseLoaded]))
+	}
+
+	if p.oneWay {
+		p.reportOneWay()
+	}
+}
+
+// median returns the median of samples, which must not be empty.
+func median(samples []time.Duration) time.Duration {
+	return percentile(samples, 50)
+}
+
+// percentile returns the p-th percentile of samples, which must not be
+// empty, using the nearest-rank method.
+func percentile(samples []time.Duration, p float64) time.Duration {
+	s := append([]time.Duration(nil), samples...)
+	sort.Slice(s, func(i, j int) bool { return s[i] < s[j] })
+	i := int(math.Ceil(p/100*float64(len(s)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	return s[i]
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/golang/glog"
+	"github.com/quic-go/quic-go"
+	"golang.org/x/exp/slog"
+)
+
+// A logHandler writes out log messages. Messages go to an slog.Handler
+// unless -log-format glog asks for glog's output, files and flags.
+type logHandler interface {
+	// output writes msg, which was logged depth calls above output,
+	// along with fields.
+	output(depth int, level slog.Level, msg string, fields []logField)
+}
+
+// logOutput is where every logger's messages go. It's set from
+// -log-format by setupLogging.
+var logOutput logHandler = slogHandler{slog.New(slog.NewTextHandler(os.Stderr))}
+
+// setupLogging points logOutput at the handler selected with -log-format.
+func setupLogging() {
+	switch *logFormat {
+	case "text":
+		logOutput = slogHandler{slog.New(slog.NewTextHandler(os.Stderr))}
+	case "json":
+		logOutput = slogHandler{slog.New(slog.NewJSONHandler(os.Stderr))}
+	case "glog":
+		logOutput = glogHandler{}
+	default:
+		log.Exitf("Fatal error: unknown -log-format %q, want text, json or glog", *logFormat)
+	}
+}
+
+type slogHandler struct {
+	l *slog.Logger
+}
+
+func (h slogHandler) output(_ int, level slog.Level, msg string, fields []logField) {
+	attrs := make([]slog.Attr, len(fields))
+	for i, f := range fields {
+		attrs[i] = slog.Any(f.key, f.value)
+	}
+	h.l.LogAttrs(level, msg, attrs...)
+}
+
+// glogHandler writes messages through glog, leaving out the fields as
+// the messages have always done.
+type glogHandler struct{}
+
+func (glogHandler) output(depth int, level slog.Level, msg string, _ []logField) {
+	switch {
+	case level >= slog.LevelError:
+		glog.ErrorDepth(depth+1, msg)
+	case level >= slog.LevelWarn:
+		glog.WarningDepth(depth+1, msg)
+	default:
+		glog.InfoDepth(depth+1, msg)
+	}
+}
+
+// logger logs qperf's operational messages to logOutput, adding the
+// fields it carries, such as the connection a message is about.
+type logger struct {
+	fields []logField
+}
+
+type logField struct {
+	key   string
+	value interface{}
+}
+
+// log is the logger for messages that aren't about a particular
+// connection.
+var log = new(logger)
+
+// with returns a logger that adds key and value to every message.
+func (l *logger) with(key string, value interface{}) *logger {
+	fields := append(l.fields[:len(l.fields):len(l.fields)], logField{key, value})
+	return &logger{fields: fields}
+}
+
+// forConn returns a logger for messages about conn, which carries the
+// connection's ID and the address of its peer.
+func (l *logger) forConn(conn quic.Connection) *logger {
+	id, _ := conn.Context().Value(quic.ConnectionTracingKey).(uint64)
+	return l.with("conn", id).with("remote", conn.RemoteAddr().String())
+}
+
+func (l *logger) Infof(format string, args ...interface{}) {
+	logOutput.output(1, slog.LevelInfo, fmt.Sprintf(format, args...), l.fields)
+}
+
+func (l *logger) Warningf(format string, args ...interface{}) {
+	logOutput.output(1, slog.LevelWarn, fmt.Sprintf(format, args...), l.fields)
+}
+
+func (l *logger) Errorf(format string, args ...interface{}) {
+	logOutput.output(1, slog.LevelError, fmt.Sprintf(format, args...), l.fields)
+}
+
+// Exitf logs the message as an error and exits with status 1.
+func (l *logger) Exitf(format string, args ...interface{}) {
+	logOutput.output(1, slog.LevelError, fmt.Sprintf(format, args...), l.fields)
+	glog.Flush()
+	os.Exit(1)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"runtime/debug"
+
+	"github.com/quic-go/quic-go"
+	"golang.org/x/exp/slog"
+)
+
+// Flow control windows of -low-memory, in place of quic-go's defaults of
+// 512 KiB per stream growing up to 6 MiB, and 768 KiB per connection
+// growing up to 15 MiB. They bound the data a receiver buffers, and so
+// the throughput to about 256 KiB per round trip on each stream.
+const (
+	lowMemoryStreamWindow    = 64 << 10
+	lowMemoryMaxStreamWindow = 256 << 10
+	lowMemoryConnWindow      = 96 << 10
+	lowMemoryMaxConnWindow   = 512 << 10
+)
+
+// lowMemoryGCPercent is the garbage collection target of -low-memory,
+// trading CPU time for a heap that grows less between collections.
+const lowMemoryGCPercent = 25
+
+// setupLowMemory makes the process itself frugal with memory if
+// -low-memory is set: it collects garbage more often and logs only
+// warnings and errors.
+func setupLowMemory() {
+	if !*lowMemory {
+		return
+	}
+	debug.SetGCPercent(lowMemoryGCPercent)
+	logOutput = leveledHandler{logOutput, slog.LevelWarn}
+}
+
+// applyLowMemory shrinks the flow control windows in c if -low-memory is
+// set. It is applied before -transport-params, which may raise them again.
+func applyLowMemory(c *quic.Config) {
+	if !*lowMemory {
+		return
+	}
+	c.InitialStreamReceiveWindow = lowMemoryStreamWindow
+	c.MaxStreamReceiveWindow = lowMemoryMaxStreamWindow
+	c.InitialConnectionReceiveWindow = lowMemoryConnWindow
+	c.MaxConnectionReceiveWindow = lowMemoryMaxConnWindow
+}
+
+// leveledHandler is a logHandler dropping the messages below min.
+type leveledHandler struct {
+	logHandler
+	min slog.Level
+}
+
+func (h leveledHandler) output(depth int, level slog.Level, msg string, fields []logField) {
+	if level < h.min {
+		return
+	}
+	h.logHandler.output(depth+1, level, msg, fields)
+}
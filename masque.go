@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+	"github.com/quic-go/quic-go/quicvarint"
+)
+
+const (
+	// masqueSettingH3Datagram is the HTTP/3 setting announcing support
+	// for HTTP datagrams (RFC 9297).
+	masqueSettingH3Datagram = 0x33
+
+	// masqueCapsuleDatagram is the type of the capsules carrying HTTP
+	// datagrams on the request stream.
+	masqueCapsuleDatagram = 0x00
+
+	// masqueTemplate is the path of the URI template of RFC 9298, added
+	// to a -masque-proxy URL that has no template of its own.
+	masqueTemplate = "/.well-known/masque/udp/{target_host}/{target_port}/"
+
+	// masqueMaxDatagram is the largest HTTP datagram sent as a QUIC
+	// datagram. Larger ones wouldn't fit in the packets quic-go sends
+	// before it discovers the path MTU to the proxy, so they are sent
+	// in capsules on the request stream instead.
+	masqueMaxDatagram = 1180
+
+	// masqueReadQueue is the number of packets received from the proxy
+	// that may wait to be read before more are dropped.
+	masqueReadQueue = 1024
+)
+
+// masqueConn is a net.PacketConn that tunnels UDP packets to a single
+// target through a MASQUE proxy with HTTP/3 CONNECT-UDP (RFC 9298).
+// Packets travel as QUIC datagrams where they fit, and in DATAGRAM
+// capsules on the CONNECT request stream where they don't.
+type masqueConn struct {
+	rt     *http3.RoundTripper
+	conn   quic.Connection // to the proxy
+	str    http3.Stream
+	prefix []byte // the quarter stream ID that starts each datagram
+	target net.Addr
+
+	packets chan []byte
+	closed  chan struct{}
+	once    sync.Once
+
+	mu sync.Mutex // serializes the capsules written to str
+
+	datagrams, capsules uint64 // packets sent each way, accessed atomically
+}
+
+// masqueURL returns the URL for a CONNECT-UDP request to target through
+// proxy, expanding proxy's URI template, or masqueTemplate if it has
+// none.
+func masqueURL(proxy, target string) (*url.URL, error) {
+	host, port, err := net.SplitHostPort(target)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.Contains(proxy, "{target_host}") {
+		proxy = strings.TrimSuffix(proxy, "/") + masqueTemplate
+	}
+	r := strings.NewReplacer("{target_host}", strings.ReplaceAll(url.PathEscape(host), ":", "%3A"), "{target_port}", port)
+	u, err := url.Parse(r.Replace(proxy))
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported proxy scheme: %q", u.Scheme)
+	}
+	return u, nil
+}
+
+// dialMASQUE connects to the MASQUE proxy at the URL or URI template
+// proxy and asks it to relay UDP packets to target, which it sees as
+// coming from raddr.
+func dialMASQUE(ctx context.Context, proxy, target string, raddr net.Addr) (*masqueConn, error) {
+	u, err := masqueURL(proxy, target)
+	if err != nil {
+		return nil, err
+	}
+
+	var conn quic.EarlyConnection
+	rt := &http3.RoundTripper{
+		TLSClientConfig: &tls.Config{
+			NextProtos:         []string{http3.NextProtoH3},
+			InsecureSkipVerify: *insecure,
+		},
+		QuicConfig:         &quic.Config{HandshakeIdleTimeout: *handshakeIdleTimeout},
+		DisableCompression: true,
+		AdditionalSettings: map[uint64]uint64{masqueSettingH3Datagram: 1},
+		// The http3 package only speaks the draft version of HTTP
+		// datagrams, so they are enabled on the connection here and
+		// announced with the setting of the RFC above.
+		Dial: func(ctx context.Context, addr string, tlsConf *tls.Config, qconf *quic.Config) (quic.EarlyConnection, error) {
+			qconf = qconf.Clone()
+			qconf.EnableDatagrams = true
+			c, err := quic.DialAddrEarlyContext(ctx, addr, tlsConf, qconf)
+			conn = c
+			return c, err
+		},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodConnect, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Proto = "connect-udp"
+	req.Header.Set("Capsule-Protocol", "?1")
+	resp, err := rt.RoundTripOpt(req, http3.RoundTripOpt{DontCloseRequestStream: true})
+	if err != nil {
+		rt.Close()
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		rt.Close()
+		return nil, fmt.Errorf("proxy answered %s", resp.Status)
+	}
+	str := resp.Body.(http3.HTTPStreamer).HTTPStream()
+
+	c := &masqueConn{
+		rt:      rt,
+		conn:    conn,
+		str:     str,
+		prefix:  quicvarint.Append(nil, uint64(str.StreamID())/4),
+		target:  raddr,
+		packets: make(chan []byte, masqueReadQueue),
+		closed:  make(chan struct{}),
+	}
+	if conn.ConnectionState().SupportsDatagrams {
+		go c.readDatagrams()
+	} else {
+		log.Warningf("MASQUE proxy %s doesn't support QUIC datagrams, tunneling every packet on a stream", u.Host)
+	}
+	go c.readCapsules()
+	return c, nil
+}
+
+// receive queues the payload of the HTTP datagram b for ReadFrom, if it
+// is a UDP packet.
+func (c *masqueConn) receive(b []byte) {
+	r := bytes.NewReader(b)
+	contextID, err := quicvarint.Read(r)
+	if err != nil || contextID != 0 {
+		return
+	}
+	select {
+	case c.packets <- b[len(b)-r.Len():]:
+	default:
+	}
+}
+
+// readDatagrams receives the QUIC datagrams of the proxy connection.
+func (c *masqueConn) readDatagrams() {
+	for {
+		msg, err := c.conn.ReceiveMessage()
+		if err != nil {
+			return
+		}
+		r := bytes.NewReader(msg)
+		if qsid, err := quicvarint.Read(r); err == nil && qsid == uint64(c.str.StreamID())/4 {
+			c.receive(msg[len(msg)-r.Len():])
+		}
+	}
+}
+
+// readCapsules receives the capsules on the request stream, ignoring
+// those of types other than DATAGRAM as RFC 9297 says.
+func (c *masqueConn) readCapsules() {
+	r := bufio.NewReader(c.str)
+	for {
+		typ, err := quicvarint.Read(r)
+		if err != nil {
+			return
+		}
+		n, err := quicvarint.Read(r)
+		if err != nil {
+			return
+		}
+		b := make([]byte, n)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return
+		}
+		if typ == masqueCapsuleDatagram {
+			c.receive(b)
+		}
+	}
+}
+
+func (c *masqueConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	select {
+	case b := <-c.packets:
+		return copy(p, b), c.target, nil
+	case <-c.closed:
+		return 0, nil, net.ErrClosed
+	}
+}
+
+func (c *masqueConn) WriteTo(p []byte, _ net.Addr) (int, error) {
+	if len(c.prefix)+1+len(p) <= masqueMaxDatagram && c.conn.ConnectionState().SupportsDatagrams {
+		b := make([]byte, 0, len(c.prefix)+1+len(p))
+		b = append(append(append(b, c.prefix...), 0), p...)
+		if err := c.conn.SendMessage(b); err == nil {
+			atomic.AddUint64(&c.datagrams, 1)
+			return len(p), nil
+		}
+	}
+
+	b := quicvarint.Append(nil, masqueCapsuleDatagram)
+	b = quicvarint.Append(b, uint64(1+len(p)))
+	b = append(append(b, 0), p...)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, err := c.str.Write(b); err != nil {
+		return 0, err
+	}
+	atomic.AddUint64(&c.capsules, 1)
+	return len(p), nil
+}
+
+// Close ends the tunnel and closes the connection to the proxy.
+func (c *masqueConn) Close() error {
+	c.once.Do(func() {
+		close(c.closed)
+		log.Infof("Sent %d packets through the MASQUE proxy as datagrams and %d on its stream",
+			atomic.LoadUint64(&c.datagrams), atomic.LoadUint64(&c.capsules))
+		c.str.Close()
+		c.rt.Close()
+	})
+	return nil
+}
+
+func (c *masqueConn) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+func (c *masqueConn) SetDeadline(time.Time) error      { return nil }
+func (c *masqueConn) SetReadDeadline(time.Time) error  { return nil }
+func (c *masqueConn) SetWriteDeadline(time.Time) error { return nil }
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// Servers are advertised with DNS-SD over multicast DNS (RFC 6762 and RFC
+// 6763) as instances of the _qperf._udp service.
+const (
+	mdnsAddr    = "224.0.0.251:5353"
+	mdnsPort    = 5353
+	mdnsService = "_qperf._udp.local."
+	mdnsTTL     = 120
+
+	// discoverTimeout is how long the client waits for servers to
+	// answer.
+	discoverTimeout = 2 * time.Second
+)
+
+// advertise answers mDNS queries for the _qperf._udp service with this
+// server's instance, listening on port, until it fails.
+func advertise(port int) error {
+	group, err := net.ResolveUDPAddr("udp4", mdnsAddr)
+	if err != nil {
+		return err
+	}
+	c, err := net.ListenMulticastUDP("udp4", nil, group)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		return err
+	}
+	hostname, _, _ = strings.Cut(hostname, ".")
+	instance, err := dnsmessage.NewName(hostname + "." + mdnsService)
+	if err != nil {
+		return err
+	}
+	target, err := dnsmessage.NewName(hostname + ".local.")
+	if err != nil {
+		return err
+	}
+	log.Infof("Advertising %s on port %d with mDNS", instance, port)
+
+	buf := make([]byte, 9000)
+	for {
+		n, src, err := c.ReadFromUDP(buf)
+		if err != nil {
+			return err
+		}
+
+		var p dnsmessage.Parser
+		hdr, err := p.Start(buf[:n])
+		if err != nil || hdr.Response {
+			continue
+		}
+		questions, err := p.AllQuestions()
+		if err != nil {
+			continue
+		}
+		for _, q := range questions {
+			if !strings.EqualFold(q.Name.String(), mdnsService) || (q.Type != dnsmessage.TypePTR && q.Type != dnsmessage.TypeALL) {
+				continue
+			}
+
+			// Queries from ports other than 5353 come from
+			// simple resolvers that expect a unicast reply with
+			// the question echoed back (RFC 6762, section 6.7).
+			legacy := src.Port != mdnsPort
+			resp, err := mdnsResponse(hdr, q, legacy, instance, target, port)
+			if err != nil {
+				log.Errorf("Error building mDNS response: %v", err)
+				break
+			}
+			dst := group
+			if legacy || q.Class&(1<<15) != 0 {
+				dst = src
+			}
+			if _, err := c.WriteToUDP(resp, dst); err != nil {
+				log.Errorf("Error sending mDNS response to %s: %v", dst, err)
+			}
+			break
+		}
+	}
+}
+
+// mdnsResponse builds the answer to the query for the service in q.
+func mdnsResponse(query dnsmessage.Header, q dnsmessage.Question, legacy bool, instance, target dnsmessage.Name, port int) ([]byte, error) {
+	hdr := dnsmessage.Header{Response: true, Authoritative: true}
+	if legacy {
+		hdr.ID = query.ID
+	}
+	b := dnsmessage.NewBuilder(nil, hdr)
+	b.EnableCompression()
+	if legacy {
+		if err := b.StartQuestions(); err != nil {
+			return nil, err
+		}
+		q.Class = dnsmessage.ClassINET
+		if err := b.Question(q); err != nil {
+			return nil, err
+		}
+	}
+	if err := b.StartAnswers(); err != nil {
+		return nil, err
+	}
+
+	rh := func(name dnsmessage.Name) dnsmessage.ResourceHeader {
+		return dnsmessage.ResourceHeader{Name: name, Class: dnsmessage.ClassINET, TTL: mdnsTTL}
+	}
+	if err := b.PTRResource(rh(q.Name), dnsmessage.PTRResource{PTR: instance}); err != nil {
+		return nil, err
+	}
+	if err := b.SRVResource(rh(instance), dnsmessage.SRVResource{Port: uint16(port), Target: target}); err != nil {
+		return nil, err
+	}
+	if err := b.TXTResource(rh(instance), dnsmessage.TXTResource{TXT: []string{"alpn=" + alpnNextProto}}); err != nil {
+		return nil, err
+	}
+
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range addrs {
+		ipnet, ok := a.(*net.IPNet)
+		if !ok || ipnet.IP.IsLoopback() || ipnet.IP.To4() == nil {
+			continue
+		}
+		var ip [4]byte
+		copy(ip[:], ipnet.IP.To4())
+		if err := b.AResource(rh(target), dnsmessage.AResource{A: ip}); err != nil {
+			return nil, err
+		}
+	}
+	return b.Finish()
+}
+
+// discover asks for qperf servers on the local network with mDNS and
+// prints the address of each one that answers.
+func discover() error {
+	group, err := net.ResolveUDPAddr("udp4", mdnsAddr)
+	if err != nil {
+		return err
+	}
+	c, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{})
+	b.StartQuestions()
+	b.Question(dnsmessage.Question{
+		Name:  dnsmessage.MustNewName(mdnsService),
+		Type:  dnsmessage.TypePTR,
+		Class: dnsmessage.ClassINET,
+	})
+	query, err := b.Finish()
+	if err != nil {
+		return err
+	}
+	if _, err := c.WriteToUDP(query, group); err != nil {
+		return err
+	}
+
+	type server struct {
+		port   uint16
+		target string
+	}
+	var (
+		servers = make(map[string]server)
+		hosts   = make(map[string][]net.IP)
+		buf     = make([]byte, 9000)
+	)
+	c.SetReadDeadline(time.Now().Add(discoverTimeout))
+	for {
+		n, _, err := c.ReadFromUDP(buf)
+		if err != nil {
+			if e, ok := err.(net.Error); ok && e.Timeout() {
+				break
+			}
+			return err
+		}
+
+		var p dnsmessage.Parser
+		if _, err := p.Start(buf[:n]); err != nil {
+			continue
+		}
+		if err := p.SkipAllQuestions(); err != nil {
+			continue
+		}
+		answers, err := p.AllAnswers()
+		if err != nil {
+			continue
+		}
+		for _, r := range answers {
+			switch body := r.Body.(type) {
+			case *dnsmessage.SRVResource:
+				servers[r.Header.Name.String()] = server{port: body.Port, target: body.Target.String()}
+			case *dnsmessage.AResource:
+				hosts[r.Header.Name.String()] = append(hosts[r.Header.Name.String()], net.IP(body.A[:]))
+			}
+		}
+	}
+
+	if len(servers) == 0 {
+		fmt.Println("No qperf servers found")
+		return nil
+	}
+	names := make([]string, 0, len(servers))
+	for name := range servers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		s := servers[name]
+		var addrs []string
+		for _, ip := range hosts[s.target] {
+			addrs = append(addrs, net.JoinHostPort(ip.String(), fmt.Sprint(s.port)))
+		}
+		if len(addrs) == 0 {
+			addrs = append(addrs, net.JoinHostPort(strings.TrimSuffix(s.target, "."), fmt.Sprint(s.port)))
+		}
+		fmt.Printf("%s\t%s\n", strings.TrimSuffix(name, "."+mdnsService), strings.Join(addrs, " "))
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+// runMixed makes requests as -rpc does, alone for the first half of the
+// test and alongside a bulk transfer on the same connection for the
+// second half, then reports how the bulk transfer changed their
+// latencies: the head-of-line blocking and stream scheduling within one
+// connection. The bulk transfer is sent on a stream the client opens
+// once the first half is over, so that none of it competes with the
+// requests made alone.
+func runMixed(ctx context.Context, conn quic.Connection) testResult {
+	cancelBulkStream(ctx, conn)
+
+	req := newRPCRequest(*rpcRequestSize, *rpcResponseSize)
+	half := time.Duration(*durationInSecs) * time.Second / 2
+
+	aloneCtx, cancel := context.WithTimeout(ctx, half)
+	start := time.Now()
+	alone, aloneBytes := makeRPCs(aloneCtx, conn, req)
+	aloneDur := time.Since(start)
+	cancel()
+
+	s, err := openDownload(ctx, conn, 0)
+	if err != nil {
+		log.Exitf("Fatal error opening the bulk transfer stream to %s: %v", conn.RemoteAddr(), err)
+	}
+	var bulk uint64 // accessed atomically
+	go func() {
+		var buf [readChunkSize]byte
+		for {
+			n, err := s.Read(buf[:])
+			atomic.AddUint64(&bulk, uint64(n))
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	loadedCtx, cancel := context.WithTimeout(ctx, half)
+	defer cancel()
+	loadedStart := time.Now()
+	loaded, loadedBytes := makeRPCs(loadedCtx, conn, req)
+	loadedDur := time.Since(loadedStart)
+	s.CancelRead(quic.StreamErrorCode(quic.NoError))
+
+	for _, p := range []struct {
+		name string
+		h    *histogram
+		dur  time.Duration
+	}{{"Alone", alone, aloneDur}, {"With bulk transfer", loaded, loadedDur}} {
+		fmt.Printf("%s: %d requests (%.1f requests/s), latency p50 %.3f ms, p90 %.3f ms, p99 %.3f ms, max %.3f ms\n",
+			p.name, p.h.count(), float64(p.h.count())/p.dur.Seconds(),
+			float64(p.h.percentile(50))/1e6,
+			float64(p.h.percentile(90))/1e6,
+			float64(p.h.percentile(99))/1e6,
+			float64(p.h.percentile(100))/1e6)
+	}
+	b := atomic.LoadUint64(&bulk)
+	fmt.Printf("Bulk transfer: %d bytes (%s)\n", b, rate(b, loadedDur))
+	if alone.count() > 0 && loaded.count() > 0 {
+		fmt.Printf("Change under the bulk transfer: p50 %+.3f ms, p99 %+.3f ms\n",
+			float64(loaded.percentile(50)-alone.percentile(50))/1e6,
+			float64(loaded.percentile(99)-alone.percentile(99))/1e6)
+	}
+
+	return testResult{bytes: aloneBytes + loadedBytes + b, duration: time.Since(start)}
+}
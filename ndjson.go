@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// ndjsonInterval is how often -ndjson reports on the bulk transfer.
+const ndjsonInterval = time.Second
+
+// ndjsonOut is where -ndjson records are written: the original standard
+// output, the rest of the client's output having been moved to standard
+// error.
+var ndjsonOut io.Writer = os.Stdout
+
+// ndjsonRecord is one line of -ndjson output: an interval of the bulk
+// transfer or, at its end, the whole of it.
+type ndjsonRecord struct {
+	Type       string       `json:"type"` // "interval" or "summary"
+	Time       float64      `json:"time_s"`
+	Bytes      uint64       `json:"bytes"`
+	Throughput float64      `json:"throughput_bps"`
+	SRTT       float64      `json:"srtt_ms"`
+	Loss       float64      `json:"loss"`
+	Tags       tagList      `json:"tags,omitempty"`
+	Env        *environment `json:"env,omitempty"` // in the summary, with -env
+}
+
+// writeNDJSON writes r, with the -tag tags, as a line of JSON to
+// ndjsonOut.
+func writeNDJSON(r ndjsonRecord) {
+	r.Tags = resultTags
+	if err := json.NewEncoder(ndjsonOut).Encode(r); err != nil {
+		log.Errorf("Error writing NDJSON output: %v", err)
+	}
+}
+
+// ndjsonStreamer writes a record for every ndjsonInterval of the bulk
+// transfer as it happens.
+type ndjsonStreamer struct {
+	stats    *connStats
+	received uint64 // accessed atomically
+
+	stopCh chan struct{}
+	doneCh chan struct{}
+}
+
+func newNDJSONStreamer(stats *connStats) *ndjsonStreamer {
+	return &ndjsonStreamer{
+		stats:  stats,
+		stopCh: make(chan struct{}),
+		doneCh: make(chan struct{}),
+	}
+}
+
+// add counts n more bytes received.
+func (s *ndjsonStreamer) add(n int) {
+	atomic.AddUint64(&s.received, uint64(n))
+}
+
+// run writes a record every ndjsonInterval until stop is called.
+func (s *ndjsonStreamer) run() {
+	defer close(s.doneCh)
+
+	t := time.NewTicker(ndjsonInterval)
+	defer t.Stop()
+
+	start := time.Now()
+	var prevBytes uint64
+	prevPackets, prevPN := s.stats.received()
+	for {
+		select {
+		case <-s.stopCh:
+			return
+		case <-t.C:
+		}
+
+		r := ndjsonRecord{Type: "interval", Time: time.Since(start).Seconds()}
+		n := atomic.LoadUint64(&s.received)
+		r.Bytes, prevBytes = n-prevBytes, n
+		r.Throughput = float64(r.Bytes) * 8 / ndjsonInterval.Seconds()
+		packets, pn := s.stats.received()
+		if pn > prevPN {
+			r.Loss = 1 - float64(packets-prevPackets)/float64(pn-prevPN)
+		}
+		prevPackets, prevPN = packets, pn
+		srtt, _, _ := s.stats.metrics()
+		r.SRTT = float64(srtt) / 1e6
+		writeNDJSON(r)
+	}
+}
+
+// stop stops writing records.
+func (s *ndjsonStreamer) stop() {
+	close(s.stopCh)
+	<-s.doneCh
+}
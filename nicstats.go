@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// nicCounterNames are the interface counters compared by -nic-stats, as
+// named in /sys/class/net/<interface>/statistics on Linux.
+var nicCounterNames = []string{
+	"rx_bytes", "rx_packets", "rx_errors", "rx_dropped",
+	"tx_bytes", "tx_packets", "tx_errors", "tx_dropped",
+}
+
+// nicStats compares an interface's counters at the start and end of a
+// test.
+type nicStats struct {
+	iface  string
+	before map[string]uint64
+}
+
+// snapshotNICStats reads the counters of the interface that packets to
+// raddr are sent from: the one given with -interface, if any.
+func snapshotNICStats(raddr net.Addr) (*nicStats, error) {
+	iface := *bindDevice
+	if iface == "" {
+		var err error
+		if iface, err = interfaceTo(raddr); err != nil {
+			return nil, err
+		}
+	}
+	c, err := readNICCounters(iface)
+	if err != nil {
+		return nil, err
+	}
+	return &nicStats{iface: iface, before: c}, nil
+}
+
+// interfaceTo returns the name of the interface with the local address
+// the kernel would send packets to raddr from.
+func interfaceTo(raddr net.Addr) (string, error) {
+	// Connecting a UDP socket picks the route without sending anything.
+	c, err := net.Dial("udp", raddr.String())
+	if err != nil {
+		return "", err
+	}
+	local := c.LocalAddr().(*net.UDPAddr).IP
+	c.Close()
+
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return "", err
+	}
+	for _, iface := range ifaces {
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, a := range addrs {
+			if ipnet, ok := a.(*net.IPNet); ok && ipnet.IP.Equal(local) {
+				return iface.Name, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("no interface has the address %s", local)
+}
+
+func readNICCounters(iface string) (map[string]uint64, error) {
+	c := make(map[string]uint64)
+	for _, name := range nicCounterNames {
+		b, err := os.ReadFile(filepath.Join("/sys/class/net", iface, "statistics", name))
+		if err != nil {
+			return nil, err
+		}
+		if c[name], err = strconv.ParseUint(strings.TrimSpace(string(b)), 10, 64); err != nil {
+			return nil, fmt.Errorf("parsing %s of %s: %v", name, iface, err)
+		}
+	}
+	return c, nil
+}
+
+// report prints how much the counters grew during the test. They count
+// all the interface's traffic, not just the test's.
+func (s *nicStats) report() {
+	after, err := readNICCounters(s.iface)
+	if err != nil {
+		log.Warningf("Error reading the counters of %s: %v", s.iface, err)
+		return
+	}
+	d := func(name string) uint64 { return after[name] - s.before[name] }
+	fmt.Printf("Interface %s received: %d bytes in %d packets (%d errors, %d dropped)\n",
+		s.iface, d("rx_bytes"), d("rx_packets"), d("rx_errors"), d("rx_dropped"))
+	fmt.Printf("Interface %s sent: %d bytes in %d packets (%d errors, %d dropped)\n",
+		s.iface, d("tx_bytes"), d("tx_packets"), d("tx_errors"), d("tx_dropped"))
+}
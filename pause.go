@@ -0,0 +1,313 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+// The client pauses the server's bulk transfer by opening a control
+// stream and sending requestPause. The server stops writing the payload
+// on every stream of the connection until the client closes that
+// stream, so the pause can't outlive the client, and discards whatever
+// the client writes on it meanwhile.
+//
+// requestPause is the request to pause. Like requestResults, it has a
+// bit no set of directions has.
+const requestPause = 0x84
+
+// pauseKeepAlive is how often the client writes a byte on the control
+// stream of a pause, which the server discards, so that neither end
+// closes the connection for being idle however long the pause.
+const pauseKeepAlive = time.Second
+
+// pauseGate holds up the writes of a connection's bulk transfers while
+// any pause requested on it is in progress. The zero value is open.
+type pauseGate struct {
+	mu      sync.Mutex
+	pauses  int
+	resumed chan struct{} // closed when the last pause ends
+}
+
+func (g *pauseGate) pause() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.pauses == 0 {
+		g.resumed = make(chan struct{})
+	}
+	g.pauses++
+}
+
+func (g *pauseGate) resume() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.pauses--
+	if g.pauses == 0 {
+		close(g.resumed)
+	}
+}
+
+// wait returns once no pause is in progress, or when ctx is done.
+func (g *pauseGate) wait(ctx context.Context) error {
+	g.mu.Lock()
+	paused, resumed := g.pauses > 0, g.resumed
+	g.mu.Unlock()
+
+	if !paused {
+		return nil
+	}
+	select {
+	case <-resumed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// pauseWriter is an io.Writer that waits for a pauseGate before every
+// write.
+type pauseWriter struct {
+	ctx  context.Context
+	gate *pauseGate
+	w    io.Writer
+}
+
+func (p *pauseWriter) Write(b []byte) (int, error) {
+	if err := p.gate.wait(p.ctx); err != nil {
+		return 0, err
+	}
+	return p.w.Write(b)
+}
+
+// servePause answers a request to pause on the control stream s, holding
+// up the bulk transfers on conn until the client closes the stream.
+func servePause(conn quic.Connection, s quic.Stream, clog *logger) {
+	s.Close()
+	sc := lookupServerConn(conn)
+	if sc == nil {
+		s.CancelRead(quic.StreamErrorCode(quic.NoError))
+		return
+	}
+
+	sc.pause.pause()
+	start := time.Now()
+	clog.Infof("Pausing the transfer to client: %s", conn.RemoteAddr())
+	io.Copy(io.Discard, s)
+	sc.pause.resume()
+	clog.Infof("Resuming the transfer to client after %.3f seconds: %s", time.Since(start).Seconds(), conn.RemoteAddr())
+}
+
+// pauser pauses and resumes the server's bulk transfer for the client,
+// keeping track of how long it was paused for. The read deadline of the
+// download is lifted while paused, and resuming sets it back by as long
+// as the pause lasted, so that the transfer is active for the whole
+// test however long the pause.
+type pauser struct {
+	ctx   context.Context
+	conn  quic.Connection
+	s     quic.ReceiveStream
+	start time.Time
+
+	mu            sync.Mutex
+	deadline      time.Time   // zero if the download has none
+	opening       bool        // while pause opens the control stream
+	ctrl          quic.Stream // nil unless paused
+	stopKeepAlive chan struct{}
+	keptAlive     sync.WaitGroup
+	since         time.Time
+	idle          time.Duration
+	pauses        int
+	stopped       bool
+}
+
+func newPauser(ctx context.Context, conn quic.Connection, s quic.ReceiveStream, start, deadline time.Time) *pauser {
+	return &pauser{ctx: ctx, conn: conn, s: s, start: start, deadline: deadline}
+}
+
+// pause asks the server to pause, unless it already is.
+func (p *pauser) pause() error {
+	p.mu.Lock()
+	if p.ctrl != nil || p.stopped || p.opening {
+		p.mu.Unlock()
+		return nil
+	}
+	p.opening = true
+	p.mu.Unlock()
+
+	// Opening the control stream takes a round trip, or up to
+	// controlHelloTimeout, which resume and stop shouldn't wait for.
+	s, err := p.open()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.opening = false
+	if err != nil {
+		return err
+	}
+	if p.stopped {
+		s.Close()
+		s.CancelRead(quic.StreamErrorCode(quic.NoError))
+		return nil
+	}
+	p.ctrl = s
+	p.since = time.Now()
+	p.pauses++
+	p.stopKeepAlive = make(chan struct{})
+	p.keptAlive.Add(1)
+	go func() {
+		defer p.keptAlive.Done()
+		keepAlive(s, p.stopKeepAlive)
+	}()
+	if !p.deadline.IsZero() {
+		p.s.SetReadDeadline(time.Time{})
+	}
+	fmt.Printf("Paused the transfer at %.3f seconds\n", p.since.Sub(p.start).Seconds())
+	return nil
+}
+
+// open opens a control stream and asks the server to pause on it.
+func (p *pauser) open() (quic.Stream, error) {
+	ctx, cancel := context.WithTimeout(p.ctx, controlHelloTimeout)
+	defer cancel()
+	s, version, err := openControl(ctx, p.conn)
+	if err != nil {
+		return nil, err
+	}
+	if version < 6 {
+		s.CancelRead(quic.StreamErrorCode(quic.NoError))
+		s.CancelWrite(quic.StreamErrorCode(quic.NoError))
+		return nil, fmt.Errorf("the server speaks version %d of the control protocol, which can't pause", version)
+	}
+	if _, err := s.Write([]byte{requestPause}); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// keepAlive writes a byte on s every pauseKeepAlive until stop is
+// closed.
+func keepAlive(s quic.SendStream, stop <-chan struct{}) {
+	t := time.NewTicker(pauseKeepAlive)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			if _, err := s.Write([]byte{0}); err != nil {
+				return
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// resume ends the pause in progress, if any.
+func (p *pauser) resume() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.endLocked()
+}
+
+func (p *pauser) endLocked() {
+	if p.ctrl == nil {
+		return
+	}
+	close(p.stopKeepAlive)
+	p.keptAlive.Wait()
+	p.ctrl.Close()
+	p.ctrl.CancelRead(quic.StreamErrorCode(quic.NoError))
+	p.ctrl = nil
+
+	d := time.Since(p.since)
+	p.idle += d
+	if !p.deadline.IsZero() {
+		p.deadline = p.deadline.Add(d)
+		p.s.SetReadDeadline(p.deadline)
+	}
+	fmt.Printf("Resumed the transfer at %.3f seconds, after %.3f seconds\n", time.Since(p.start).Seconds(), d.Seconds())
+}
+
+// toggle pauses the transfer if it is running and resumes it if it is
+// paused.
+func (p *pauser) toggle() {
+	p.mu.Lock()
+	paused := p.ctrl != nil
+	p.mu.Unlock()
+
+	if paused {
+		p.resume()
+		return
+	}
+	if err := p.pause(); err != nil {
+		log.Errorf("Error pausing the transfer: %v", err)
+	}
+}
+
+// stop ends the pause in progress, if any, and returns how many pauses
+// there were and how long they lasted in all.
+func (p *pauser) stop() (pauses int, idle time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.stopped = true
+	if p.ctrl != nil {
+		// Don't move the deadline of a download that is over.
+		p.deadline = time.Time{}
+		p.endLocked()
+	}
+	return p.pauses, p.idle
+}
+
+// schedule pauses the transfer after at and resumes it after for,
+// unless ctx is done first.
+func (p *pauser) schedule(ctx context.Context, at, length time.Duration) {
+	select {
+	case <-time.After(at):
+	case <-ctx.Done():
+		return
+	}
+	if err := p.pause(); err != nil {
+		log.Errorf("Error pausing the transfer: %v", err)
+		return
+	}
+	select {
+	case <-time.After(length):
+	case <-ctx.Done():
+	}
+	p.resume()
+}
+
+// activePauser is the pauser of the download in progress, which a
+// SIGUSR2 toggles.
+var activePauser struct {
+	sync.Mutex
+	p *pauser
+}
+
+func setActivePauser(p *pauser) {
+	activePauser.Lock()
+	defer activePauser.Unlock()
+
+	activePauser.p = p
+}
+
+// togglePause pauses or resumes the download in progress, if any.
+func togglePause() {
+	activePauser.Lock()
+	p := activePauser.p
+	activePauser.Unlock()
+
+	if p == nil {
+		log.Warningf("Got a SIGUSR2, but there is no download to pause or resume")
+		return
+	}
+	p.toggle()
+}
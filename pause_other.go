@@ -0,0 +1,19 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// watchPause pauses or resumes the download every time the process gets
+// a SIGUSR2.
+func watchPause() {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGUSR2)
+	for range ch {
+		togglePause()
+	}
+}
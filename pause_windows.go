@@ -0,0 +1,7 @@
+//go:build windows
+
+package main
+
+// watchPause does nothing on Windows, which has no SIGUSR2; -pause-at
+// still works there.
+func watchPause() {}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// spkiPin returns the pin of cert's public key: the SHA-256 hash of its
+// DER-encoded SubjectPublicKeyInfo in base64, prefixed with "sha256/", as
+// in HTTP Public Key Pinning.
+func spkiPin(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return "sha256/" + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// leafPin returns the pin of the public key of the leaf certificate in
+// cert.
+func leafPin(cert *tls.Certificate) (string, error) {
+	if len(cert.Certificate) == 0 {
+		return "", errors.New("no certificate")
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return "", err
+	}
+	return spkiPin(leaf), nil
+}
+
+// spkiPins is a flag.Value holding a comma-separated list of public key
+// pins, any one of which the server's certificate chain must match.
+type spkiPins []string
+
+func (p *spkiPins) String() string {
+	return strings.Join(*p, ",")
+}
+
+func (p *spkiPins) Set(s string) error {
+	var pins []string
+	for _, f := range strings.Split(s, ",") {
+		pin := strings.TrimSpace(f)
+		if !strings.HasPrefix(pin, "sha256/") {
+			return fmt.Errorf("invalid pin %q, want sha256/ followed by a base64 SHA-256 hash", pin)
+		}
+		if sum, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(pin, "sha256/")); err != nil || len(sum) != sha256.Size {
+			return fmt.Errorf("invalid pin %q, want sha256/ followed by a base64 SHA-256 hash", pin)
+		}
+		pins = append(pins, pin)
+	}
+	*p = pins
+	return nil
+}
+
+// verify is a tls.Config.VerifyPeerCertificate callback checking that a
+// certificate the server presented has one of the pinned public keys. It
+// runs after the usual verification unless that is skipped with
+// -insecure.
+func (p spkiPins) verify(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+	for _, raw := range rawCerts {
+		cert, err := x509.ParseCertificate(raw)
+		if err != nil {
+			return err
+		}
+		pin := spkiPin(cert)
+		for _, want := range p {
+			if pin == want {
+				return nil
+			}
+		}
+	}
+	return errors.New("no certificate presented by the server matches -pin")
+}
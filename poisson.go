@@ -0,0 +1,31 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+// runPoisson makes requests whose start times follow a Poisson process
+// with a mean of -poisson-rate requests per second, and whose response
+// sizes are drawn from -poisson-sizes, for the duration of the test.
+// Requests are started on schedule whether or not earlier ones have
+// completed, as they would be by many independent users.
+func runPoisson(ctx context.Context, conn quic.Connection) testResult {
+	cancelBulkStream(ctx, conn)
+
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(*durationInSecs)*time.Second)
+	defer cancel()
+
+	rng := newWorkloadRand()
+	var at time.Duration
+	next := func() (time.Duration, int, bool) {
+		at += time.Duration(rng.ExpFloat64() / *poissonRate * float64(time.Second))
+		return at, poissonSizes.sample(rng), true
+	}
+
+	fmt.Printf("Offering %.1f requests/s\n", *poissonRate)
+	return runScheduled(ctx, conn, next)
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// qlogCloseTimeout bounds how long -qlog-summary waits for quic-go to
+// finish writing a qlog after the connection is closed.
+const qlogCloseTimeout = 5 * time.Second
+
+// qlogFiles keeps track of the qlogs written during a test, so that they
+// can be summarized once quic-go has closed them.
+type qlogFiles struct {
+	mu    sync.Mutex
+	files []*qlogFile
+}
+
+type qlogFile struct {
+	name   string
+	closed chan struct{}
+}
+
+// add wraps w, the qlog written to the file name, so that it is known
+// when the qlog is complete.
+func (q *qlogFiles) add(name string, w io.WriteCloser) io.WriteCloser {
+	f := &qlogFile{name: name, closed: make(chan struct{})}
+	q.mu.Lock()
+	q.files = append(q.files, f)
+	q.mu.Unlock()
+	return &qlogCloser{WriteCloser: w, f: f}
+}
+
+type qlogCloser struct {
+	io.WriteCloser
+	f *qlogFile
+}
+
+func (c *qlogCloser) Close() error {
+	defer close(c.f.closed)
+	return c.WriteCloser.Close()
+}
+
+// printSummaries prints a summary of each qlog, waiting for quic-go to
+// finish writing it.
+func (q *qlogFiles) printSummaries() {
+	q.mu.Lock()
+	files := q.files
+	q.mu.Unlock()
+
+	for _, f := range files {
+		select {
+		case <-f.closed:
+		case <-time.After(qlogCloseTimeout):
+			log.Warningf("Not summarizing %s: the qlog wasn't closed within %s", f.name, qlogCloseTimeout)
+			continue
+		}
+		s, err := summarizeQlogFile(f.name)
+		if err != nil {
+			log.Errorf("Error summarizing %s: %v", f.name, err)
+			continue
+		}
+		fmt.Printf("Qlog summary of %s:\n", f.name)
+		s.print()
+	}
+}
+
+// qlogEvent is an event in a qlog written by quic-go, in the draft-02
+// NDJSON format: a header line followed by one event per line.
+type qlogEvent struct {
+	Time float64 `json:"time"` // milliseconds since the connection started
+	Name string  `json:"name"`
+	Data struct {
+		CongestionWindow *uint64 `json:"congestion_window"`
+		New              string  `json:"new"`
+		Frames           []struct {
+			FrameType string `json:"frame_type"`
+		} `json:"frames"`
+	} `json:"data"`
+}
+
+// qlogDigest is the digest of a qlog printed by -qlog-summary. The
+// congestion figures are those of the endpoint that wrote the qlog, so
+// they describe the data it sent rather than the data it received.
+type qlogDigest struct {
+	duration      time.Duration
+	handshake     time.Duration // zero if the handshake didn't complete
+	maxCwnd       uint64
+	packetsLost   uint64
+	lossEpisodes  uint64
+	timeRecovery  time.Duration
+	inRecovery    bool
+	recoveryStart time.Duration
+}
+
+func summarizeQlogFile(name string) (*qlogDigest, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return summarizeQlog(f)
+}
+
+// summarizeQlog reads a qlog and works out the handshake duration, the
+// largest congestion window, and how often and for how long the
+// congestion controller was in recovery.
+func summarizeQlog(r io.Reader) (*qlogDigest, error) {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64<<10), 16<<20)
+
+	// The first line is the header describing the trace.
+	if !sc.Scan() {
+		if err := sc.Err(); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("empty qlog")
+	}
+
+	s := &qlogDigest{}
+	for line := 2; sc.Scan(); line++ {
+		var ev qlogEvent
+		if err := json.Unmarshal(sc.Bytes(), &ev); err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		t := time.Duration(ev.Time * float64(time.Millisecond))
+		if t > s.duration {
+			s.duration = t
+		}
+
+		switch ev.Name {
+		case "transport:packet_received":
+			for _, f := range ev.Data.Frames {
+				if f.FrameType == "handshake_done" && s.handshake == 0 {
+					s.handshake = t
+				}
+			}
+		case "recovery:metrics_updated":
+			if cwnd := ev.Data.CongestionWindow; cwnd != nil && *cwnd > s.maxCwnd {
+				s.maxCwnd = *cwnd
+			}
+		case "recovery:packet_lost":
+			s.packetsLost++
+		case "recovery:congestion_state_updated":
+			switch {
+			case ev.Data.New == "recovery" && !s.inRecovery:
+				s.inRecovery, s.recoveryStart = true, t
+				s.lossEpisodes++
+			case ev.Data.New != "recovery" && s.inRecovery:
+				s.inRecovery = false
+				s.timeRecovery += t - s.recoveryStart
+			}
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if s.inRecovery {
+		s.timeRecovery += s.duration - s.recoveryStart
+	}
+	return s, nil
+}
+
+func (s *qlogDigest) print() {
+	if s.handshake > 0 {
+		fmt.Printf("  Handshake: %.3f ms\n", float64(s.handshake)/1e6)
+	} else {
+		fmt.Printf("  Handshake: not completed\n")
+	}
+	fmt.Printf("  Largest congestion window: %d bytes\n", s.maxCwnd)
+	fmt.Printf("  Packets lost: %d in %d loss episodes\n", s.packetsLost, s.lossEpisodes)
+	recovery := 0.0
+	if s.duration > 0 {
+		recovery = 100 * float64(s.timeRecovery) / float64(s.duration)
+	}
+	fmt.Printf("  Time in recovery: %.3f seconds (%.1f%% of %.3f seconds)\n",
+		s.timeRecovery.Seconds(), recovery, s.duration.Seconds())
+}
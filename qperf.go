@@ -4,18 +4,24 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"crypto/sha256"
 	"crypto/tls"
 	"encoding/binary"
+	"errors"
 	"flag"
 	"fmt"
+	"hash"
 	"io"
+	"math"
 	"math/rand"
 	"net"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"syscall"
 	"time"
 
-	"github.com/golang/glog"
 	"github.com/quic-go/quic-go"
 	"github.com/quic-go/quic-go/logging"
 	"github.com/quic-go/quic-go/qlog"
@@ -26,12 +32,194 @@ var (
 	cert           = flag.String("cert", "", "path to the tls certificate file")
 	addr           = flag.String("addr", ":32850", "listen on this address")
 	serve          = flag.Bool("s", false, "run as a server")
-	client         = flag.String("c", "localhost:32850", "run as a client to specified remote")
+	client         = flag.String("c", "localhost:32850", "run as a client to specified remote, or to each of a comma-separated list of remotes in turn")
 	insecure       = flag.Bool("insecure", false, "don't verify TLS certificate details")
+	sni            = flag.String("sni", "", "when running as a client, send this TLS server name and verify the server's certificate for it instead of the host given with -c")
 	qlogDir        = flag.String("qlog-dest-dir", "", "activate qlog writing and write the qlogs in this directory")
+	qlogSummary    = flag.Bool("qlog-summary", false, "when running as a client, print a digest of each qlog written to -qlog-dest-dir once the test is over")
 	durationInSecs = flag.Int64("seconds", 30, "run the test for this number of seconds.")
+	clientPort     = flag.Int("client-port", 0, "send from this local UDP port when running as a client (default: an ephemeral port)")
+	seed           = flag.Int64("seed", 0, "seed for the random payload and client workloads, so that runs with the same seed are repeatable (default: a time-based seed)")
+	sendFile       = flag.String("sendfile", "", "when running as a server, send the contents of this file instead of random data")
+	recvFile       = flag.String("recvfile", "", "when running as a client, write the received data to this file and print its SHA-256")
+	verify         = flag.Bool("verify", false, "send (server) or expect (client) sequence numbered, checksummed blocks and report any corruption; must be set on both sides")
+	bindDevice     = flag.String("interface", "", "bind the UDP socket to this network device, e.g. eth1, so that packets go through it whatever the routes say (Linux only)")
+	df             = flag.Bool("df", false, "report packets that appear to be dropped for being too large, setting the Don't Fragment bit on outgoing packets where quic-go doesn't already")
+	packetStats    = flag.Bool("packet-stats", false, "print the packets sent, received, acknowledged and lost and the PTO count in each packet number space")
+	reportNIC      = flag.Bool("nic-stats", false, "when running as a client, report how much the byte, packet, error and drop counters of the network interface used grew during the test (Linux only)")
+	disablePMTUD   = flag.Bool("disable-pmtud", false, "don't probe for a larger path MTU, capping packets at 1252 (IPv4) or 1232 (IPv6) bytes")
+	connectTimeout = flag.Duration("connect-timeout", 10*time.Second, "when running as a client, give up if the connection isn't established within this time (0 for no limit)")
+	rateBytes      = flag.Bool("bytes", false, "report rates in bytes rather than bits per second")
+	rateUnits      = flag.String("units", "k", "prefixes for rates: k for kilo, si to scale by powers of 1000 or iec to scale by powers of 1024")
+	logFormat      = flag.String("log-format", "text", "write logs to stderr as text or JSON lines (json), or through glog (glog)")
+	proxy          = flag.String("proxy", "", "relay the client's UDP traffic through this SOCKS5 proxy (host:port or socks5://[user:password@]host:port)")
+
+	masqueProxy = flag.String("masque-proxy", "", "tunnel the client's QUIC connection through the MASQUE proxy at this https URL with HTTP/3 CONNECT-UDP; a URL without a {target_host} template gets /.well-known/masque/udp/{target_host}/{target_port}/ added")
+
+	tryAddrs = flag.String("try-addrs", "", "when running as a client to a name with several addresses, first make a handshake with each of them, one after another (sequential) or all at once (race), and test with the fastest")
+
+	retries       = flag.Int("retries", 0, "when running as a client, retry connecting this many times if the server can't be reached")
+	retryInterval = flag.Duration("retry-interval", time.Second, "wait this long before the first retry, doubling the wait for each retry after that")
+
+	acmeDomain   = flag.String("acme-domain", "", "when running as a server, obtain and renew a certificate for this domain from Let's Encrypt instead of using -cert and -key")
+	acmeEmail    = flag.String("acme-email", "", "contact address to register with the ACME server")
+	acmeCacheDir = flag.String("acme-cache-dir", "qperf-acme", "directory to keep the ACME account key and certificates in")
+	acmeHTTPAddr = flag.String("acme-http-addr", ":80", "answer ACME HTTP-01 challenges on this TCP address")
+
+	sendLogInterval = flag.Duration("send-log-interval", 0, "when running as a server, log the bytes sent to each client, and how fairly they were shared, at this interval, e.g. 1s")
+
+	maxBytes = flag.Int64("max-bytes", 0, "when running as a server, end each download with a FIN after this many bytes, stop reading each upload after this many, and reset the RPC responses and echoes that take a connection past this many, whatever the client asks for (0 for no limit)")
+
+	handshakeIdleTimeout = flag.Duration("handshake-idle-timeout", 5*time.Second, "abort a connection attempt if nothing is heard from the peer for this long during the handshake")
+
+	acceptRate = flag.Float64("accept-rate", 0, "when running as a server, accept at most this many new connections per second on average, in bursts of up to a second's worth, refusing the rest")
+
+	retry         = flag.Bool("retry", false, "when running as a server, validate every client's address with a Retry packet before the handshake")
+	retryTokenAge = flag.Duration("retry-token-age", 5*time.Second, "when running as a server with -retry, accept the tokens of Retry packets for this long")
+
+	cidLength = flag.Int("cid-length", 0, "use connection IDs of this many bytes, from 4 to 18, for the connection IDs this end chooses; 0 leaves it to quic-go")
+
+	accessLogFile = flag.String("access-log", "", "when running as a server, append a line describing every connection attempt to this file")
+
+	showTransportParams = flag.Bool("show-transport-params", false, "when running as a client, print the transport parameters the server advertised")
+
+	datagrams        = flag.Bool("datagrams", true, "negotiate support for QUIC datagrams (RFC 9221), which the latency probes need; a server without it doesn't answer probes")
+	latencyUnderLoad = flag.Bool("latency-under-load", false, "when running as a client, measure the RTT with datagram probes before and during the transfer")
+	heartbeat        = flag.Duration("heartbeat", 0, "when running as a client, send a heartbeat at this interval if nothing else was sent, keeping a quiet connection from timing out, and report when the server stops answering them")
+	probeInterval    = flag.Duration("probe-interval", 100*time.Millisecond, "send latency probes at this interval")
+	oneWay           = flag.Bool("one-way", false, "also estimate the offset between the client's and server's clocks from the latency probes and report the one-way delay in each direction")
+
+	rpc             = flag.Bool("rpc", false, "when running as a client, make request/response round trips instead of a bulk transfer")
+	rpcRequestSize  = flag.Int("rpc-request-size", 64, "size of each request in bytes")
+	rpcResponseSize = flag.Int("rpc-response-size", 1024, "size of each response in bytes")
+	rpcConcurrency  = flag.Int("rpc-concurrency", 1, "number of requests to keep outstanding")
+	hgrmFile        = flag.String("hgrm", "", "write the distribution of request latencies to this file in HdrHistogram's .hgrm format")
+
+	burstSize = flag.Int("burst-size", 0, "when running as a client, receive bursts of this many bytes at full rate instead of a bulk transfer")
+	burstGap  = flag.Duration("burst-gap", time.Second, "idle for this long between bursts")
+
+	echo = flag.Bool("echo", false, "when running as a client, send on a bidirectional stream that the server echoes back and report the echoed throughput instead of a bulk transfer")
+
+	replayFile = flag.String("replay", "", "when running as a client, make the requests in this schedule file of timestamp and size lines instead of a bulk transfer")
+
+	rampStep = flag.Duration("ramp-step", 10*time.Second, "time spent at each rate of a -ramp")
+
+	direction = flag.String("direction", "download", "when running as a client, test the download from the server, the upload to it, or both at once")
+
+	stop      = flag.String("stop", "deadline", "when running as a client, how to end the download: stop reading after -seconds (deadline), also cancel the stream with STOP_SENDING then (reset), or have the server close it with a FIN after -stop-bytes (fin)")
+	stopBytes = flag.Int64("stop-bytes", 0, "with -stop fin, the number of bytes the server sends before closing the stream")
+
+	clientStream = flag.Bool("client-stream", false, "when running as a client, open the stream the download is sent on instead of accepting the one the server opens")
+
+	pauseAt  = flag.Duration("pause-at", 0, "when running as a client, have the server pause the download this far into it, for -pause-for, leaving the pause out of the throughput")
+	pauseFor = flag.Duration("pause-for", 5*time.Second, "how long a -pause-at pause lasts")
+
+	streams = flag.Int("streams", 1, "when running as a client, receive on this many parallel streams and report on each of them")
+
+	udpBackend = flag.String("udp-backend", "std", "send and receive UDP with the standard system calls (std) or, experimentally and on Linux only, with io_uring (iouring)")
+	cpuList    = flag.String("cpus", "", "run only on these CPUs, e.g. 0-3,8 (Linux only)")
+	recvBatch  = flag.Bool("recv-batch", true, "read packets in batches with recvmmsg where the platform supports it; -recv-batch=false reads one packet with each system call, to measure the difference")
+	sockets    = flag.Int("sockets", 1, "when running as a server, listen on this many sockets sharing -addr with SO_REUSEPORT, each with its own QUIC listener, to spread the load across cores (Linux only)")
+
+	advertiseMDNS = flag.Bool("advertise", false, "when running as a server, advertise the server on the local network with mDNS")
+	discoverMDNS  = flag.Bool("discover", false, "list the qperf servers advertised on the local network with mDNS, and exit")
+
+	showVersion = flag.Bool("version", false, "print the versions of qperf, its git commit, Go and quic-go, and exit")
+
+	lowMemory = flag.Bool("low-memory", false, "use little memory, for routers and other small devices: advertise small flow control windows, collect garbage more often and log only warnings and errors")
+
+	selfTest = flag.Bool("selftest", false, "run a server on an ephemeral loopback port and a client against it in this process, to check the host's UDP stack and the build without a second machine")
+
+	relay = flag.String("relay", "", "run as a relay, accepting connections on -addr like a server and forwarding their streams and datagrams to the qperf server at this address")
+
+	agent      = flag.String("agent", "", "run as an agent, running tests for a coordinator that connects to this address")
+	agentList  = flag.String("agents", "", "run as a coordinator, having each of these comma-separated agents run the test against the server in -c at the same time")
+	agentToken = flag.String("agent-token", "", "token that the coordinator, agents and control API clients use to authenticate requests; required with -agent")
+
+	statusAddr  = flag.String("status", "", "when running as a server, serve the active connections, their send rates and recently finished connections as JSON at /status, and stream live stats at /events, on this address")
+	healthAddr  = flag.String("health-addr", "", "when running as a server or relay, answer HTTP health checks on this address, with 200 once the QUIC listener is up")
+	controlAddr = flag.String("control", "", "when running as a server, only run tests when told to through a control API, JSON over HTTP rather than gRPC, served on this address")
+
+	sparklines  = flag.Bool("sparkline", false, "when running as a client, draw the throughput of each second of the download as a sparkline on the terminal, updated in place")
+	reportFile  = flag.String("report", "", "when running as a client, chart the throughput, RTT, loss and congestion window of the transfer in this standalone HTML file")
+	samplesFile = flag.String("samples", "", "when running as a client, write the bytes received, RTT, loss and congestion window of every 100ms of the transfer to this CSV file")
+	captureEnv  = flag.Bool("env", false, "when running as a client, record the kernel version, CPU, UDP buffer sysctls and the NIC's offload settings, print them and add them to the JSON results")
+	ndjson      = flag.Bool("ndjson", false, "when running as a client, write the throughput, smoothed RTT and loss of every second of the download, and then of the whole of it, to standard output as lines of JSON, moving the usual output to standard error")
+	gnuplotFile = flag.String("gnuplot", "", "when running as a client, write the time, throughput, smoothed RTT and loss of every 100ms of the transfer to this file as whitespace-separated columns for gnuplot, adding -2, -3 and so on to the name for later runs")
+
+	runs      = flag.Int("runs", 1, "when running as a client, run the test this many times and print the mean, 95% confidence interval and coefficient of variation of the throughput and RTT")
+	abA       = flag.String("ab-a", "", "when running as a client, compare runs with these semicolon-separated flag settings, e.g. \"transport-params=initial_max_data=1048576\", against runs with -ab-b")
+	abB       = flag.String("ab-b", "", "the flag settings for the B side of an -ab-a comparison, setting the same flags")
+	sweep     = flag.String("sweep", "", "when running as a client, run the test once for every combination of flag values in this list, e.g. \"streams=1,2,4,8;seconds=10,30\", and print a table of the results")
+	sweepJSON = flag.String("sweep-json", "", "also write the -sweep results to this file as JSON")
+
+	crossTraffic = flag.String("cross-traffic", "", "when running as a client, add a competing flow for the second half of the test: quic for a second bulk transfer or udp for datagrams sent to the server at -cross-rate")
+
+	connections = flag.Int("connections", 1, "when running as a client, receive the bulk transfer on this many connections at once and report how fairly they share the path")
+
+	mixed = flag.Bool("mixed", false, "when running as a client, make requests as -rpc does, alone for the first half of the test and alongside a bulk transfer on the same connection for the second half, and report how their latency changed")
+
+	web = flag.Int("web", 0, "when running as a client, load pages of this many objects, each requested at once on its own stream, one page after another instead of a bulk transfer")
+
+	poissonRate = flag.Float64("poisson-rate", 0, "when running as a client, start requests as a Poisson process with this mean rate per second instead of a bulk transfer")
+
+	emulateDelay  = flag.Duration("emulate-delay", 0, "delay the packets sent by this long, e.g. 80ms")
+	emulateJitter = flag.Duration("emulate-jitter", 0, "vary the delay of the packets sent randomly by up to this much either way, keeping them in order")
+	emulateQueue  = flag.Int("emulate-queue", 64<<10, "bytes that may wait for the -emulate-rate link before packets sent are dropped")
+
+	emulateReorderGap = flag.Int("emulate-reorder-gap", 3, "number of later packets that overtake each packet reordered by -emulate-reorder")
 )
 
+var (
+	minThroughput  bitRate
+	maxLoss        percentage
+	converge       percentage
+	poissonSizes   = sizeDist{kind: "fixed", min: 1024, max: 1024}
+	rampRates      bitRates
+	fctSizes       byteSizes
+	webSizes       = sizeDist{kind: "exp", min: 20000, max: 20000}
+	crossRate      bitRate
+	quicParams     transportParams
+	tlsCiphers     cipherSuites
+	tlsGroups      curveIDs
+	pins           spkiPins
+	emulateLoss    percentage
+	emulateRate    bitRate
+	emulateReorder percentage
+	maxConnRate    bitRate
+	totalRate      bitRate
+	allowCIDRs     cidrList
+	resultTags     tagList
+	denyCIDRs      cidrList
+)
+
+func init() {
+	flag.Var(&minThroughput, "min-throughput", "when running as a client, exit with status 3 if the throughput is below this rate, e.g. 500Mbps")
+	flag.Var(&poissonSizes, "poisson-sizes", "distribution of response sizes for -poisson-rate: fixed:N, uniform:MIN-MAX or exp:MEAN bytes")
+	flag.Var(&crossRate, "cross-rate", "rate of the udp -cross-traffic, e.g. 50Mbps")
+	flag.Var(&rampRates, "ramp", "when running as a client, receive at each of these comma-separated rates in turn instead of at full rate, e.g. 10M,50M,100M")
+	flag.Var(&webSizes, "web-sizes", "distribution of object sizes for -web: fixed:N, uniform:MIN-MAX or exp:MEAN bytes")
+	flag.Var(&fctSizes, "fct", "when running as a client, request objects of each of these comma-separated sizes in turn, one at a time, instead of a bulk transfer and report their completion times, e.g. 50KB,1MB,10MB")
+	flag.Var(&quicParams, "transport-params", "advertise these comma-separated transport parameters: max_idle_timeout (a duration), initial_max_data, initial_max_stream_data, initial_max_streams_bidi or initial_max_streams_uni, e.g. initial_max_data=1048576; the flow control windows set this way are initial ones, which auto-tuning can still grow to quic-go's maximum (or to the value set, if larger)")
+	flag.Var(&tlsCiphers, "tls-ciphers", "offer or accept only these comma-separated TLS 1.3 cipher suites, in order of preference, e.g. TLS_CHACHA20_POLY1305_SHA256")
+	flag.Var(&tlsGroups, "tls-groups", "offer or accept only these comma-separated key exchange groups, in order of preference: X25519, P256, P384 or P521")
+	flag.Var(&pins, "pin", "when running as a client, require the server's certificate chain to include one of these comma-separated public key pins, e.g. sha256/...; with -insecure, check only the pin")
+	flag.Var(&maxConnRate, "max-conn-rate", "when running as a server, send to each client at no more than this rate, e.g. 1Gbps")
+	flag.Var(&totalRate, "total-rate", "when running as a server, share this total send rate equally between the clients being served, e.g. 10Gbps")
+	flag.Var(&allowCIDRs, "allow-cidr", "when running as a server, ignore packets from addresses outside these comma-separated ranges, e.g. 192.0.2.0/24,2001:db8::/32")
+	flag.Var(&denyCIDRs, "deny-cidr", "when running as a server, ignore packets from addresses in these comma-separated ranges, even if -allow-cidr includes them")
+	flag.Var(&emulateLoss, "emulate-loss", "randomly drop this percentage of the packets sent, e.g. 1%")
+	flag.Var(&emulateRate, "emulate-rate", "send packets through an emulated link of this rate, queueing up to -emulate-queue bytes for it, e.g. 20Mbps")
+	flag.Var(&emulateReorder, "emulate-reorder", "send this percentage of the packets sent after the -emulate-reorder-gap packets that follow them, e.g. 1%")
+	flag.Var(&converge, "converge", "when running as a client, end the download once its throughput over the last 5 seconds is within this percentage of that over the 5 seconds before, e.g. 5%, or after -seconds at most")
+	flag.Var(&resultTags, "tag", "attach this key=value tag, e.g. site=ams1, to the JSON results of -ndjson, -sweep-json, the agent and the status API; may be repeated")
+	flag.Var(&maxLoss, "max-loss", "when running as a client, exit with status 3 if the estimated packet loss is above this percentage, e.g. 0.5%")
+}
+
+// exitThresholdNotMet is the exit status of a client whose results don't
+// meet the -min-throughput or -max-loss thresholds.
+const exitThresholdNotMet = 3
+
 var data [1 << 16]byte
 
 const alpnNextProto = "quic-perf-test"
@@ -59,139 +247,1099 @@ func (h bufferedWriteCloser) Close() error {
 }
 
 func serverMain(ctx context.Context) {
+	if *sockets < 1 {
+		log.Exitf("Fatal error: -sockets must be at least 1")
+	}
+	startHealth()
+
+	payloadSeed := *seed
+	if payloadSeed == 0 {
+		payloadSeed = time.Now().UnixNano()
+	}
+	rng := rand.New(rand.NewSource(payloadSeed))
+
 	buf := new(bytes.Buffer)
 	for i := 1; i <= len(data)/8; i++ {
-		err := binary.Write(buf, binary.LittleEndian, rand.Int63())
+		err := binary.Write(buf, binary.LittleEndian, rng.Int63())
 		if err != nil {
-			glog.Exitf("Fatal error generating random data: %v", err)
+			log.Exitf("Fatal error generating random data: %v", err)
 		}
 	}
 	copy(data[:], buf.Bytes())
+	log.Infof("Generated random payload with seed %d", payloadSeed)
+
+	c := serverTLSConfig()
 
-	cert, err := tls.LoadX509KeyPair(*cert, *key)
+	if *sendFile != "" && *verify {
+		log.Exitf("Fatal error: -sendfile and -verify can't be used together")
+	}
+	if *maxBytes < 0 {
+		log.Exitf("Fatal error: -max-bytes can't be negative")
+	}
+
+	if *sendFile != "" {
+		fi, err := os.Stat(*sendFile)
+		if err != nil {
+			log.Exitf("Fatal error opening file to send: %v", err)
+		}
+		sum, err := fileSHA256(*sendFile)
+		if err != nil {
+			log.Exitf("Fatal error reading file to send: %v", err)
+		}
+		log.Infof("Sending %s (%d bytes, SHA-256 %x) to each client", *sendFile, fi.Size(), sum)
+	}
+
+	qconf := &quic.Config{
+		EnableDatagrams:         *datagrams,
+		DisablePathMTUDiscovery: *disablePMTUD,
+		HandshakeIdleTimeout:    *handshakeIdleTimeout,
+		ConnectionIDLength:      *cidLength,
+		MaxRetryTokenAge:        *retryTokenAge,
+	}
+	if *retry {
+		qconf.RequireAddressValidation = func(net.Addr) bool { return true }
+	}
+	applyLowMemory(qconf)
+	quicParams.apply(qconf)
+	tracers := []logging.Tracer{resultsTracer{}}
+	if *accessLogFile != "" {
+		al, err := openAccessLog(*accessLogFile)
+		if err != nil {
+			log.Exitf("Fatal error opening access log: %v", err)
+		}
+		tracers = append(tracers, al)
+	}
+	qconf.Tracer = logging.NewMultiplexedTracer(tracers...)
+
+	listeners, err := listen(c, qconf)
 	if err != nil {
-		glog.Exitf("Fatal error loading TLS key pair: %v", err)
+		log.Exitf("Fatal error listening on %s: %v", *addr, err)
+	}
+	for _, l := range listeners {
+		log.Infof("Listening on address %v", l.Addr())
+		defer l.Close()
+	}
+	atomic.StoreInt32(&listenersUp, 1)
+	if serverListening != nil {
+		serverListening(listeners[0].Addr())
+	}
+	logBatching()
+
+	if len(allowCIDRs) > 0 {
+		log.Infof("Accepting packets only from %s", allowCIDRs.String())
+	}
+	if len(denyCIDRs) > 0 {
+		log.Infof("Ignoring packets from %s", denyCIDRs.String())
+	}
+	if *acceptRate > 0 {
+		burst := *acceptRate
+		if burst < 1 {
+			burst = 1
+		}
+		accepts = newTokenBucket(*acceptRate, burst)
+	}
+	if maxConnRate > 0 {
+		log.Infof("Sending to each client at no more than %s", formatRate(float64(maxConnRate)))
+	}
+	if totalRate > 0 {
+		log.Infof("Sharing %s equally between the clients being served", formatRate(float64(totalRate)))
+	}
+	if *sendLogInterval > 0 {
+		go logFairness(*sendLogInterval)
+	}
+	if *statusAddr != "" {
+		serverStatus = newStatusServer()
+		go func() {
+			log.Exitf("Fatal error serving the status API on %s: %v", *statusAddr, serverStatus.serve(*statusAddr))
+		}()
+	}
+	if *controlAddr != "" {
+		control = new(controlServer)
+		go func() {
+			log.Exitf("Fatal error serving the control API on %s: %v", *controlAddr, control.serve(*controlAddr))
+		}()
+	}
+	if *advertiseMDNS {
+		go func() {
+			if err := advertise(listeners[0].Addr().(*net.UDPAddr).Port); err != nil {
+				log.Errorf("Error advertising with mDNS: %v", err)
+			}
+		}()
+	}
+
+	for _, l := range listeners[1:] {
+		go acceptConns(ctx, l)
+	}
+	acceptConns(ctx, listeners[0])
+}
+
+// serverTLSConfig returns the TLS configuration of a server, or of a
+// relay facing its clients, with the certificate from -acme-domain or
+// -cert and -key.
+func serverTLSConfig() *tls.Config {
+	var getCertificate func(*tls.ClientHelloInfo) (*tls.Certificate, error)
+	if *acmeDomain != "" {
+		getCertificate = acmeGetCertificate(newACMEManager())
+	} else {
+		certs, err := newCertReloader(*cert, *key)
+		if err != nil {
+			log.Exitf("Fatal error loading TLS key pair: %v", err)
+		}
+		go certs.watch()
+		getCertificate = certs.getCertificate
+		if cert, _ := certs.getCertificate(nil); cert != nil {
+			if pin, err := leafPin(cert); err == nil {
+				log.Infof("Clients can pin this server's certificate with -pin %s", pin)
+			}
+		}
 	}
 
 	c := &tls.Config{
-		Certificates:       []tls.Certificate{cert},
+		GetCertificate:     getCertificate,
 		NextProtos:         []string{alpnNextProto},
 		InsecureSkipVerify: *insecure,
 	}
+	restrictTLS(c)
+	return c
+}
 
-	l, err := quic.ListenAddr(*addr, c, nil)
-	if err != nil {
-		glog.Exitf("Fatal error listening on %s: %v", *addr, err)
-	}
-
-	glog.Infof("Listening on address %v", *addr)
-	defer l.Close()
+// accepts limits the rate of new connections, or is nil if -accept-rate
+// isn't set.
+var accepts *tokenBucket
 
+// acceptConns accepts connections on l and starts serving them.
+func acceptConns(ctx context.Context, l quic.Listener) {
 	for {
 		conn, err := l.Accept(ctx)
 		if err != nil {
-			glog.Errorf("Error accepting connection: %v", err)
+			log.Errorf("Error accepting connection: %v", err)
+			continue
+		}
+		if accepts != nil && !accepts.allow() {
+			log.forConn(conn).Warningf("Refusing connection from %s as -accept-rate is exceeded", conn.RemoteAddr())
+			conn.CloseWithError(errorCodeBusy, "too many new connections")
+			continue
+		}
+		if control != nil && !control.admit(conn) {
+			log.forConn(conn).Infof("Turning away connection from %s as no test is running", conn.RemoteAddr())
+			conn.CloseWithError(errorCodeNoTest, "no test running")
 			continue
 		}
-		glog.Infof("Accepted connection from %s", conn.RemoteAddr())
+		log.forConn(conn).Infof("Accepted connection from %s", conn.RemoteAddr())
+		if serverStatus != nil {
+			serverStatus.add(conn)
+		}
 
-		go func(conn quic.Connection) {
-			nBytes := uint64(0)
-			defer func() {
-				glog.Infof("Wrote %d bytes to client: %s", nBytes, conn.RemoteAddr())
-			}()
+		go handleConn(ctx, conn)
+		go serveRPCs(ctx, conn)
+		if conn.ConnectionState().SupportsDatagrams {
+			go echoDatagrams(conn)
+		} else {
+			log.forConn(conn).Infof("Datagrams weren't negotiated with %s", conn.RemoteAddr())
+		}
+	}
 
-			glog.Infof("Opening Unidirectional stream connection to client: %s", conn.RemoteAddr())
-			s, err := conn.OpenUniStreamSync(ctx)
-			if err != nil {
-				glog.Errorf("Error opening unidirectional stream to  client: %s: %v", conn.RemoteAddr(), err)
+}
+
+// handleConn opens a unidirectional stream to the client on conn, as soon
+// as the client allows it to, and sends the payload on it.
+func handleConn(ctx context.Context, conn quic.Connection) {
+	clog := log.forConn(conn)
+
+	clog.Infof("Opening Unidirectional stream connection to client: %s", conn.RemoteAddr())
+	s, err := conn.OpenUniStreamSync(ctx)
+	if err != nil {
+		// A client that allows no unidirectional streams, as for
+		// -direction upload, closes the connection while we wait.
+		if !closedByPeer(err) {
+			clog.Errorf("Error opening unidirectional stream to  client: %s: %v", conn.RemoteAddr(), err)
+		}
+		return
+	}
+	sendBulk(ctx, conn, s, clog, 0)
+}
+
+// sendBulk writes the payload to s until the client goes away, limit
+// bytes have been sent if limit isn't 0 or, with -sendfile, the whole
+// file has been sent, then closes s. -max-bytes caps limit.
+func sendBulk(ctx context.Context, conn quic.Connection, s quic.SendStream, clog *logger, limit uint64) {
+	defer s.Close()
+
+	if *maxBytes > 0 && (limit == 0 || limit > uint64(*maxBytes)) {
+		limit = uint64(*maxBytes)
+	}
+
+	out, done := sendTo(conn, s)
+	defer done()
+	if sc := lookupServerConn(conn); sc != nil {
+		out = &pauseWriter{ctx: ctx, gate: &sc.pause, w: out}
+	}
+	w := &sendCounter{w: out}
+	defer trackBulk(conn, w)()
+	if control != nil {
+		control.track(conn, w)
+	}
+	if serverStatus != nil {
+		serverStatus.track(conn, w)
+	}
+	defer func() {
+		clog.Infof("Wrote %d bytes to client: %s", w.bytes(), conn.RemoteAddr())
+	}()
+	if *sendLogInterval > 0 {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		go logSendRate(ctx, clog, w, *sendLogInterval)
+	}
+
+	if *sendFile != "" {
+		if _, err := writeFile(w, *sendFile, limit); err != nil && !closedByPeer(err) {
+			clog.Errorf("Error sending %s to client: %s: %v", *sendFile, conn.RemoteAddr(), err)
+		}
+		return
+	}
+
+	block := data[:]
+	if *verify {
+		block = make([]byte, verifyBlockSize)
+		copy(block, data[:])
+	}
+
+	for seq := uint64(0); limit == 0 || w.bytes() < limit; seq++ {
+		if *verify {
+			sealBlock(block, seq)
+		}
+		b := block
+		if limit > 0 && limit-w.bytes() < uint64(len(b)) {
+			b = b[:limit-w.bytes()]
+		}
+		if _, err := w.Write(b); err != nil {
+			if closedByPeer(err) {
 				return
 			}
-			defer s.Close()
-
-			for {
-				n, err := s.Write(data[:])
-				if err != nil {
-					if e, ok := err.(*quic.ApplicationError); ok {
-						if e.ErrorCode == quic.ApplicationErrorCode(0) {
-							return
-						}
-					}
-					glog.Errorf("Error writing to client: %s: %v", conn.RemoteAddr(),
-						err)
-					return
-				}
-				nBytes += uint64(n)
+			clog.Errorf("Error writing to client: %s: %v", conn.RemoteAddr(),
+				err)
+			return
+		}
+	}
+}
+
+// echoDatagrams sends every datagram received on conn back to the client,
+// so that clients can use them as latency probes.
+func echoDatagrams(conn quic.Connection) {
+	for {
+		msg, err := conn.ReceiveMessage()
+		if err != nil {
+			return
+		}
+		if len(msg) == timedProbeLen {
+			binary.BigEndian.PutUint64(msg[probeLen:], uint64(time.Now().UnixNano()))
+		}
+		if err := conn.SendMessage(msg); err != nil {
+			log.forConn(conn).Errorf("Error echoing datagram to client: %s: %v", conn.RemoteAddr(), err)
+			return
+		}
+	}
+}
+
+// writeFile copies the file at path to w, or only its first limit bytes
+// unless limit is 0, returning the number of bytes written.
+func writeFile(w io.Writer, path string, limit uint64) (uint64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if limit > 0 {
+		r = io.LimitReader(f, int64(limit))
+	}
+	n, err := io.Copy(w, r)
+	return uint64(n), err
+}
+
+// fileSHA256 returns the SHA-256 digest of the contents of the file at path.
+func fileSHA256(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// closedByPeer reports whether err is the result of the client closing the
+// connection or stopping the stream without signaling an error.
+func closedByPeer(err error) bool {
+	var appErr *quic.ApplicationError
+	if errors.As(err, &appErr) {
+		return appErr.ErrorCode == quic.ApplicationErrorCode(0)
+	}
+	var streamErr *quic.StreamError
+	if errors.As(err, &streamErr) {
+		return streamErr.ErrorCode == quic.StreamErrorCode(0)
+	}
+	return false
+}
+
+// listen starts listening for QUIC connections on the socket passed by
+// systemd if we were socket activated, and otherwise on -addr, with a
+// listener for each of the -sockets sockets sharing it.
+func listen(tlsConfig *tls.Config, qconf *quic.Config) ([]quic.Listener, error) {
+	udpConn, err := systemdPacketConn()
+	if err != nil {
+		return nil, err
+	}
+	if udpConn != nil {
+		if *sockets > 1 || *udpBackend == "iouring" {
+			udpConn.Close()
+			return nil, errors.New("-sockets and -udp-backend iouring can't be used with systemd socket activation")
+		}
+		l, err := listenOn(udpConn, tlsConfig, qconf)
+		if err != nil {
+			return nil, err
+		}
+		return []quic.Listener{l}, nil
+	}
+
+	if *udpBackend == "iouring" {
+		pconn, err := listenIOUring(*addr)
+		if err != nil {
+			return nil, err
+		}
+		if filteringAddrs() {
+			pconn = filteredConn{pconn}
+		}
+		if emulating() {
+			pconn = emulated(pconn)
+		}
+		l, err := quic.Listen(pconn, tlsConfig, qconf)
+		if err != nil {
+			pconn.Close()
+			return nil, err
+		}
+		return []quic.Listener{l}, nil
+	}
+
+	if *sockets == 1 {
+		if !*df && *recvBatch && !filteringAddrs() && !emulating() && !smallSendBuffers {
+			l, err := quic.ListenAddr(*addr, tlsConfig, qconf)
+			if err != nil {
+				return nil, err
 			}
-		}(conn)
+			return []quic.Listener{l}, nil
+		}
+
+		laddr, err := net.ResolveUDPAddr("udp", *addr)
+		if err != nil {
+			return nil, err
+		}
+		udpConn, err = net.ListenUDP("udp", laddr)
+		if err != nil {
+			return nil, err
+		}
+		l, err := listenOn(udpConn, tlsConfig, qconf)
+		if err != nil {
+			return nil, err
+		}
+		return []quic.Listener{l}, nil
 	}
 
+	var ls []quic.Listener
+	closeAll := func() {
+		for _, l := range ls {
+			l.Close()
+		}
+	}
+	address := *addr
+	for i := 0; i < *sockets; i++ {
+		udpConn, err := listenReusePort(address)
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		// If -addr leaves the port to the kernel, the other sockets
+		// need to share the one the first was given.
+		address = udpConn.LocalAddr().String()
+
+		l, err := listenOn(udpConn, tlsConfig, qconf)
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		ls = append(ls, l)
+	}
+	return ls, nil
 }
 
-func clientMain(ctx context.Context) {
+// listenOn starts a QUIC listener on udpConn. First it:
+//
+//   - enlarges the send buffer where the platform's default is small;
+//   - binds udpConn to the -interface device, if any;
+//   - sets the Don't Fragment bit if -df is set;
+//   - drops the packets from the addresses -allow-cidr and -deny-cidr
+//     exclude;
+//   - hides its batched reads from quic-go if -recv-batch is turned off;
+//   - impairs the packets it sends as the -emulate flags say.
+func listenOn(udpConn *net.UDPConn, tlsConfig *tls.Config, qconf *quic.Config) (quic.Listener, error) {
+	tuneSendBuffer(udpConn)
+	if *bindDevice != "" {
+		if err := bindToDevice(udpConn, *bindDevice); err != nil {
+			udpConn.Close()
+			return nil, fmt.Errorf("binding to device %s: %w", *bindDevice, err)
+		}
+	}
+	if *df {
+		if err := setDF(udpConn); err != nil {
+			udpConn.Close()
+			return nil, err
+		}
+	}
+	var pconn net.PacketConn = udpConn
+	if filteringAddrs() {
+		pconn = filteredUDPConn{unbatchedConn{PacketConn: udpConn, udpConn: udpConn}}
+	} else if !*recvBatch {
+		pconn = unbatched(udpConn)
+	}
+	if emulating() {
+		pconn = emulated(pconn)
+	}
+	return quic.Listen(pconn, tlsConfig, qconf)
+}
+
+// dial establishes the QUIC connection to the server at addr, normally
+// -c, over the packet connection clientPacketConn sets up for it.
+func dial(ctx context.Context, addr string, tlsConfig *tls.Config, qconf *quic.Config) (quic.Connection, error) {
+	pconn, raddr, err := clientPacketConn(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	if pconn == nil {
+		return quic.DialAddrContext(ctx, addr, tlsConfig, qconf)
+	}
+	return dialOn(ctx, pconn, raddr, tlsConfig, qconf)
+}
+
+// clientPacketConn sets up the packet connection to reach the server at
+// addr from the local port given by -client-port on the device given by
+// -interface, relaying through the SOCKS5 proxy given by -proxy or
+// tunneling through the MASQUE proxy given by -masque-proxy if there is
+// one. It returns a nil connection if none of the flags needs one,
+// leaving quic-go to make its own.
+func clientPacketConn(ctx context.Context, addr string) (net.PacketConn, *net.UDPAddr, error) {
+	if *proxy == "" && *masqueProxy == "" && *clientPort == 0 && *bindDevice == "" && !*df && *recvBatch && *udpBackend == "std" && !emulating() && !smallSendBuffers {
+		return nil, nil, nil
+	}
+
+	raddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var pconn net.PacketConn
+	if *masqueProxy != "" {
+		pconn, err = dialMASQUE(ctx, *masqueProxy, addr, raddr)
+		if err != nil {
+			return nil, nil, fmt.Errorf("tunneling through MASQUE proxy %s: %w", *masqueProxy, err)
+		}
+		log.Infof("Tunneling UDP traffic through MASQUE proxy %s", *masqueProxy)
+		if emulating() {
+			pconn = emulated(pconn)
+		}
+		return pconn, raddr, nil
+	}
+	if *udpBackend == "iouring" {
+		pconn, err = listenIOUring(fmt.Sprintf(":%d", *clientPort))
+		if err != nil {
+			return nil, nil, fmt.Errorf("binding to local UDP port %d: %w", *clientPort, err)
+		}
+		log.Infof("Using the experimental io_uring UDP backend")
+		if emulating() {
+			pconn = emulated(pconn)
+		}
+		return pconn, raddr, nil
+	}
+
+	udpConn, err := net.ListenUDP("udp", &net.UDPAddr{Port: *clientPort})
+	if err != nil {
+		return nil, nil, fmt.Errorf("binding to local UDP port %d: %w", *clientPort, err)
+	}
+	tuneSendBuffer(udpConn)
+	if *bindDevice != "" {
+		if err := bindToDevice(udpConn, *bindDevice); err != nil {
+			udpConn.Close()
+			return nil, nil, fmt.Errorf("binding to device %s: %w", *bindDevice, err)
+		}
+	}
+	if *df {
+		if err := setDF(udpConn); err != nil {
+			udpConn.Close()
+			return nil, nil, err
+		}
+	}
+
+	pconn = udpConn
+	if !*recvBatch {
+		pconn = unbatched(udpConn)
+	}
+	if *proxy != "" {
+		sconn, err := dialSOCKS5UDP(ctx, *proxy, udpConn)
+		if err != nil {
+			udpConn.Close()
+			return nil, nil, fmt.Errorf("setting up UDP association with proxy %s: %w", *proxy, err)
+		}
+		log.Infof("Relaying UDP traffic through SOCKS5 proxy %s (relay address %s)", *proxy, sconn.relay)
+		pconn = sconn
+	}
+	if emulating() {
+		pconn = emulated(pconn)
+	}
+	return pconn, raddr, nil
+}
+
+// dialOn establishes the QUIC connection to raddr over pconn, closing
+// pconn when the connection is closed.
+func dialOn(ctx context.Context, pconn net.PacketConn, raddr *net.UDPAddr, tlsConfig *tls.Config, qconf *quic.Config) (quic.Connection, error) {
+	log.Infof("Sending from local address %s", pconn.LocalAddr())
+
+	conn, err := quic.DialContext(ctx, pconn, raddr, *client, tlsConfig, qconf)
+	if err != nil {
+		pconn.Close()
+		return nil, err
+	}
+
+	// quic-go doesn't close packet connections it didn't create itself.
+	go func() {
+		<-conn.Context().Done()
+		pconn.Close()
+	}()
+	return conn, nil
+}
+
+// dialRetrying calls dial, giving each attempt -connect-timeout to
+// succeed. Transient failures are retried up to -retries times, waiting
+// -retry-interval before the first retry and twice as long as the last
+// wait before each one after that.
+func dialRetrying(ctx context.Context, tlsConfig *tls.Config, qconf *quic.Config) (quic.Connection, error) {
+	wait := *retryInterval
+	for attempt := 0; ; attempt++ {
+		dialCtx, cancel := ctx, context.CancelFunc(func() {})
+		if *connectTimeout > 0 {
+			dialCtx, cancel = context.WithTimeout(ctx, *connectTimeout)
+		}
+		conn, err := dial(dialCtx, *client, tlsConfig, qconf)
+		cancel()
+		if err == nil || attempt >= *retries || !transientDialError(err) {
+			return conn, err
+		}
+
+		log.Warningf("Error connecting to %s (attempt %d of %d), retrying in %v: %v", *client, attempt+1, *retries+1, wait, err)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(wait):
+		}
+		wait *= 2
+	}
+}
+
+// transientDialError reports whether err is a failure to connect that may
+// go away by itself, such as the server not having started yet.
+func transientDialError(err error) bool {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	return errors.Is(err, syscall.ECONNREFUSED)
+}
+
+// initialPacketSize returns the size of the packets quic-go sends before
+// it has discovered the path MTU to the host at addr.
+func initialPacketSize(addr string) int {
+	if raddr, err := net.ResolveUDPAddr("udp", addr); err == nil && raddr.IP.To4() == nil {
+		return 1232
+	}
+	return 1252
+}
+
+// testResult holds the client's measurements from a test.
+type testResult struct {
+	bytes    uint64
+	duration time.Duration
+	loss     float64
+	rtt      time.Duration // smoothed, at the end of the test
+	env      *environment  // with -env
+}
+
+// throughput returns the measured throughput in bits per second.
+func (r testResult) throughput() float64 {
+	return float64(r.bytes) * 8 / r.duration.Seconds()
+}
+
+// checkThresholds reports whether r meets the -min-throughput and
+// -max-loss thresholds, printing any that it doesn't meet.
+func checkThresholds(r testResult) bool {
+	ok := true
+	if minThroughput > 0 && r.throughput() < float64(minThroughput) {
+		fmt.Printf("FAIL: throughput %s is below the minimum of %s\n",
+			formatRate(r.throughput()), formatRate(float64(minThroughput)))
+		ok = false
+	}
+	if maxLoss.set && r.loss*100 > maxLoss.value {
+		fmt.Printf("FAIL: estimated packet loss %.3f%% is above the maximum of %.3f%%\n",
+			r.loss*100, maxLoss.value)
+		ok = false
+	}
+	return ok
+}
+
+// clientMain runs the test against the server in -c and returns its
+// result, or an error if the test couldn't be run.
+func clientMain(ctx context.Context) (res testResult, err error) {
 	host, _, err := net.SplitHostPort(*client)
 	if err != nil {
-		glog.Exitf("Fatal error parsing server address: %v", err)
+		return testResult{}, fmt.Errorf("parsing server address: %w", err)
+	}
+
+	if *rpcRequestSize < rpcHeaderLen {
+		return testResult{}, fmt.Errorf("-rpc-request-size must be at least %d bytes", rpcHeaderLen)
+	}
+	if *rpcResponseSize < 0 || int64(*rpcResponseSize) > math.MaxUint32 {
+		return testResult{}, fmt.Errorf("-rpc-response-size must be between 0 and %d bytes", uint32(math.MaxUint32))
+	}
+	if *rpcResponseSize == controlMagicSize || *burstSize == controlMagicSize {
+		return testResult{}, fmt.Errorf("-rpc-response-size and -burst-size can't be %d bytes, which is reserved for the control stream", controlMagicSize)
+	}
+	if *rpcConcurrency < 1 {
+		return testResult{}, errors.New("-rpc-concurrency must be at least 1")
+	}
+	if *streams < 1 {
+		return testResult{}, errors.New("-streams must be at least 1")
+	}
+	if *connections < 1 {
+		return testResult{}, errors.New("-connections must be at least 1")
+	}
+	switch *crossTraffic {
+	case "", "quic":
+	case "udp":
+		if crossRate <= 0 {
+			return testResult{}, errors.New("-cross-traffic udp needs a -cross-rate")
+		}
+	default:
+		return testResult{}, fmt.Errorf("unknown -cross-traffic %q, want quic or udp", *crossTraffic)
+	}
+	if *latencyUnderLoad && !*datagrams {
+		return testResult{}, errors.New("-latency-under-load needs -datagrams")
+	}
+	if *oneWay && !*latencyUnderLoad {
+		return testResult{}, errors.New("-one-way needs -latency-under-load")
+	}
+	if *qlogSummary && *qlogDir == "" {
+		return testResult{}, errors.New("-qlog-summary needs a -qlog-dest-dir")
+	}
+	if *burstSize < 0 || int64(*burstSize) > math.MaxUint32 {
+		return testResult{}, fmt.Errorf("-burst-size must be between 0 and %d bytes", uint32(math.MaxUint32))
+	}
+
+	for _, size := range fctSizes {
+		if size > math.MaxUint32 {
+			return testResult{}, fmt.Errorf("-fct sizes must be at most %d bytes", uint32(math.MaxUint32))
+		}
 	}
 
+	// Each workload replaces the bulk transfer, so only one can be used.
+	workloads := 0
+	for _, on := range []bool{*rpc, *burstSize > 0, *poissonRate > 0, len(rampRates) > 0, *replayFile != "", *streams > 1, *connections > 1, *crossTraffic != "", *echo, len(fctSizes) > 0, *web > 0, *mixed} {
+		if on {
+			workloads++
+		}
+	}
+	if workloads > 1 {
+		return testResult{}, errors.New("only one of -rpc, -burst-size, -poisson-rate, -ramp, -replay, -streams, -connections, -cross-traffic, -echo, -fct, -web and -mixed can be used")
+	}
+	dir, ok := directionNames[*direction]
+	if !ok {
+		return testResult{}, fmt.Errorf("unknown -direction %q, want download, upload or both", *direction)
+	}
+	switch *stop {
+	case "deadline", "reset":
+		if *stopBytes != 0 {
+			return testResult{}, errors.New("-stop-bytes needs -stop fin")
+		}
+	case "fin":
+		if *stopBytes <= 0 {
+			return testResult{}, errors.New("-stop fin needs a positive -stop-bytes")
+		}
+	default:
+		return testResult{}, fmt.Errorf("unknown -stop %q, want deadline, reset or fin", *stop)
+	}
+	if converge.set && (workloads > 0 || dir == directionUpload || *stop == "fin") {
+		return testResult{}, errors.New("-converge only applies to the download, so it can't be used with -direction upload, -stop fin or a workload")
+	}
+	if *stop != "deadline" && (workloads > 0 || dir == directionUpload) {
+		return testResult{}, errors.New("-stop only changes how the download ends, so it can't be used with -direction upload or a workload")
+	}
+	if *clientStream && (workloads > 0 || dir == directionUpload) {
+		return testResult{}, errors.New("-client-stream only changes how the download is received, so it can't be used with -direction upload or a workload")
+	}
+	if *pauseAt < 0 || *pauseFor <= 0 {
+		return testResult{}, errors.New("-pause-at can't be negative and -pause-for must be positive")
+	}
+	if *pauseAt > 0 && (workloads > 0 || dir == directionUpload) {
+		return testResult{}, errors.New("-pause-at only pauses the download, so it can't be used with -direction upload or a workload")
+	}
+	if dir != directionDownload && workloads > 0 {
+		return testResult{}, fmt.Errorf("-direction %s can't be used with -rpc, -burst-size, -poisson-rate, -ramp, -replay, -streams, -connections, -cross-traffic, -echo, -fct, -web or -mixed", *direction)
+	}
+
+	serverName := host
+	if *sni != "" {
+		serverName = *sni
+	}
 	tlsConfig := &tls.Config{
-		NextProtos: []string{alpnNextProto},
-		ServerName: host,
+		NextProtos:         []string{alpnNextProto},
+		ServerName:         serverName,
+		InsecureSkipVerify: *insecure,
 	}
+	if len(pins) > 0 {
+		tlsConfig.VerifyPeerCertificate = pins.verify
+	}
+	restrictTLS(tlsConfig)
 
 	var qconf quic.Config
-	qconf.EnableDatagrams = true
+	qconf.EnableDatagrams = *datagrams
+	qconf.DisablePathMTUDiscovery = *disablePMTUD
+	qconf.HandshakeIdleTimeout = *handshakeIdleTimeout
+	qconf.ConnectionIDLength = *cidLength
+	qconf.KeepAlivePeriod = *heartbeat
+	applyLowMemory(&qconf)
+	quicParams.apply(&qconf)
+	if dir == directionUpload {
+		// Keep the server from opening the bulk transfer stream, so
+		// that no download competes with the upload.
+		qconf.MaxIncomingUniStreams = -1
+	}
 
+	stats := newConnStats()
+	var env *environment
+	defer func() {
+		res.rtt, _ = stats.rtt()
+		res.env = env
+	}()
+	var tracers []logging.Tracer
+
+	var qlogs qlogFiles
+	if *qlogSummary {
+		// Deferred before the connection is closed, so this runs after.
+		defer qlogs.printSummaries()
+	}
 	if *qlogDir != "" {
-		glog.Infof("Qlog logging enabled, will write qlog files to this dir: %s", *qlogDir)
-		qconf.Tracer = qlog.NewTracer(func(_ logging.Perspective, connID []byte) io.WriteCloser {
+		log.Infof("Qlog logging enabled, will write qlog files to this dir: %s", *qlogDir)
+		tracers = append(tracers, qlog.NewTracer(func(_ logging.Perspective, connID []byte) io.WriteCloser {
 			baseName := fmt.Sprintf("client_%x.qlog", connID)
 			fname := filepath.Join(*qlogDir, baseName)
 			f, err := os.Create(fname)
 			if err != nil {
-				glog.Fatalf("Qlog: Failed to create file: %s: %v", fname, err)
+				log.Exitf("Qlog: Failed to create file: %s: %v", fname, err)
 			}
-			glog.Infof("Created new qlog file: %s", fname)
-			return newBufferedWriteCloser(bufio.NewWriter(f), f)
-		})
+			log.Infof("Created new qlog file: %s", fname)
+			return qlogs.add(fname, newBufferedWriteCloser(bufio.NewWriter(f), f))
+		}))
 
 	}
+	tracers = append(tracers, statsTracer{stats: stats})
+	qconf.Tracer = logging.NewMultiplexedTracer(tracers...)
+
+	if *tryAddrs != "" {
+		a, err := pickAddress(ctx, tlsConfig, &qconf)
+		if err != nil {
+			return testResult{}, fmt.Errorf("trying the addresses of %s: %w", *client, err)
+		}
+		// Later runs, e.g. of a -sweep, resolve the name again.
+		defer func(c string) { *client = c }(*client)
+		*client = a
+	}
 
-	conn, err := quic.DialAddrContext(ctx, *client, tlsConfig, &qconf)
+	dialStart := time.Now()
+	conn, err := dialRetrying(ctx, tlsConfig, &qconf)
 	if err != nil {
-		glog.Exitf("Fatal error establishing connection: %v", err)
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			hint := ""
+			if *df {
+				hint = fmt.Sprintf("; the path may be dropping the %d byte handshake packets sent with the Don't Fragment bit set", initialPacketSize(*client))
+			}
+			return testResult{}, fmt.Errorf("establishing connection: no connection to %s after %.1f seconds: %w%s",
+				*client, time.Since(dialStart).Seconds(), err, hint)
+		}
+		return testResult{}, fmt.Errorf("establishing connection: %w", err)
 	}
+	connected := time.Now()
 	defer conn.CloseWithError(quic.ApplicationErrorCode(quic.NoError), "done")
+	if *packetStats {
+		defer stats.printSpaces()
+	}
+	printConnectionDetails(conn, tlsConfig)
+	stats.printHandshake()
+	if *insecure {
+		printPeerCertificates(conn.ConnectionState().TLS.PeerCertificates)
+	}
+	if *showTransportParams {
+		printTransportParams(stats.transportParams())
+	}
+	if *captureEnv {
+		env = captureEnvironment(conn.RemoteAddr())
+		env.print()
+	}
+	drops := snapshotUDPDrops(conn.LocalAddr())
+	defer drops.report()
+	if *reportNIC {
+		nic, err := snapshotNICStats(conn.RemoteAddr())
+		if err != nil {
+			return testResult{}, fmt.Errorf("reading network interface counters: %w", err)
+		}
+		defer nic.report()
+	}
+	if *heartbeat > 0 {
+		w := newPathWatcher(stats, *heartbeat)
+		watchCtx, cancel := context.WithCancel(ctx)
+		defer w.report()
+		defer cancel()
+		go w.run(watchCtx)
+	}
 
-	s, err := conn.AcceptUniStream(ctx)
-	if err != nil {
-		glog.Exitf("Fatal error accepting unidirectional stream from %s: %v", conn.RemoteAddr(), err)
+	if dir == directionUpload {
+		r, err := runUpload(ctx, conn, dir)
+		if err != nil {
+			return testResult{}, fmt.Errorf("uploading to %s: %w", conn.RemoteAddr(), err)
+		}
+		r.print()
+		return testResult{bytes: r.received, duration: r.duration}, nil
+	}
+	var (
+		upload   uploadResult
+		uploaded chan error
+	)
+	if dir == directionBoth {
+		uploaded = make(chan error, 1)
+		go func() {
+			var err error
+			upload, err = runUpload(ctx, conn, dir)
+			uploaded <- err
+		}()
+	}
+
+	if *rpc {
+		return runRPCs(ctx, conn), nil
+	}
+	if *burstSize > 0 {
+		return runBursts(ctx, conn), nil
+	}
+	if *poissonRate > 0 {
+		return runPoisson(ctx, conn), nil
+	}
+	if len(rampRates) > 0 {
+		return runRamp(ctx, conn, stats), nil
+	}
+	if *replayFile != "" {
+		return runReplay(ctx, conn), nil
+	}
+	if *streams > 1 {
+		return runStreams(ctx, conn), nil
 	}
+	if *echo {
+		return runEcho(ctx, conn), nil
+	}
+	if len(fctSizes) > 0 {
+		return runFCT(ctx, conn), nil
+	}
+	if *web > 0 {
+		return runWeb(ctx, conn), nil
+	}
+	if *mixed {
+		return runMixed(ctx, conn), nil
+	}
+	dialAnother := func() (quic.Connection, error) {
+		// The stats tracer, which is last, only follows the first
+		// connection.
+		conf := qconf.Clone()
+		conf.Tracer = logging.NewMultiplexedTracer(tracers[:len(tracers)-1]...)
+		return dialRetrying(ctx, tlsConfig, conf)
+	}
+	if *connections > 1 {
+		return runConnections(ctx, conn, dialAnother), nil
+	}
+	if *crossTraffic != "" {
+		return runCrossTraffic(ctx, conn, stats, dialAnother), nil
+	}
+
+	var prober *latencyProber
+	if *latencyUnderLoad {
+		if !conn.ConnectionState().SupportsDatagrams {
+			return testResult{}, fmt.Errorf("%s doesn't support datagrams, which are needed for latency probes", conn.RemoteAddr())
+		}
+		prober = newLatencyProber(conn, *probeInterval, *oneWay)
+		go prober.receive()
+
+		// The server starts sending as soon as the connection is
+		// up, but can't send more than the initial flow control
+		// window until we start reading.
+		log.Infof("Measuring idle RTT with %d probes", idleProbes)
+		prober.run(ctx, phaseIdle, idleProbes)
+	}
+
+	var s quic.ReceiveStream
+	if *stop == "fin" || *clientStream {
+		cancelBulkStream(ctx, conn)
+	}
+	if *stop == "fin" {
+		s, err = openDownload(ctx, conn, uint64(*stopBytes))
+		if err != nil {
+			return testResult{}, fmt.Errorf("opening the download stream to %s: %w", conn.RemoteAddr(), err)
+		}
+	} else if *clientStream {
+		s, err = openDownload(ctx, conn, 0)
+		if err != nil {
+			return testResult{}, fmt.Errorf("opening the download stream to %s: %w", conn.RemoteAddr(), err)
+		}
+	} else {
+		s, err = conn.AcceptUniStream(ctx)
+		if err != nil {
+			return testResult{}, fmt.Errorf("accepting unidirectional stream from %s: %w", conn.RemoteAddr(), err)
+		}
+	}
+	opened := time.Now()
 	defer s.CancelRead(quic.StreamErrorCode(quic.NoError))
 
-	err = s.SetReadDeadline(time.Now().Add(time.Duration(*durationInSecs) * time.Second))
-	if err != nil {
-		glog.Exitf("Fatal error setting a read deadline on unidirectional stream: %v", err)
+	// With -stop fin, the server ends the stream after -stop-bytes
+	// however long that takes.
+	var deadline time.Time
+	if *stop != "fin" {
+		deadline = time.Now().Add(time.Duration(*durationInSecs) * time.Second)
+		err = s.SetReadDeadline(deadline)
+		if err != nil {
+			return testResult{}, fmt.Errorf("setting a read deadline on unidirectional stream: %w", err)
+		}
+	}
+
+	var (
+		out  *os.File
+		bw   *bufio.Writer
+		sum  hash.Hash
+		sink io.Writer
+	)
+	if *recvFile != "" {
+		out, err = os.Create(*recvFile)
+		if err != nil {
+			return testResult{}, fmt.Errorf("creating file for received data: %w", err)
+		}
+		defer out.Close()
+		bw = bufio.NewWriter(out)
+		sum = sha256.New()
+		sink = io.MultiWriter(bw, sum)
+	}
+
+	var verifier *blockVerifier
+	if *verify {
+		verifier = new(blockVerifier)
+		if sink != nil {
+			sink = io.MultiWriter(sink, verifier)
+		} else {
+			sink = verifier
+		}
+	}
+
+	stopProbes := func() {}
+	if prober != nil {
+		loadCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		stopProbes = cancel
+		go prober.run(loadCtx, phaseLoaded, 0)
+	}
+
+	var spark *sparkline
+	if *sparklines {
+		spark = newSparkline()
+		go spark.run()
+		defer spark.stop()
+	}
+
+	var nd *ndjsonStreamer
+	if *ndjson {
+		nd = newNDJSONStreamer(stats)
+		go nd.run()
+	}
+
+	var smp *sampler
+	if *reportFile != "" || *samplesFile != "" || *gnuplotFile != "" {
+		smp = newSampler(stats)
+		go smp.run()
 	}
 
 	doneCh := ctx.Done()
 
 	var discard [readChunkSize]byte
 	n := uint64(0)
+	complete := false
 	start := time.Now()
+	var firstByte time.Time
+	var conv *convergence
+	if converge.set {
+		conv = newConvergence(converge.value/100, start)
+	}
+	var interim interimCounter
+	defer onInterim(func() { interim.report(stats, start) })()
+
+	// Pauses push the deadline back, so the transfer is active for
+	// -seconds in all.
+	pz := newPauser(ctx, conn, s, start, deadline)
+	setActivePauser(pz)
+	defer setActivePauser(nil)
+	if *pauseAt > 0 {
+		pauseCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		go pz.schedule(pauseCtx, *pauseAt, *pauseFor)
+	}
 	for {
 		if doneCh != nil {
 			select {
 			case <-doneCh:
-				return
+				return testResult{bytes: n, duration: time.Since(start)}, nil
 			default:
 			}
 		}
 
 		i, err := s.Read(discard[:])
+		if n == 0 && i > 0 {
+			firstByte = time.Now()
+		}
 		n += uint64(i)
+		interim.set(n)
+		if spark != nil {
+			spark.add(i)
+		}
+		if nd != nil {
+			nd.add(i)
+		}
+		if smp != nil {
+			smp.add(i)
+		}
+		if sink != nil {
+			if _, err := sink.Write(discard[:i]); err != nil {
+				return testResult{}, fmt.Errorf("writing received data to %s: %w", *recvFile, err)
+			}
+		}
+		if err == nil && conv != nil && conv.add(n, time.Now()) {
+			break
+		}
 		if err != nil {
 			if err == io.EOF {
+				complete = true
 				break
 			}
 
@@ -201,26 +1349,265 @@ func clientMain(ctx context.Context) {
 				}
 			}
 
-			glog.Errorf("Error reading from stream: %v", err)
+			log.Errorf("Error reading from stream: %v", err)
 			break
 		}
 	}
 	dur := time.Since(start)
-	durS := float64(dur) / 1e9
-	fmt.Printf("Received: %d bytes in %.3f seconds (%.3f Kbits/s)\n",
-		n,
-		durS,
-		((float64(n)/1e3)*8)/float64(durS))
+	pauses, idle := pz.stop()
+	if spark != nil {
+		spark.stop()
+	}
+	if nd != nil {
+		nd.stop()
+	}
+	if *stop == "reset" {
+		s.CancelRead(quic.StreamErrorCode(quic.NoError))
+	}
+	stopProbes()
+	fmt.Printf("Received: %d bytes in %.3f seconds (%s)\n", n, dur.Seconds(), rate(n, dur))
+	if pauses > 0 && dur > idle {
+		fmt.Printf("Paused: %d times for %.3f seconds in all; %s while not paused\n",
+			pauses, idle.Seconds(), rate(n, dur-idle))
+	}
+	if *stop == "fin" && n < uint64(*stopBytes) && complete {
+		fmt.Printf("The server ended the download after %d of the %d bytes asked for, e.g. at its -max-bytes limit\n", n, *stopBytes)
+	} else if *stop != "fin" && complete {
+		fmt.Println("The server ended the download before the test did, e.g. at the end of its -sendfile or its -max-bytes limit")
+	}
+	if conv != nil {
+		conv.report()
+	}
+	if n > 0 {
+		fmt.Printf("Time to first byte: %.3f ms (handshake %.3f ms, stream open %.3f ms, first byte %.3f ms)\n",
+			float64(firstByte.Sub(dialStart))/1e6, float64(connected.Sub(dialStart))/1e6,
+			float64(opened.Sub(connected))/1e6, float64(firstByte.Sub(opened))/1e6)
+	}
 
+	loss, received, sent := stats.receiveLoss()
+	fmt.Printf("Estimated packet loss: %.3f%% (%d of %d packets received)\n", loss*100, received, sent)
+	stats.printMTU()
+	if r, err := fetchServerResults(ctx, conn); err != nil {
+		log.Infof("Not reporting the server's view of the test: %v", err)
+	} else {
+		r.print()
+		printWasted(r.bytesSent, n)
+	}
+
+	if uploaded != nil {
+		if err := <-uploaded; err != nil {
+			return testResult{}, fmt.Errorf("uploading to %s: %w", conn.RemoteAddr(), err)
+		}
+		upload.print()
+	}
+
+	if prober != nil {
+		prober.report()
+	}
+
+	if *df {
+		largestSent, largestAcked, largeLost := stats.packetSizes()
+		fmt.Printf("Largest packet sent: %d bytes, largest acknowledged: %d bytes\n", largestSent, largestAcked)
+		if largeLost > 0 {
+			fmt.Printf("Warning: %d packets larger than %d bytes were lost; the path may be dropping packets that are too large to forward without fragmentation\n",
+				largeLost, largestAcked)
+		}
+	}
+
+	if verifier != nil {
+		fmt.Printf("Verified %d blocks: %d corrupt, %d out of sequence\n",
+			verifier.blocks, verifier.corrupt, verifier.outOfOrder)
+		if !verifier.ok() && verifier.corrupt == verifier.blocks {
+			fmt.Println("Warning: every block failed verification; is the server running with -verify?")
+		}
+	}
+
+	if out != nil {
+		if err := bw.Flush(); err != nil {
+			return testResult{}, fmt.Errorf("writing received data to %s: %w", *recvFile, err)
+		}
+		if err := out.Close(); err != nil {
+			return testResult{}, fmt.Errorf("closing %s: %w", *recvFile, err)
+		}
+		fmt.Printf("Wrote %d bytes to %s (SHA-256 %x)\n", n, *recvFile, sum.Sum(nil))
+		if !complete {
+			fmt.Printf("Warning: the server had not finished sending when the test ended; %s is incomplete\n", *recvFile)
+		}
+	}
+
+	r := testResult{bytes: n, duration: dur, loss: loss}
+	if nd != nil {
+		srtt, _ := stats.rtt()
+		writeNDJSON(ndjsonRecord{Type: "summary", Time: dur.Seconds(), Bytes: n, Throughput: r.throughput(), SRTT: float64(srtt) / 1e6, Loss: loss, Env: env})
+	}
+	if smp != nil {
+		samples := smp.stop()
+		if *reportFile != "" {
+			if err := writeReport(*reportFile, samples, r); err != nil {
+				return r, fmt.Errorf("writing report: %w", err)
+			}
+			fmt.Printf("Wrote report to %s\n", *reportFile)
+		}
+		if *samplesFile != "" {
+			if err := writeSamples(*samplesFile, samples); err != nil {
+				return r, fmt.Errorf("writing samples: %w", err)
+			}
+			fmt.Printf("Wrote %d samples to %s\n", len(samples), *samplesFile)
+		}
+		if *gnuplotFile != "" {
+			path := gnuplotPath(*gnuplotFile)
+			if err := writeGnuplot(path, samples); err != nil {
+				return r, fmt.Errorf("writing gnuplot data: %w", err)
+			}
+			fmt.Printf("Wrote %d samples to %s\n", len(samples), path)
+		}
+	}
+	return r, nil
 }
 
 func main() {
 	flag.Parse()
 
+	if *showVersion {
+		printVersion()
+		return
+	}
+
+	setupLogging()
+	setupLowMemory()
+	go watchInterim()
+	go watchPause()
+
+	if *ndjson {
+		// Keep standard output for the records alone.
+		os.Stdout = os.Stderr
+	}
+
+	switch *rateUnits {
+	case "k", "si", "iec":
+	default:
+		log.Exitf("Fatal error: unknown -units %q, want k, si or iec", *rateUnits)
+	}
+
+	switch *udpBackend {
+	case "std":
+	case "iouring":
+		if *df || *proxy != "" || *sockets > 1 || *bindDevice != "" {
+			log.Exitf("Fatal error: -udp-backend iouring can't be used with -df, -proxy, -sockets or -interface")
+		}
+	default:
+		log.Exitf("Fatal error: unknown -udp-backend %q, want std or iouring", *udpBackend)
+	}
+
+	if *cidLength != 0 && (*cidLength < 4 || *cidLength > 18) {
+		log.Exitf("Fatal error: -cid-length must be between 4 and 18, or 0 for quic-go's default")
+	}
+	if *masqueProxy != "" && (*proxy != "" || *df || *clientPort != 0 || *udpBackend != "std" || *bindDevice != "") {
+		log.Exitf("Fatal error: -masque-proxy can't be used with -proxy, -df, -client-port, -interface or -udp-backend iouring")
+	}
+	switch *tryAddrs {
+	case "", "sequential", "race":
+	default:
+		log.Exitf("Fatal error: unknown -try-addrs %q, want sequential or race", *tryAddrs)
+	}
+	if *tryAddrs != "" && *clientPort != 0 {
+		log.Exitf("Fatal error: -try-addrs can't be used with -client-port, as quic-go can't take the port up again straight after a handshake has used it")
+	}
+	if *runs < 1 {
+		log.Exitf("Fatal error: -runs must be at least 1")
+	}
+	if *emulateReorderGap < 1 {
+		log.Exitf("Fatal error: -emulate-reorder-gap must be at least 1")
+	}
+
+	if *cpuList != "" {
+		if err := pinCPUs(*cpuList); err != nil {
+			log.Exitf("Fatal error pinning to -cpus %s: %v", *cpuList, err)
+		}
+		log.Infof("Pinned to CPUs %s", *cpuList)
+	}
+	logEmulation()
+
 	if *serve {
 		serverMain(context.Background())
 		return
 	}
+	if *selfTest {
+		if !runSelfTest() {
+			os.Exit(exitThresholdNotMet)
+		}
+		return
+	}
+	if *relay != "" {
+		relayMain(context.Background())
+		return
+	}
 
-	clientMain(context.Background())
+	if *discoverMDNS {
+		if err := discover(); err != nil {
+			log.Exitf("Fatal error discovering servers: %v", err)
+		}
+		return
+	}
+	if *agent != "" {
+		if *agentToken == "" {
+			log.Exitf("Fatal error: -agent needs an -agent-token, or anyone who can reach it could have it run tests")
+		}
+		runAgent()
+		return
+	}
+	if *agentList != "" {
+		if !runCoordinator() {
+			os.Exit(exitThresholdNotMet)
+		}
+		return
+	}
+
+	if strings.Contains(*client, ",") {
+		if *sweep != "" || *runs > 1 || *abA != "" || *abB != "" {
+			log.Exitf("Fatal error: -sweep, -runs, -ab-a and -ab-b can't be used with more than one server")
+		}
+		if !runComparison(context.Background()) {
+			os.Exit(exitThresholdNotMet)
+		}
+		return
+	}
+
+	if *abA != "" || *abB != "" {
+		if *abA == "" || *abB == "" {
+			log.Exitf("Fatal error: -ab-a and -ab-b must be given together")
+		}
+		if *sweep != "" {
+			log.Exitf("Fatal error: -ab-a and -ab-b can't be used with -sweep")
+		}
+		if !runAB(context.Background()) {
+			os.Exit(exitThresholdNotMet)
+		}
+		return
+	}
+
+	if *runs > 1 {
+		if *sweep != "" {
+			log.Exitf("Fatal error: -runs can't be used with -sweep")
+		}
+		if !runRepeated(context.Background()) {
+			os.Exit(exitThresholdNotMet)
+		}
+		return
+	}
+
+	if *sweep != "" {
+		if !runSweep(context.Background()) {
+			os.Exit(exitThresholdNotMet)
+		}
+		return
+	}
+
+	r, err := clientMain(context.Background())
+	if err != nil {
+		log.Exitf("Fatal error: %v", err)
+	}
+	if !checkThresholds(r) {
+		os.Exit(exitThresholdNotMet)
+	}
 }
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+// runRamp reads the bulk transfer stream at each of the -ramp rates in
+// turn, for -ramp-step each, and reports the throughput, loss and RTT at
+// every step. Since the server can't send faster than the client reads,
+// flow control limits the sender to the target rate, or to whatever the
+// path can carry if that is less.
+func runRamp(ctx context.Context, conn quic.Connection, stats *connStats) testResult {
+	s, err := conn.AcceptUniStream(ctx)
+	if err != nil {
+		log.Exitf("Fatal error accepting unidirectional stream from %s: %v", conn.RemoteAddr(), err)
+	}
+	defer s.CancelRead(quic.StreamErrorCode(quic.NoError))
+
+	var (
+		buf   [readChunkSize]byte
+		total uint64
+	)
+	start := time.Now()
+	for i, target := range rampRates {
+		prevPackets, prevPN := stats.received()
+		stepStart := time.Now()
+		stepEnd := stepStart.Add(*rampStep)
+		if err := s.SetReadDeadline(stepEnd); err != nil {
+			log.Exitf("Fatal error setting a read deadline on unidirectional stream: %v", err)
+		}
+
+		var n uint64
+		for ctx.Err() == nil && time.Now().Before(stepEnd) {
+			allowed := int64(target/8*time.Since(stepStart).Seconds()) - int64(n)
+			if allowed <= 0 {
+				time.Sleep(time.Millisecond)
+				continue
+			}
+			if allowed > int64(len(buf)) {
+				allowed = int64(len(buf))
+			}
+
+			m, err := s.Read(buf[:allowed])
+			n += uint64(m)
+			if err != nil {
+				if e, ok := err.(net.Error); ok && e.Timeout() {
+					break
+				}
+				if err != io.EOF {
+					log.Errorf("Error reading from stream: %v", err)
+				}
+				return testResult{bytes: total + n, duration: time.Since(start)}
+			}
+		}
+		total += n
+
+		packets, pn := stats.received()
+		loss := 0.0
+		if pn > prevPN {
+			loss = 1 - float64(packets-prevPackets)/float64(pn-prevPN)
+		}
+		srtt, _ := stats.rtt()
+		fmt.Printf("Step %d: target %s, received %d bytes (%s), estimated loss %.3f%%, smoothed RTT %.3f ms\n",
+			i+1, formatRate(target), n, rate(n, time.Since(stepStart)), loss*100, float64(srtt)/1e6)
+	}
+
+	dur := time.Since(start)
+	fmt.Printf("Received: %d bytes in %.3f seconds (%s)\n", total, dur.Seconds(), rate(total, dur))
+	loss, _, _ := stats.receiveLoss()
+	return testResult{bytes: total, duration: dur, loss: loss}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// Pacing parameters of pacer. Writes are split into chunks so that the
+// data leaves smoothly rather than in bursts, and writers that fall
+// behind, e.g. because flow control blocked them, may catch up on at most
+// paceSlack's worth of sending.
+const (
+	paceChunk = 16 << 10
+	paceSlack = 10 * time.Millisecond
+)
+
+// pacer spaces out writes to rate() bits per second, which is called for
+// every chunk so that it may change as sending goes on. Several writers
+// may share a pacer, and then share its rate.
+type pacer struct {
+	rate func() float64
+
+	mu   sync.Mutex
+	next time.Time // when the next chunk may be written
+}
+
+func newPacer(rate func() float64) *pacer {
+	return &pacer{rate: rate, next: time.Now()}
+}
+
+// reserve books the writing of n bytes and returns how long to wait
+// before writing them.
+func (p *pacer) reserve(n int) time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	if earliest := now.Add(-paceSlack); p.next.Before(earliest) {
+		p.next = earliest
+	}
+	wait := p.next.Sub(now)
+	p.next = p.next.Add(time.Duration(float64(n) * 8 / p.rate() * float64(time.Second)))
+	return wait
+}
+
+// pacedWriter is an io.Writer writing to w at the pace of p.
+type pacedWriter struct {
+	w io.Writer
+	p *pacer
+}
+
+func (pw *pacedWriter) Write(b []byte) (int, error) {
+	written := 0
+	for len(b) > 0 {
+		chunk := b
+		if len(chunk) > paceChunk {
+			chunk = chunk[:paceChunk]
+		}
+		if d := pw.p.reserve(len(chunk)); d > 0 {
+			time.Sleep(d)
+		}
+
+		n, err := pw.w.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		b = b[n:]
+	}
+	return written, nil
+}
+
+// tokenBucket allows events at rate per second on average, in bursts of
+// up to burst.
+type tokenBucket struct {
+	rate, burst float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate, burst float64) *tokenBucket {
+	return &tokenBucket{rate: rate, burst: burst, tokens: burst, last: time.Now()}
+}
+
+// allow reports whether an event may happen now, taking a token if so.
+func (b *tokenBucket) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"sync/atomic"
+
+	"github.com/quic-go/quic-go"
+)
+
+// relayMain runs qperf as a relay: it accepts connections on -addr like a
+// server and forwards the streams and datagrams of each to the qperf
+// server at -relay over a connection of its own, so that a path through
+// the relay can be measured end to end and each of its hops on its own.
+func relayMain(ctx context.Context) {
+	startHealth()
+	c := serverTLSConfig()
+	qconf := &quic.Config{
+		EnableDatagrams:         *datagrams,
+		DisablePathMTUDiscovery: *disablePMTUD,
+		HandshakeIdleTimeout:    *handshakeIdleTimeout,
+		ConnectionIDLength:      *cidLength,
+	}
+	applyLowMemory(qconf)
+	quicParams.apply(qconf)
+
+	listeners, err := listen(c, qconf)
+	if err != nil {
+		log.Exitf("Fatal error listening on %s: %v", *addr, err)
+	}
+	for _, l := range listeners {
+		log.Infof("Relaying connections on address %v to %s", l.Addr(), *relay)
+		defer l.Close()
+	}
+	atomic.StoreInt32(&listenersUp, 1)
+
+	upstreamTLS := &tls.Config{
+		NextProtos:         []string{alpnNextProto},
+		InsecureSkipVerify: *insecure,
+	}
+	if len(pins) > 0 {
+		upstreamTLS.VerifyPeerCertificate = pins.verify
+	}
+	restrictTLS(upstreamTLS)
+
+	for _, l := range listeners[1:] {
+		go acceptRelayed(ctx, l, upstreamTLS, qconf)
+	}
+	acceptRelayed(ctx, listeners[0], upstreamTLS, qconf)
+}
+
+// acceptRelayed accepts connections on l and relays each of them to the
+// upstream server.
+func acceptRelayed(ctx context.Context, l quic.Listener, tlsConfig *tls.Config, qconf *quic.Config) {
+	for {
+		conn, err := l.Accept(ctx)
+		if err != nil {
+			log.Errorf("Error accepting connection: %v", err)
+			continue
+		}
+		log.forConn(conn).Infof("Accepted connection from %s", conn.RemoteAddr())
+		go relayConn(ctx, conn, tlsConfig, qconf)
+	}
+}
+
+// relayConn connects to the upstream server for the client on down and
+// forwards between the two connections until either is closed, closing
+// the other the same way.
+func relayConn(ctx context.Context, down quic.Connection, tlsConfig *tls.Config, qconf *quic.Config) {
+	clog := log.forConn(down)
+
+	up, err := quic.DialAddrContext(ctx, *relay, tlsConfig, qconf)
+	if err != nil {
+		clog.Errorf("Error connecting to upstream server %s for client %s: %v", *relay, down.RemoteAddr(), err)
+		down.CloseWithError(errorCodeUpstream, "upstream server unreachable")
+		return
+	}
+	clog.Infof("Relaying client %s through %s to upstream server %s", down.RemoteAddr(), up.LocalAddr(), up.RemoteAddr())
+
+	var toUp, fromUp uint64 // accessed atomically
+	done := make(chan error, 2)
+	go func() { done <- forwardStreams(ctx, down, up, &toUp, &fromUp) }()
+	go func() { done <- forwardStreams(ctx, up, down, &fromUp, &toUp) }()
+	if down.ConnectionState().SupportsDatagrams && up.ConnectionState().SupportsDatagrams {
+		go forwardDatagrams(down, up, &toUp)
+		go forwardDatagrams(up, down, &fromUp)
+	}
+
+	err = <-done
+	code, msg := quic.ApplicationErrorCode(quic.NoError), ""
+	var appErr *quic.ApplicationError
+	if errors.As(err, &appErr) {
+		code, msg = appErr.ErrorCode, appErr.ErrorMessage
+	}
+	down.CloseWithError(code, msg)
+	up.CloseWithError(code, msg)
+	<-done
+
+	clog.Infof("Relayed %d bytes from client %s and %d bytes to it", atomic.LoadUint64(&toUp), down.RemoteAddr(), atomic.LoadUint64(&fromUp))
+}
+
+// forwardStreams opens a stream on dst for every stream src's peer opens
+// and forwards the data on it, adding the bytes forwarded from src to dst
+// to n and those of bidirectional streams' answers to back, until src is
+// closed.
+func forwardStreams(ctx context.Context, src, dst quic.Connection, n, back *uint64) error {
+	errs := make(chan error, 2)
+	go func() {
+		for {
+			in, err := src.AcceptUniStream(ctx)
+			if err != nil {
+				errs <- err
+				return
+			}
+			out, err := dst.OpenUniStreamSync(ctx)
+			if err != nil {
+				in.CancelRead(quic.StreamErrorCode(quic.NoError))
+				errs <- err
+				return
+			}
+			go forwardStream(in, out, n)
+		}
+	}()
+	go func() {
+		for {
+			in, err := src.AcceptStream(ctx)
+			if err != nil {
+				errs <- err
+				return
+			}
+			out, err := dst.OpenStreamSync(ctx)
+			if err != nil {
+				in.CancelRead(quic.StreamErrorCode(quic.NoError))
+				in.CancelWrite(quic.StreamErrorCode(quic.NoError))
+				errs <- err
+				return
+			}
+			go forwardStream(in, out, n)
+			go forwardStream(out, in, back)
+		}
+	}()
+	return <-errs
+}
+
+// forwardStream copies in to out, adding the bytes copied to n. When in
+// is reset or out is stopped, it passes the error code on to the other.
+func forwardStream(in quic.ReceiveStream, out quic.SendStream, n *uint64) {
+	buf := make([]byte, readChunkSize)
+	for {
+		m, err := in.Read(buf)
+		if m > 0 {
+			if _, werr := out.Write(buf[:m]); werr != nil {
+				var streamErr *quic.StreamError
+				if errors.As(werr, &streamErr) {
+					in.CancelRead(streamErr.ErrorCode)
+				} else {
+					in.CancelRead(quic.StreamErrorCode(quic.NoError))
+				}
+				return
+			}
+			atomic.AddUint64(n, uint64(m))
+		}
+		if err == io.EOF {
+			out.Close()
+			return
+		}
+		if err != nil {
+			var streamErr *quic.StreamError
+			if errors.As(err, &streamErr) {
+				out.CancelWrite(streamErr.ErrorCode)
+			} else {
+				out.CancelWrite(quic.StreamErrorCode(quic.NoError))
+			}
+			return
+		}
+	}
+}
+
+// forwardDatagrams sends every datagram received on src on dst, adding
+// their bytes to n, until either connection is closed. Like a router, it
+// drops the datagrams it can't send, such as those too large for dst.
+func forwardDatagrams(src, dst quic.Connection, n *uint64) {
+	for {
+		msg, err := src.ReceiveMessage()
+		if err != nil {
+			return
+		}
+		if err := dst.SendMessage(msg); err != nil {
+			if dst.Context().Err() != nil {
+				return
+			}
+			continue
+		}
+		atomic.AddUint64(n, uint64(len(msg)))
+	}
+}
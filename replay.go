@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+// scheduleEntry is a single line of a -replay schedule.
+type scheduleEntry struct {
+	at   time.Duration
+	size int
+}
+
+// readSchedule parses the schedule file at path. Each line holds a
+// timestamp in seconds and a size in bytes, separated by whitespace or a
+// comma. Blank lines and lines starting with # are ignored. Timestamps
+// must not decrease, and are taken relative to the first one so that
+// absolute capture timestamps can be used as they are.
+func readSchedule(path string) ([]scheduleEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var (
+		entries []scheduleEntry
+		first   float64
+	)
+	sc := bufio.NewScanner(f)
+	for line := 1; sc.Scan(); line++ {
+		text := strings.TrimSpace(sc.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		fields := strings.FieldsFunc(text, func(r rune) bool {
+			return r == ',' || r == ' ' || r == '\t'
+		})
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: want a timestamp and a size", path, line)
+		}
+		ts, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid timestamp %q", path, line, fields[0])
+		}
+		size, err := strconv.ParseUint(fields[1], 10, 32)
+		if err != nil || size > math.MaxInt32 {
+			return nil, fmt.Errorf("%s:%d: invalid size %q", path, line, fields[1])
+		}
+
+		if len(entries) == 0 {
+			first = ts
+		}
+		at := time.Duration((ts - first) * float64(time.Second))
+		if len(entries) > 0 && at < entries[len(entries)-1].at {
+			return nil, fmt.Errorf("%s:%d: timestamp goes backwards", path, line)
+		}
+		entries = append(entries, scheduleEntry{at: at, size: int(size)})
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("%s: empty schedule", path)
+	}
+	return entries, nil
+}
+
+// runReplay requests the sizes in the -replay schedule at the times it
+// gives, regardless of -seconds.
+func runReplay(ctx context.Context, conn quic.Connection) testResult {
+	schedule, err := readSchedule(*replayFile)
+	if err != nil {
+		log.Exitf("Fatal error reading schedule: %v", err)
+	}
+	log.Infof("Replaying %d requests over %s from %s", len(schedule), schedule[len(schedule)-1].at, *replayFile)
+
+	cancelBulkStream(ctx, conn)
+
+	i := 0
+	next := func() (time.Duration, int, bool) {
+		if i == len(schedule) {
+			return 0, 0, false
+		}
+		e := schedule[i]
+		i++
+		return e.at, e.size, true
+	}
+	return runScheduled(ctx, conn, next)
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"os"
+	"strings"
+	"time"
+)
+
+// Size of the charts in a report, in pixels.
+const (
+	chartWidth  = 800
+	chartHeight = 200
+)
+
+// reportChart is one chart of a report: a line through the values of a
+// series, scaled to fit the chart.
+type reportChart struct {
+	Title  string
+	Points string
+	Max    string
+}
+
+var reportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>qperf report: {{.Server}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+svg { background: #fafafa; border: 1px solid #ccc; }
+polyline { fill: none; stroke: #1f77b4; stroke-width: 1.5; }
+text { font-size: 12px; fill: #555; }
+</style>
+</head>
+<body>
+<h1>qperf report: {{.Server}}</h1>
+<p>{{.Time}}</p>
+<p>{{.Summary}}</p>
+{{range .Charts}}
+<h2>{{.Title}}</h2>
+<svg width="{{$.Width}}" height="{{$.Height}}" viewBox="0 0 {{$.Width}} {{$.Height}}">
+<polyline points="{{.Points}}"/>
+<text x="4" y="14">{{.Max}}</text>
+<text x="4" y="{{$.Height}}" dy="-4">0</text>
+<text x="{{$.Width}}" y="{{$.Height}}" dx="-4" dy="-4" text-anchor="end">{{$.Duration}}</text>
+</svg>
+{{end}}
+</body>
+</html>
+`))
+
+// writeReport writes a standalone HTML file to path charting the
+// throughput, RTT, loss and congestion window in samples.
+func writeReport(path string, samples []sample, r testResult) error {
+	var dur time.Duration
+	if len(samples) > 0 {
+		dur = samples[len(samples)-1].at
+	}
+
+	series := []struct {
+		title  string
+		value  func(sample) float64
+		format func(float64) string
+	}{
+		{"Throughput", func(s sample) float64 { return float64(s.bytes) * 8 / sampleInterval.Seconds() }, formatRate},
+		{"Smoothed RTT", func(s sample) float64 { return float64(s.srtt) }, func(v float64) string { return fmt.Sprintf("%.3f ms", v/1e6) }},
+		{"Latest RTT", func(s sample) float64 { return float64(s.latestRTT) }, func(v float64) string { return fmt.Sprintf("%.3f ms", v/1e6) }},
+		{"Estimated loss", func(s sample) float64 { return s.loss * 100 }, func(v float64) string { return fmt.Sprintf("%.3f%%", v) }},
+		{"Congestion window", func(s sample) float64 { return float64(s.cwnd) }, func(v float64) string { return fmt.Sprintf("%.0f bytes", v) }},
+	}
+
+	var charts []reportChart
+	for _, ser := range series {
+		peak := 0.0
+		for _, s := range samples {
+			if v := ser.value(s); v > peak {
+				peak = v
+			}
+		}
+		points := make([]string, len(samples))
+		for i, s := range samples {
+			x, y := 0.0, float64(chartHeight)
+			if dur > 0 {
+				x = float64(s.at) / float64(dur) * chartWidth
+			}
+			if peak > 0 {
+				y -= ser.value(s) / peak * chartHeight
+			}
+			points[i] = fmt.Sprintf("%.1f,%.1f", x, y)
+		}
+		charts = append(charts, reportChart{
+			Title:  ser.title,
+			Points: strings.Join(points, " "),
+			Max:    ser.format(peak),
+		})
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	err = reportTemplate.Execute(f, map[string]interface{}{
+		"Server":   *client,
+		"Time":     time.Now().Format(time.RFC1123),
+		"Summary":  fmt.Sprintf("Received %d bytes in %.3f seconds (%s), estimated packet loss %.3f%%", r.bytes, r.duration.Seconds(), formatRate(r.throughput()), r.loss*100),
+		"Charts":   charts,
+		"Width":    chartWidth,
+		"Height":   chartHeight,
+		"Duration": fmt.Sprintf("%.1f s", dur.Seconds()),
+	})
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
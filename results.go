@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/logging"
+)
+
+// When the client asks for them with requestResults, the server answers
+// on the control stream with its view of the bulk transfer so far: the
+// bytes it wrote, the 1-RTT packets it sent and declared lost, and the
+// nanoseconds since it started writing, each as a big-endian 64 bit
+// number. The request and answer are retransmitted like any stream data,
+// so the client gets them even if the path dropped the last packets of
+// the transfer.
+const resultsLen = 4 * 8
+
+// serverResults is the server's view of a connection's bulk transfer.
+type serverResults struct {
+	bytesSent, packetsSent, packetsLost uint64
+	duration                            time.Duration
+}
+
+// serverConn is what the server keeps of a connection for reporting its
+// results.
+type serverConn struct {
+	served uint64 // bytes of RPC responses and echoes, accessed atomically
+
+	stats *connStats
+
+	mu      sync.Mutex
+	sent    *sendCounter // nil until the bulk transfer starts
+	start   time.Time
+	remote  string
+	sending bool // while the bulk transfer is in progress
+
+	pause pauseGate
+}
+
+// serverConns holds the server's connections by their tracing ID, from
+// the time quic-go starts tracing them until they are closed.
+var serverConns = struct {
+	sync.Mutex
+	m map[uint64]*serverConn
+}{m: make(map[uint64]*serverConn)}
+
+// resultsTracer is a logging.Tracer keeping the stats of each of the
+// server's connections in serverConns.
+type resultsTracer struct {
+	logging.NullTracer
+}
+
+func (resultsTracer) TracerForConnection(ctx context.Context, _ logging.Perspective, _ logging.ConnectionID) logging.ConnectionTracer {
+	id, ok := ctx.Value(quic.ConnectionTracingKey).(uint64)
+	if !ok {
+		return nil
+	}
+	sc := &serverConn{stats: newConnStats()}
+
+	serverConns.Lock()
+	defer serverConns.Unlock()
+
+	serverConns.m[id] = sc
+	return resultsConnTracer{connStats: sc.stats, id: id}
+}
+
+// resultsConnTracer removes its connection from serverConns when it is
+// closed.
+type resultsConnTracer struct {
+	*connStats
+	id uint64
+}
+
+func (t resultsConnTracer) Close() {
+	serverConns.Lock()
+	defer serverConns.Unlock()
+
+	delete(serverConns.m, t.id)
+}
+
+// lookupServerConn returns what the server keeps of conn, or nil.
+func lookupServerConn(conn quic.Connection) *serverConn {
+	id, ok := conn.Context().Value(quic.ConnectionTracingKey).(uint64)
+	if !ok {
+		return nil
+	}
+
+	serverConns.Lock()
+	defer serverConns.Unlock()
+
+	return serverConns.m[id]
+}
+
+// trackBulk records that the bulk transfer on conn started, writing
+// through w. It returns a function to call once the transfer is over.
+func trackBulk(conn quic.Connection, w *sendCounter) (done func()) {
+	sc := lookupServerConn(conn)
+	if sc == nil {
+		return func() {}
+	}
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	sc.sent = w
+	sc.start = time.Now()
+	sc.remote = conn.RemoteAddr().String()
+	sc.sending = true
+	return func() {
+		sc.mu.Lock()
+		defer sc.mu.Unlock()
+
+		sc.sending = false
+	}
+}
+
+// spendMaxBytes counts n more bytes of RPC responses or echoes about to
+// be sent on conn, and reports whether they keep the connection within
+// -max-bytes.
+func spendMaxBytes(conn quic.Connection, n uint64) bool {
+	if *maxBytes <= 0 {
+		return true
+	}
+	sc := lookupServerConn(conn)
+	if sc == nil {
+		return true
+	}
+	return atomic.AddUint64(&sc.served, n) <= uint64(*maxBytes)
+}
+
+// serveResults answers a request for the results on the control stream s.
+func serveResults(conn quic.Connection, s quic.Stream, clog *logger) {
+	s.CancelRead(quic.StreamErrorCode(quic.NoError))
+
+	var r serverResults
+	if sc := lookupServerConn(conn); sc != nil {
+		sc.mu.Lock()
+		if sc.sent != nil {
+			r.bytesSent = sc.sent.bytes()
+			r.duration = time.Since(sc.start)
+		}
+		sc.mu.Unlock()
+
+		sc.stats.mu.Lock()
+		r.packetsSent = sc.stats.spaces[spaceAppData].sent
+		r.packetsLost = sc.stats.spaces[spaceAppData].lost
+		sc.stats.mu.Unlock()
+	}
+
+	var b [resultsLen]byte
+	binary.BigEndian.PutUint64(b[0:], r.bytesSent)
+	binary.BigEndian.PutUint64(b[8:], r.packetsSent)
+	binary.BigEndian.PutUint64(b[16:], r.packetsLost)
+	binary.BigEndian.PutUint64(b[24:], uint64(r.duration))
+	if _, err := s.Write(b[:]); err != nil && !closedByPeer(err) {
+		clog.Errorf("Error writing results to client: %s: %v", conn.RemoteAddr(), err)
+	}
+}
+
+// fetchServerResults asks the server for its results on a new control
+// stream.
+func fetchServerResults(ctx context.Context, conn quic.Connection) (serverResults, error) {
+	var r serverResults
+	ctx, cancel := context.WithTimeout(ctx, controlHelloTimeout)
+	defer cancel()
+
+	s, version, err := openControl(ctx, conn)
+	if err != nil {
+		return r, err
+	}
+	if version < 2 {
+		s.CancelRead(quic.StreamErrorCode(quic.NoError))
+		s.CancelWrite(quic.StreamErrorCode(quic.NoError))
+		return r, fmt.Errorf("the server speaks version %d of the control protocol, which has no results", version)
+	}
+	if _, err := s.Write([]byte{requestResults}); err != nil {
+		return r, err
+	}
+	s.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		s.SetReadDeadline(deadline)
+	}
+	var b [resultsLen]byte
+	if _, err := io.ReadFull(s, b[:]); err != nil {
+		return r, err
+	}
+	r.bytesSent = binary.BigEndian.Uint64(b[0:])
+	r.packetsSent = binary.BigEndian.Uint64(b[8:])
+	r.packetsLost = binary.BigEndian.Uint64(b[16:])
+	r.duration = time.Duration(binary.BigEndian.Uint64(b[24:]))
+	return r, nil
+}
+
+// print prints the server's results.
+func (r serverResults) print() {
+	loss := 0.0
+	if r.packetsSent > 0 {
+		loss = float64(r.packetsLost) / float64(r.packetsSent)
+	}
+	fmt.Printf("Server sent: %d bytes in %.3f seconds (%s), %d packets of which it declared %d lost (%.3f%%)\n",
+		r.bytesSent, r.duration.Seconds(), rate(r.bytesSent, r.duration), r.packetsSent, r.packetsLost, loss*100)
+}
+
+// printWasted prints how many of the bytes the server wrote the client
+// never read, as they were in flight or buffered when it stopped reading.
+// With -stop deadline that includes what the server went on writing
+// until the client asked for its results.
+func printWasted(written, read uint64) {
+	var wasted uint64
+	if written > read {
+		wasted = written - read
+	}
+	share := 0.0
+	if written > 0 {
+		share = float64(wasted) / float64(written)
+	}
+	fmt.Printf("Wasted: %d bytes written by the server but not read (%.3f%%)\n", wasted, share*100)
+}
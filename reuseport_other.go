@@ -0,0 +1,15 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"net"
+)
+
+// listenReusePort isn't supported on this platform, which either lacks
+// SO_REUSEPORT or doesn't spread flows between the sockets sharing an
+// address.
+func listenReusePort(address string) (*net.UDPConn, error) {
+	return nil, errors.New("listening on several sockets isn't supported on this platform")
+}
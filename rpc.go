@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+// An RPC request is sent on its own bidirectional stream. It starts with
+// the big-endian 32 bit size of the response the client wants, followed
+// by padding up to the request size. The client then closes its side of
+// the stream and the server answers with a response of the requested
+// size, after which it closes its side too.
+const rpcHeaderLen = 4
+
+// serveRPCs answers the requests the client sends on bidirectional
+// streams until the connection is closed.
+func serveRPCs(ctx context.Context, conn quic.Connection) {
+	for {
+		s, err := conn.AcceptStream(ctx)
+		if err != nil {
+			return
+		}
+		go serveRPC(conn, s)
+	}
+}
+
+func serveRPC(conn quic.Connection, s quic.Stream) {
+	defer s.Close()
+	clog := log.forConn(conn).with("stream", s.StreamID())
+
+	var hdr [rpcHeaderLen]byte
+	if _, err := io.ReadFull(s, hdr[:]); err != nil {
+		clog.Errorf("Error reading request header from client: %s: %v", conn.RemoteAddr(), err)
+		s.CancelRead(quic.StreamErrorCode(quic.NoError))
+		return
+	}
+	if string(hdr[:]) == controlMagic {
+		serveControl(conn, s, clog)
+		return
+	}
+	if _, err := io.Copy(io.Discard, s); err != nil {
+		clog.Errorf("Error reading request from client: %s: %v", conn.RemoteAddr(), err)
+		return
+	}
+
+	w, done := sendTo(conn, s)
+	defer done()
+	for left := uint64(binary.BigEndian.Uint32(hdr[:])); left > 0; {
+		n := left
+		if n > uint64(len(data)) {
+			n = uint64(len(data))
+		}
+		if !spendMaxBytes(conn, n) {
+			clog.Infof("Resetting response to client at -max-bytes: %s", conn.RemoteAddr())
+			s.CancelWrite(errorCodeMaxBytes)
+			return
+		}
+		if _, err := w.Write(data[:n]); err != nil {
+			if !closedByPeer(err) {
+				clog.Errorf("Error writing response to client: %s: %v", conn.RemoteAddr(), err)
+			}
+			return
+		}
+		left -= n
+	}
+}
+
+// runRPCs keeps -rpc-concurrency requests outstanding on conn for the
+// duration of the test, then prints the request rate and latencies.
+func runRPCs(ctx context.Context, conn quic.Connection) testResult {
+	cancelBulkStream(ctx, conn)
+
+	req := newRPCRequest(*rpcRequestSize, *rpcResponseSize)
+
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(*durationInSecs)*time.Second)
+	defer cancel()
+
+	start := time.Now()
+	latencies, received := makeRPCs(ctx, conn, req)
+	dur := time.Since(start)
+
+	fmt.Printf("Completed: %d requests of %d bytes with %d byte responses in %.3f seconds (%.1f requests/s, %d in flight)\n",
+		latencies.count(), *rpcRequestSize, *rpcResponseSize, dur.Seconds(),
+		float64(latencies.count())/dur.Seconds(), *rpcConcurrency)
+	reportLatencies(latencies)
+
+	return testResult{bytes: received, duration: dur}
+}
+
+// makeRPCs keeps -rpc-concurrency requests req outstanding on conn until
+// ctx is done, returning their latencies and the bytes received.
+func makeRPCs(ctx context.Context, conn quic.Connection, req []byte) (*histogram, uint64) {
+	var (
+		mu        sync.Mutex
+		latencies = newHistogram()
+		received  uint64
+		wg        sync.WaitGroup
+	)
+	for i := 0; i < *rpcConcurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				t := time.Now()
+				n, err := doRPC(ctx, conn, req)
+				if err != nil {
+					if ctx.Err() == nil {
+						log.Errorf("Error making request to %s: %v", conn.RemoteAddr(), err)
+					}
+					return
+				}
+
+				mu.Lock()
+				latencies.record(time.Since(t))
+				received += n
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	return latencies, received
+}
+
+// cancelBulkStream accepts the server's bulk transfer stream and resets it,
+// for tests that have no use for it.
+func cancelBulkStream(ctx context.Context, conn quic.Connection) {
+	bulk, err := conn.AcceptUniStream(ctx)
+	if err != nil {
+		log.Exitf("Fatal error accepting unidirectional stream from %s: %v", conn.RemoteAddr(), err)
+	}
+	bulk.CancelRead(quic.StreamErrorCode(quic.NoError))
+}
+
+// newRPCRequest returns a request of reqSize bytes asking for a response of
+// respSize bytes, or one byte less if respSize is the reserved
+// controlMagicSize, which a sampled size may be.
+func newRPCRequest(reqSize, respSize int) []byte {
+	if respSize == controlMagicSize {
+		respSize--
+	}
+	req := make([]byte, reqSize)
+	binary.BigEndian.PutUint32(req, uint32(respSize))
+	return req
+}
+
+// openRPC sends req on a new stream, returning the stream to read the
+// response from.
+func openRPC(ctx context.Context, conn quic.Connection, req []byte) (quic.Stream, error) {
+	s, err := conn.OpenStreamSync(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		s.SetDeadline(deadline)
+	}
+
+	if _, err := s.Write(req); err != nil {
+		s.CancelRead(quic.StreamErrorCode(quic.NoError))
+		return nil, err
+	}
+	s.Close()
+	return s, nil
+}
+
+// doRPC sends req on a new stream and reads the response, returning its
+// size.
+func doRPC(ctx context.Context, conn quic.Connection, req []byte) (uint64, error) {
+	s, err := openRPC(ctx, conn, req)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := io.Copy(io.Discard, s)
+	if err != nil {
+		s.CancelRead(quic.StreamErrorCode(quic.NoError))
+		if isMaxBytes(err) {
+			err = errMaxBytes
+		}
+		return uint64(n), err
+	}
+	if want := binary.BigEndian.Uint32(req); n != int64(want) {
+		return uint64(n), fmt.Errorf("got a %d byte response, want %d bytes", n, want)
+	}
+	return uint64(n), nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+)
+
+// tCritical95 holds the two-sided 95% critical values of Student's t
+// distribution for 1 to 30 degrees of freedom. Beyond that the normal
+// distribution's 1.96 is close enough.
+var tCritical95 = [...]float64{
+	12.706, 4.303, 3.182, 2.776, 2.571, 2.447, 2.365, 2.306, 2.262, 2.228,
+	2.201, 2.179, 2.160, 2.145, 2.131, 2.120, 2.110, 2.101, 2.093, 2.086,
+	2.080, 2.074, 2.069, 2.064, 2.060, 2.056, 2.052, 2.048, 2.045, 2.042,
+}
+
+// sampleStats summarizes repeated measurements of one quantity.
+type sampleStats struct {
+	mean, stddev float64
+	ci           float64 // half the width of the 95% confidence interval
+}
+
+// summarize returns the mean, sample standard deviation and 95%
+// confidence interval of the mean of vs, which has at least two values.
+func summarize(vs []float64) sampleStats {
+	n := float64(len(vs))
+	var sum float64
+	for _, v := range vs {
+		sum += v
+	}
+	mean := sum / n
+	var sq float64
+	for _, v := range vs {
+		sq += (v - mean) * (v - mean)
+	}
+	sd := math.Sqrt(sq / (n - 1))
+
+	t := 1.96
+	if df := len(vs) - 1; df <= len(tCritical95) {
+		t = tCritical95[df-1]
+	}
+	return sampleStats{mean: mean, stddev: sd, ci: t * sd / math.Sqrt(n)}
+}
+
+// cv returns the coefficient of variation, the standard deviation
+// relative to the mean.
+func (s sampleStats) cv() float64 {
+	if s.mean == 0 {
+		return 0
+	}
+	return s.stddev / s.mean
+}
+
+// runRepeated runs the client -runs times, then prints the mean, the 95%
+// confidence interval of the mean and the coefficient of variation of the
+// throughput and smoothed RTT across the runs, so that a change can be
+// told apart from run to run noise. It reports whether every run met the
+// -min-throughput and -max-loss thresholds.
+func runRepeated(ctx context.Context) bool {
+	var (
+		throughputs, rtts []float64
+		ok                = true
+	)
+	for i := 0; i < *runs; i++ {
+		fmt.Printf("=== Run %d of %d\n", i+1, *runs)
+		r, err := clientMain(ctx)
+		if err != nil {
+			log.Exitf("Fatal error: %v", err)
+		}
+		if !checkThresholds(r) {
+			ok = false
+		}
+		throughputs = append(throughputs, r.throughput())
+		rtts = append(rtts, float64(r.rtt)/1e6)
+	}
+
+	fmt.Println()
+	t := summarize(throughputs)
+	fmt.Printf("Throughput over %d runs: mean %s, 95%% confidence interval %s to %s, coefficient of variation %.1f%%\n",
+		*runs, formatRate(t.mean), formatRate(t.mean-t.ci), formatRate(t.mean+t.ci), t.cv()*100)
+	r := summarize(rtts)
+	fmt.Printf("Smoothed RTT over %d runs: mean %.3f ms, 95%% confidence interval %.3f to %.3f ms, coefficient of variation %.1f%%\n",
+		*runs, r.mean, r.mean-r.ci, r.mean+r.ci, r.cv()*100)
+	return ok
+}
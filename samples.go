@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/quic-go/quic-go/logging"
+)
+
+// sampleInterval is how often the bulk transfer is sampled.
+const sampleInterval = 100 * time.Millisecond
+
+// sample is a snapshot of the bulk transfer.
+type sample struct {
+	at        time.Duration // since the start of the transfer
+	bytes     uint64        // received since the previous sample
+	srtt      time.Duration
+	latestRTT time.Duration
+	loss      float64 // estimated since the previous sample
+	cwnd      logging.ByteCount
+}
+
+// sampler samples the bulk transfer every sampleInterval.
+type sampler struct {
+	stats    *connStats
+	received uint64 // accessed atomically
+
+	samples []sample
+	stopCh  chan struct{}
+	doneCh  chan struct{}
+}
+
+func newSampler(stats *connStats) *sampler {
+	return &sampler{
+		stats:  stats,
+		stopCh: make(chan struct{}),
+		doneCh: make(chan struct{}),
+	}
+}
+
+// add counts n more bytes received.
+func (s *sampler) add(n int) {
+	atomic.AddUint64(&s.received, uint64(n))
+}
+
+// run takes samples until stop is called.
+func (s *sampler) run() {
+	defer close(s.doneCh)
+
+	t := time.NewTicker(sampleInterval)
+	defer t.Stop()
+
+	start := time.Now()
+	var prevBytes uint64
+	prevPackets, prevPN := s.stats.received()
+	for {
+		select {
+		case <-s.stopCh:
+			return
+		case <-t.C:
+		}
+
+		smp := sample{at: time.Since(start)}
+		n := atomic.LoadUint64(&s.received)
+		smp.bytes, prevBytes = n-prevBytes, n
+		packets, pn := s.stats.received()
+		if pn > prevPN {
+			smp.loss = 1 - float64(packets-prevPackets)/float64(pn-prevPN)
+		}
+		prevPackets, prevPN = packets, pn
+		smp.srtt, smp.latestRTT, smp.cwnd = s.stats.metrics()
+		s.samples = append(s.samples, smp)
+	}
+}
+
+// stop stops sampling and returns the samples taken.
+func (s *sampler) stop() []sample {
+	close(s.stopCh)
+	<-s.doneCh
+	return s.samples
+}
+
+// writeSamples writes samples to path as CSV with a header row, one row
+// per sample:
+//
+//	time_s,bytes,srtt_ms,latest_rtt_ms,loss,cwnd_bytes
+//
+// time_s is the end of the sample in seconds since the transfer started
+// and bytes the number received during it. The RTTs and the congestion
+// window are their values at the end of the sample, and loss is the
+// fraction of packets estimated lost during it.
+func writeSamples(path string, samples []sample) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	fmt.Fprintln(w, "time_s,bytes,srtt_ms,latest_rtt_ms,loss,cwnd_bytes")
+	for _, s := range samples {
+		fmt.Fprintf(w, "%.3f,%d,%.3f,%.3f,%.6f,%d\n",
+			s.at.Seconds(), s.bytes, float64(s.srtt)/1e6, float64(s.latestRTT)/1e6, s.loss, s.cwnd)
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// gnuplotRuns counts the runs that have written a -gnuplot file, so that
+// each run of a -runs, -sweep or comparison gets a file of its own.
+var gnuplotRuns int
+
+// gnuplotPath returns the file the current run writes for -gnuplot: path
+// itself for the first run, and path with -2, -3 and so on before its
+// extension for the runs after that.
+func gnuplotPath(path string) string {
+	gnuplotRuns++
+	if gnuplotRuns == 1 {
+		return path
+	}
+	ext := filepath.Ext(path)
+	return fmt.Sprintf("%s-%d%s", strings.TrimSuffix(path, ext), gnuplotRuns, ext)
+}
+
+// writeGnuplot writes samples to path as whitespace-separated columns
+// that gnuplot reads as they are, after a comment naming them:
+//
+//	# time_s throughput_bps srtt_ms loss
+//
+// The columns are those of writeSamples, with the bytes of each sample
+// turned into its throughput.
+func writeGnuplot(path string, samples []sample) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	fmt.Fprintln(w, "# time_s throughput_bps srtt_ms loss")
+	for _, s := range samples {
+		fmt.Fprintf(w, "%.3f %.0f %.3f %.6f\n",
+			s.at.Seconds(), float64(s.bytes)*8/sampleInterval.Seconds(), float64(s.srtt)/1e6, s.loss)
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"flag"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// serverListening, if set, is called with the address of the server's
+// first listener once it is listening.
+var serverListening func(net.Addr)
+
+// runSelfTest runs a server on an ephemeral loopback port in this process
+// and the client against it, to check the host's UDP stack and the build
+// without a second machine. The server gets a throwaway self-signed
+// certificate, which the client pins. Both run with the other flags
+// given. It reports whether the client's results met the thresholds.
+func runSelfTest() bool {
+	dir, err := os.MkdirTemp("", "qperf-selftest")
+	if err != nil {
+		log.Exitf("Fatal error creating a directory for the self-test certificate: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	leaf, err := writeSelfSignedCert(certFile, keyFile)
+	if err != nil {
+		log.Exitf("Fatal error generating the self-test certificate: %v", err)
+	}
+
+	flag.Set("cert", certFile)
+	flag.Set("key", keyFile)
+	flag.Set("addr", "127.0.0.1:0")
+	flag.Set("insecure", "true")
+	flag.Set("pin", spkiPin(leaf))
+
+	addrs := make(chan net.Addr, 1)
+	serverListening = func(a net.Addr) { addrs <- a }
+	go serverMain(context.Background())
+	a := <-addrs
+
+	flag.Set("c", a.String())
+	log.Infof("Running the self-test client against %s", a)
+	r, err := clientMain(context.Background())
+	if err != nil {
+		log.Exitf("Fatal error: %v", err)
+	}
+	return checkThresholds(r)
+}
+
+// writeSelfSignedCert writes a self-signed certificate for localhost,
+// valid for a day, and its private key to certFile and keyFile in PEM.
+func writeSelfSignedCert(certFile, keyFile string) (*x509.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o644); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		return nil, err
+	}
+	return leaf, nil
+}
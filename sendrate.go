@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+// sendCounter is an io.Writer that counts the bytes written through it to
+// w. The count can be read while writes are in progress.
+type sendCounter struct {
+	w io.Writer
+	n uint64 // accessed atomically
+}
+
+func (c *sendCounter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	atomic.AddUint64(&c.n, uint64(n))
+	return n, err
+}
+
+// bytes returns the number of bytes written so far.
+func (c *sendCounter) bytes() uint64 {
+	return atomic.LoadUint64(&c.n)
+}
+
+// logSendRate logs the bytes written through c in each interval, and in
+// total, until ctx is done. This is what the server believes it sent,
+// for comparing with what the client says it received.
+func logSendRate(ctx context.Context, clog *logger, c *sendCounter, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	var prev uint64
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+
+		total := c.bytes()
+		clog.with("interval_bytes", total-prev).with("total_bytes", total).Infof(
+			"Sent %d bytes in the last %v (%s), %d bytes in total",
+			total-prev, interval, rate(total-prev, interval), total)
+		prev = total
+	}
+}
+
+// clientSender is what the server sends to a client on all the streams of
+// its connection: the pacer keeping it to -max-conn-rate and the client's
+// fair share of -total-rate, and the number of bytes sent.
+type clientSender struct {
+	remote string
+	pace   *pacer // nil without -max-conn-rate or -total-rate
+	n      uint64 // accessed atomically
+
+	writing int // streams being written to, guarded by clientSenders
+}
+
+// bytes returns the number of bytes sent to the client so far.
+func (c *clientSender) bytes() uint64 {
+	return atomic.LoadUint64(&c.n)
+}
+
+// clientSenders holds the sender of each connection the server has sent
+// on, until it is closed. The clients being written to are the active
+// senders, which the server reports on the fairness between and splits
+// -total-rate between.
+var clientSenders = struct {
+	sync.Mutex
+	m      map[quic.Connection]*clientSender
+	active map[*clientSender]bool
+}{
+	m:      make(map[quic.Connection]*clientSender),
+	active: make(map[*clientSender]bool),
+}
+
+// sendTo returns a writer writing to w, one of conn's streams, that paces
+// and counts the bytes along with everything else the server writes to
+// the client on conn. The client is an active sender until done is
+// called.
+func sendTo(conn quic.Connection, w io.Writer) (_ io.Writer, done func()) {
+	clientSenders.Lock()
+	defer clientSenders.Unlock()
+
+	c, ok := clientSenders.m[conn]
+	if !ok {
+		c = &clientSender{remote: conn.RemoteAddr().String()}
+		if maxConnRate > 0 || totalRate > 0 {
+			c.pace = newPacer(sendRateLimit)
+		}
+		clientSenders.m[conn] = c
+		go func() {
+			<-conn.Context().Done()
+
+			clientSenders.Lock()
+			defer clientSenders.Unlock()
+
+			delete(clientSenders.m, conn)
+		}()
+	}
+	c.writing++
+	clientSenders.active[c] = true
+
+	if c.pace != nil {
+		w = &pacedWriter{w: w, p: c.pace}
+	}
+	return &clientWriter{w: w, c: c}, func() {
+		clientSenders.Lock()
+		defer clientSenders.Unlock()
+
+		if c.writing--; c.writing == 0 {
+			delete(clientSenders.active, c)
+		}
+	}
+}
+
+// clientWriter is an io.Writer counting the bytes written through it to w
+// as sent to the client of c.
+type clientWriter struct {
+	w io.Writer
+	c *clientSender
+}
+
+func (cw *clientWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	atomic.AddUint64(&cw.c.n, uint64(n))
+	return n, err
+}
+
+// fairShare returns each client's equal share of -total-rate.
+func fairShare() float64 {
+	clientSenders.Lock()
+	n := len(clientSenders.active)
+	clientSenders.Unlock()
+
+	if n == 0 {
+		n = 1
+	}
+	return float64(totalRate) / float64(n)
+}
+
+// sendRateLimit returns the rate the server may send to a client at, the
+// lower of -max-conn-rate and its fair share of -total-rate, or 0 if
+// neither is set.
+func sendRateLimit() float64 {
+	limit := float64(maxConnRate)
+	if totalRate > 0 {
+		if share := fairShare(); limit == 0 || share < limit {
+			limit = share
+		}
+	}
+	return limit
+}
+
+// logFairness logs Jain's fairness index of the bytes sent to each client
+// in every interval in which more than one client was being sent to.
+func logFairness(interval time.Duration) {
+	prev := make(map[*clientSender]uint64)
+	for range time.Tick(interval) {
+		clientSenders.Lock()
+		flows := make([]uint64, 0, len(clientSenders.active))
+		cur := make(map[*clientSender]uint64, len(clientSenders.m))
+		share := 0.0
+		if totalRate > 0 && len(clientSenders.active) > 0 {
+			share = float64(totalRate) / float64(len(clientSenders.active))
+		}
+		for _, c := range clientSenders.m {
+			cur[c] = c.bytes()
+			sent := cur[c] - prev[c]
+			if sent == 0 && !clientSenders.active[c] {
+				continue
+			}
+			flows = append(flows, sent)
+			if share > 0 {
+				achieved := float64(sent) * 8 / interval.Seconds()
+				log.with("remote", c.remote).with("allocated_bps", share).with("achieved_bps", achieved).Infof(
+					"Client %s: allocated %s, achieved %s (%.0f%% of its share)",
+					c.remote, formatRate(share), formatRate(achieved), achieved/share*100)
+			}
+		}
+		clientSenders.Unlock()
+		prev = cur
+
+		if len(flows) > 1 {
+			j := jainIndex(flows)
+			log.with("clients", len(flows)).with("jain_index", j).Infof(
+				"Sending to %d clients, Jain's fairness index %.3f", len(flows), j)
+		}
+	}
+}
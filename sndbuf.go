@@ -0,0 +1,19 @@
+package main
+
+import "net"
+
+// sendBufferSize is the UDP send buffer qperf asks for where the
+// platform's default is too small to send at high rates, such as the
+// 9 KiB of macOS. quic-go only enlarges the receive buffer.
+const sendBufferSize = 2 << 20
+
+// tuneSendBuffer enlarges c's send buffer to sendBufferSize on platforms
+// with small send buffers.
+func tuneSendBuffer(c *net.UDPConn) {
+	if !smallSendBuffers {
+		return
+	}
+	if err := c.SetWriteBuffer(sendBufferSize); err != nil {
+		log.Warningf("Error enlarging the UDP send buffer to %d KiB: %v", sendBufferSize>>10, err)
+	}
+}
@@ -0,0 +1,7 @@
+//go:build !darwin && !windows
+
+package main
+
+// smallSendBuffers reports whether UDP sockets start with send buffers
+// too small for qperf.
+const smallSendBuffers = false
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+)
+
+const (
+	socks5Version = 0x05
+
+	socks5MethodNoAuth       = 0x00
+	socks5MethodUserPass     = 0x02
+	socks5MethodNoAcceptable = 0xff
+
+	socks5CmdUDPAssociate = 0x03
+
+	socks5AtypIPv4   = 0x01
+	socks5AtypDomain = 0x03
+	socks5AtypIPv6   = 0x04
+
+	socks5UserPassVersion = 0x01
+)
+
+// socks5PacketConn is a net.PacketConn that relays datagrams through a
+// SOCKS5 proxy using the UDP ASSOCIATE command (RFC 1928). The association
+// lasts for as long as the TCP control connection to the proxy is open.
+//
+// The local socket is deliberately embedded as a net.PacketConn rather than
+// a *net.UDPConn so that quic-go can't bypass WriteTo and ReadFrom via the
+// UDPConn's OOB methods.
+type socks5PacketConn struct {
+	net.PacketConn
+	ctrl  net.Conn
+	relay *net.UDPAddr
+
+	// rbuf is only used by ReadFrom, which quic-go calls from a
+	// single goroutine.
+	rbuf [64 << 10]byte
+}
+
+// dialSOCKS5UDP sets up a UDP association with the SOCKS5 proxy named by
+// proxy, which is either host:port or a socks5://[user:password@]host:port
+// URL. Datagrams are sent to the proxy from udpConn, which the returned
+// socks5PacketConn takes ownership of.
+func dialSOCKS5UDP(ctx context.Context, proxy string, udpConn *net.UDPConn) (*socks5PacketConn, error) {
+	proxyAddr := proxy
+	var user *url.Userinfo
+	if u, err := url.Parse(proxy); err == nil && u.Scheme != "" {
+		if u.Scheme != "socks5" {
+			return nil, fmt.Errorf("unsupported proxy scheme: %q", u.Scheme)
+		}
+		proxyAddr = u.Host
+		user = u.User
+	}
+
+	var d net.Dialer
+	ctrl, err := d.DialContext(ctx, "tcp", proxyAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	relay, err := socks5Associate(ctrl, user)
+	if err != nil {
+		ctrl.Close()
+		return nil, err
+	}
+	if relay.IP.IsUnspecified() {
+		// The proxy wants us to send datagrams to the address we
+		// reached it on.
+		relay.IP = ctrl.RemoteAddr().(*net.TCPAddr).IP
+	}
+
+	return &socks5PacketConn{
+		PacketConn: udpConn,
+		ctrl:       ctrl,
+		relay:      relay,
+	}, nil
+}
+
+// socks5Associate authenticates on the control connection rw and issues a
+// UDP ASSOCIATE request, returning the relay address chosen by the proxy.
+func socks5Associate(rw io.ReadWriter, user *url.Userinfo) (*net.UDPAddr, error) {
+	methods := []byte{socks5MethodNoAuth}
+	if user != nil {
+		methods = append(methods, socks5MethodUserPass)
+	}
+	greeting := append([]byte{socks5Version, byte(len(methods))}, methods...)
+	if _, err := rw.Write(greeting); err != nil {
+		return nil, err
+	}
+
+	var reply [2]byte
+	if _, err := io.ReadFull(rw, reply[:]); err != nil {
+		return nil, err
+	}
+	if reply[0] != socks5Version {
+		return nil, fmt.Errorf("unexpected SOCKS version in proxy reply: %d", reply[0])
+	}
+
+	switch reply[1] {
+	case socks5MethodNoAuth:
+	case socks5MethodUserPass:
+		if user == nil {
+			return nil, errors.New("proxy requires username/password authentication")
+		}
+		if err := socks5Authenticate(rw, user); err != nil {
+			return nil, err
+		}
+	case socks5MethodNoAcceptable:
+		return nil, errors.New("proxy accepted none of the offered authentication methods")
+	default:
+		return nil, fmt.Errorf("proxy selected unsupported authentication method: %d", reply[1])
+	}
+
+	// We don't know which address the proxy will see our datagrams
+	// coming from, so ask it to accept them from anywhere.
+	req := []byte{socks5Version, socks5CmdUDPAssociate, 0x00, socks5AtypIPv4, 0, 0, 0, 0, 0, 0}
+	if _, err := rw.Write(req); err != nil {
+		return nil, err
+	}
+
+	var hdr [3]byte
+	if _, err := io.ReadFull(rw, hdr[:]); err != nil {
+		return nil, err
+	}
+	if hdr[1] != 0x00 {
+		return nil, fmt.Errorf("proxy refused UDP ASSOCIATE: reply code %d", hdr[1])
+	}
+
+	host, port, err := socks5ReadAddr(rw)
+	if err != nil {
+		return nil, err
+	}
+	return net.ResolveUDPAddr("udp", net.JoinHostPort(host, strconv.Itoa(port)))
+}
+
+// socks5Authenticate performs username/password authentication (RFC 1929).
+func socks5Authenticate(rw io.ReadWriter, user *url.Userinfo) error {
+	name := user.Username()
+	pass, _ := user.Password()
+	if len(name) > 255 || len(pass) > 255 {
+		return errors.New("proxy username or password too long")
+	}
+
+	req := []byte{socks5UserPassVersion, byte(len(name))}
+	req = append(req, name...)
+	req = append(req, byte(len(pass)))
+	req = append(req, pass...)
+	if _, err := rw.Write(req); err != nil {
+		return err
+	}
+
+	var reply [2]byte
+	if _, err := io.ReadFull(rw, reply[:]); err != nil {
+		return err
+	}
+	if reply[1] != 0x00 {
+		return errors.New("proxy rejected username/password")
+	}
+	return nil
+}
+
+// socks5ReadAddr reads an ATYP, ADDR, PORT tuple from r.
+func socks5ReadAddr(r io.Reader) (string, int, error) {
+	var atyp [1]byte
+	if _, err := io.ReadFull(r, atyp[:]); err != nil {
+		return "", 0, err
+	}
+
+	var host string
+	switch atyp[0] {
+	case socks5AtypIPv4, socks5AtypIPv6:
+		ip := make(net.IP, net.IPv4len)
+		if atyp[0] == socks5AtypIPv6 {
+			ip = make(net.IP, net.IPv6len)
+		}
+		if _, err := io.ReadFull(r, ip); err != nil {
+			return "", 0, err
+		}
+		host = ip.String()
+	case socks5AtypDomain:
+		var n [1]byte
+		if _, err := io.ReadFull(r, n[:]); err != nil {
+			return "", 0, err
+		}
+		name := make([]byte, n[0])
+		if _, err := io.ReadFull(r, name); err != nil {
+			return "", 0, err
+		}
+		host = string(name)
+	default:
+		return "", 0, fmt.Errorf("unknown SOCKS address type: %d", atyp[0])
+	}
+
+	var port [2]byte
+	if _, err := io.ReadFull(r, port[:]); err != nil {
+		return "", 0, err
+	}
+	return host, int(binary.BigEndian.Uint16(port[:])), nil
+}
+
+// WriteTo sends p to addr via the proxy's UDP relay.
+func (c *socks5PacketConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	udpAddr, ok := addr.(*net.UDPAddr)
+	if !ok {
+		return 0, fmt.Errorf("unsupported address type: %T", addr)
+	}
+
+	// RSV (2 bytes), FRAG, ATYP, DST.ADDR, DST.PORT, DATA
+	pkt := make([]byte, 0, 4+net.IPv6len+2+len(p))
+	pkt = append(pkt, 0, 0, 0)
+	if ip4 := udpAddr.IP.To4(); ip4 != nil {
+		pkt = append(pkt, socks5AtypIPv4)
+		pkt = append(pkt, ip4...)
+	} else {
+		pkt = append(pkt, socks5AtypIPv6)
+		pkt = append(pkt, udpAddr.IP.To16()...)
+	}
+	pkt = binary.BigEndian.AppendUint16(pkt, uint16(udpAddr.Port))
+	pkt = append(pkt, p...)
+
+	if _, err := c.PacketConn.WriteTo(pkt, c.relay); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// ReadFrom reads the next datagram from the proxy's UDP relay, stripping
+// the SOCKS5 UDP request header. Fragmented datagrams are discarded.
+func (c *socks5PacketConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	for {
+		n, _, err := c.PacketConn.ReadFrom(c.rbuf[:])
+		if err != nil {
+			return 0, nil, err
+		}
+		if n < 4 || c.rbuf[2] != 0x00 {
+			continue
+		}
+
+		r := bytes.NewReader(c.rbuf[3:n])
+		host, port, err := socks5ReadAddr(r)
+		if err != nil {
+			continue
+		}
+		ip := net.ParseIP(host)
+		if ip == nil {
+			continue
+		}
+		return copy(p, c.rbuf[n-r.Len():n]), &net.UDPAddr{IP: ip, Port: port}, nil
+	}
+}
+
+// Close tears down the UDP association and closes the local socket.
+func (c *socks5PacketConn) Close() error {
+	c.ctrl.Close()
+	return c.PacketConn.Close()
+}
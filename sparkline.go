@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// sparkBlocks are the characters of a sparkline, from lowest to highest.
+var sparkBlocks = []rune("▁▂▃▄▅▆▇█")
+
+// sparkWidth is the number of seconds a sparkline shows.
+const sparkWidth = 60
+
+// sparkline draws the throughput of the bulk transfer in each second as
+// a line of block characters on the terminal, redrawing it in place every
+// second, with the latest second's rate after it.
+type sparkline struct {
+	received uint64 // accessed atomically
+
+	stopCh chan struct{}
+	doneCh chan struct{}
+	once   sync.Once
+}
+
+func newSparkline() *sparkline {
+	return &sparkline{
+		stopCh: make(chan struct{}),
+		doneCh: make(chan struct{}),
+	}
+}
+
+// add counts n more bytes received.
+func (s *sparkline) add(n int) {
+	atomic.AddUint64(&s.received, uint64(n))
+}
+
+// run redraws the sparkline every second until stop is called.
+func (s *sparkline) run() {
+	defer close(s.doneCh)
+
+	t := time.NewTicker(time.Second)
+	defer t.Stop()
+
+	var (
+		prev  uint64
+		rates []float64
+	)
+	for {
+		select {
+		case <-s.stopCh:
+			if len(rates) > 0 {
+				fmt.Println()
+			}
+			return
+		case <-t.C:
+		}
+
+		n := atomic.LoadUint64(&s.received)
+		rates = append(rates, float64(n-prev)*8)
+		prev = n
+		if len(rates) > sparkWidth {
+			rates = rates[1:]
+		}
+
+		var max float64
+		for _, r := range rates {
+			if r > max {
+				max = r
+			}
+		}
+		var b strings.Builder
+		for _, r := range rates {
+			i := 0
+			if max > 0 {
+				i = int(r / max * float64(len(sparkBlocks)-1))
+			}
+			b.WriteRune(sparkBlocks[i])
+		}
+		fmt.Printf("\r%s %s\033[K", b.String(), formatRate(rates[len(rates)-1]))
+	}
+}
+
+// stop stops redrawing, ending the line the sparkline is on. It may be
+// called more than once.
+func (s *sparkline) stop() {
+	s.once.Do(func() { close(s.stopCh) })
+	<-s.doneCh
+}
@@ -0,0 +1,442 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/quic-go/quic-go/logging"
+)
+
+// Packet number spaces. 0-RTT and 1-RTT packets share the application
+// data space.
+const (
+	spaceInitial = iota
+	spaceHandshake
+	spaceAppData
+	numSpaces
+)
+
+var spaceNames = [numSpaces]string{"Initial", "Handshake", "1-RTT"}
+
+// spaceForLevel returns the packet number space of packets sent at level.
+func spaceForLevel(level logging.EncryptionLevel) int {
+	switch level {
+	case logging.EncryptionInitial:
+		return spaceInitial
+	case logging.EncryptionHandshake:
+		return spaceHandshake
+	default:
+		return spaceAppData
+	}
+}
+
+// spaceForHeader returns the packet number space of a long header packet.
+func spaceForHeader(hdr *logging.ExtendedHeader) int {
+	switch logging.PacketTypeFromHeader(&hdr.Header) {
+	case logging.PacketTypeInitial:
+		return spaceInitial
+	case logging.PacketTypeHandshake:
+		return spaceHandshake
+	default:
+		return spaceAppData
+	}
+}
+
+// spaceCounters counts the packets in one packet number space.
+type spaceCounters struct {
+	sent, received, acked, lost, ptos uint64
+	sentBytes, receivedBytes          logging.ByteCount
+}
+
+// connStats is a logging.ConnectionTracer that keeps the per-connection
+// counters qperf reports at the end of a test.
+type connStats struct {
+	logging.NullConnectionTracer
+
+	mu sync.Mutex
+	// 1-RTT packets received, and the range of packet numbers seen.
+	// Duplicates are dropped by quic-go before they're traced.
+	packetsReceived uint64
+	firstPN         logging.PacketNumber
+	largestPN       logging.PacketNumber
+
+	smoothedRTT time.Duration
+	minRTT      time.Duration
+	latestRTT   time.Duration
+	cwnd        logging.ByteCount
+
+	spaces [numSpaces]spaceCounters
+
+	// Sizes of the 1-RTT packets we've sent, for spotting packets that
+	// are too large for the path. Only packets larger than the largest
+	// acknowledged one are tracked individually.
+	largestSent     logging.ByteCount
+	largestAcked    logging.ByteCount
+	unackedLarge    map[logging.PacketNumber]logging.ByteCount
+	largeLost       uint64
+	largestLostSize logging.ByteCount
+
+	// Path MTU discovery in each direction. quic-go doesn't trace the
+	// MTU, so it is worked out from the probes: 1-RTT packets carrying
+	// nothing but a PING frame that are larger than any packet known to
+	// fit through the path.
+	start        time.Time
+	initialMTU   logging.ByteCount
+	mtu          logging.ByteCount
+	sentProbes   []mtuProbe
+	probePNs     map[logging.PacketNumber]int
+	largestRecvd logging.ByteCount
+	recvdProbes  []mtuProbe
+
+	peerParams *logging.TransportParameters
+	// When both 1-RTT keys were installed, which for a client is when
+	// the handshake completed.
+	handshakeDone time.Duration
+	oneRTTKeys    int
+	// Retry packets received, each costing the handshake a round trip.
+	retries int
+	// When the last packet was received, for telling a dead path from
+	// a quiet one.
+	lastReceived time.Time
+}
+
+// mtuProbe is a path MTU probe sent or received.
+type mtuProbe struct {
+	at      time.Duration // since the connection started
+	size    logging.ByteCount
+	outcome string // for probes we sent: acknowledged, lost or outstanding
+}
+
+// onlyPing reports whether frames is a lone PING frame, as sent in path
+// MTU probes.
+func onlyPing(frames []logging.Frame) bool {
+	if len(frames) != 1 {
+		return false
+	}
+	_, ok := frames[0].(*logging.PingFrame)
+	return ok
+}
+
+func newConnStats() *connStats {
+	return &connStats{
+		unackedLarge: make(map[logging.PacketNumber]logging.ByteCount),
+		probePNs:     make(map[logging.PacketNumber]int),
+	}
+}
+
+func (s *connStats) ReceivedTransportParameters(tp *logging.TransportParameters) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.peerParams = tp
+}
+
+// transportParams returns the transport parameters the peer advertised.
+func (s *connStats) transportParams() *logging.TransportParameters {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.peerParams
+}
+
+func (s *connStats) StartedConnection(_, remote net.Addr, _, _ logging.ConnectionID) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.start = time.Now()
+	s.initialMTU = logging.ByteCount(initialPacketSize(remote.String()))
+	s.mtu = s.initialMTU
+}
+
+func (s *connStats) SentLongHeaderPacket(hdr *logging.ExtendedHeader, size logging.ByteCount, _ *logging.AckFrame, _ []logging.Frame) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c := &s.spaces[spaceForHeader(hdr)]
+	c.sent++
+	c.sentBytes += size
+}
+
+func (s *connStats) ReceivedLongHeaderPacket(hdr *logging.ExtendedHeader, size logging.ByteCount, _ []logging.Frame) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c := &s.spaces[spaceForHeader(hdr)]
+	c.received++
+	c.receivedBytes += size
+	s.lastReceived = time.Now()
+}
+
+// sinceReceived returns how long ago the last packet was received.
+func (s *connStats) sinceReceived() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return time.Since(s.lastReceived)
+}
+
+func (s *connStats) ReceivedRetry(*logging.Header) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.retries++
+}
+
+func (s *connStats) UpdatedKeyFromTLS(level logging.EncryptionLevel, _ logging.Perspective) {
+	if level != logging.Encryption1RTT {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.oneRTTKeys++; s.oneRTTKeys == 2 {
+		s.handshakeDone = time.Since(s.start)
+	}
+}
+
+func (s *connStats) LossTimerExpired(timerType logging.TimerType, level logging.EncryptionLevel) {
+	if timerType != logging.TimerTypePTO {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.spaces[spaceForLevel(level)].ptos++
+}
+
+func (s *connStats) SentShortHeaderPacket(hdr *logging.ShortHeader, size logging.ByteCount, ack *logging.AckFrame, frames []logging.Frame) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.spaces[spaceAppData].sent++
+	if ack == nil && onlyPing(frames) && size > s.mtu {
+		s.probePNs[hdr.PacketNumber] = len(s.sentProbes)
+		s.sentProbes = append(s.sentProbes, mtuProbe{at: time.Since(s.start), size: size, outcome: "outstanding"})
+	}
+
+	if size > s.largestSent {
+		s.largestSent = size
+	}
+	if size > s.largestAcked {
+		s.unackedLarge[hdr.PacketNumber] = size
+	}
+}
+
+func (s *connStats) AcknowledgedPacket(level logging.EncryptionLevel, pn logging.PacketNumber) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.spaces[spaceForLevel(level)].acked++
+	if level != logging.Encryption1RTT {
+		return
+	}
+
+	if i, ok := s.probePNs[pn]; ok {
+		delete(s.probePNs, pn)
+		s.sentProbes[i].outcome = "acknowledged"
+		if size := s.sentProbes[i].size; size > s.mtu {
+			s.mtu = size
+		}
+	}
+
+	if size, ok := s.unackedLarge[pn]; ok {
+		delete(s.unackedLarge, pn)
+		if size > s.largestAcked {
+			s.largestAcked = size
+			for pn, size := range s.unackedLarge {
+				if size <= s.largestAcked {
+					delete(s.unackedLarge, pn)
+				}
+			}
+		}
+	}
+}
+
+func (s *connStats) LostPacket(level logging.EncryptionLevel, pn logging.PacketNumber, _ logging.PacketLossReason) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.spaces[spaceForLevel(level)].lost++
+	if level != logging.Encryption1RTT {
+		return
+	}
+
+	if i, ok := s.probePNs[pn]; ok {
+		delete(s.probePNs, pn)
+		s.sentProbes[i].outcome = "lost"
+	}
+
+	if size, ok := s.unackedLarge[pn]; ok {
+		delete(s.unackedLarge, pn)
+		s.largeLost++
+		if size > s.largestLostSize {
+			s.largestLostSize = size
+		}
+	}
+}
+
+// packetSizes returns the size of the largest 1-RTT packet sent and
+// acknowledged, and the number of packets lost that were larger than any
+// acknowledged packet. Such losses suggest that the path drops packets
+// above a certain size rather than fragmenting them.
+func (s *connStats) packetSizes() (largestSent, largestAcked logging.ByteCount, largeLost uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// A loss followed by the acknowledgement of an even larger packet
+	// wasn't down to its size.
+	if s.largestLostSize <= s.largestAcked {
+		return s.largestSent, s.largestAcked, 0
+	}
+	return s.largestSent, s.largestAcked, s.largeLost
+}
+
+func (s *connStats) UpdatedMetrics(rttStats *logging.RTTStats, cwnd, _ logging.ByteCount, _ int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.smoothedRTT = rttStats.SmoothedRTT()
+	s.minRTT = rttStats.MinRTT()
+	s.latestRTT = rttStats.LatestRTT()
+	s.cwnd = cwnd
+}
+
+// metrics returns the current smoothed and latest RTT and our congestion
+// window.
+func (s *connStats) metrics() (smoothed, latest time.Duration, cwnd logging.ByteCount) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.smoothedRTT, s.latestRTT, s.cwnd
+}
+
+// rtt returns the current smoothed and minimum RTT.
+func (s *connStats) rtt() (smoothed, minimum time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.smoothedRTT, s.minRTT
+}
+
+// received returns the number of 1-RTT packets received and the largest
+// packet number seen, for computing the loss over an interval.
+func (s *connStats) received() (packets uint64, largestPN logging.PacketNumber) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.packetsReceived, s.largestPN
+}
+
+func (s *connStats) ReceivedShortHeaderPacket(hdr *logging.ShortHeader, size logging.ByteCount, frames []logging.Frame) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.spaces[spaceAppData].received++
+	s.lastReceived = time.Now()
+	if size > s.largestRecvd {
+		if size > s.initialMTU && onlyPing(frames) {
+			s.recvdProbes = append(s.recvdProbes, mtuProbe{at: time.Since(s.start), size: size})
+		}
+		s.largestRecvd = size
+	}
+	if s.packetsReceived == 0 || hdr.PacketNumber < s.firstPN {
+		s.firstPN = hdr.PacketNumber
+	}
+	if s.packetsReceived == 0 || hdr.PacketNumber > s.largestPN {
+		s.largestPN = hdr.PacketNumber
+	}
+	s.packetsReceived++
+}
+
+// receiveLoss estimates the fraction of 1-RTT packets sent by the peer
+// that never arrived, from the gaps in the packet numbers received. It
+// returns the estimate along with the number of packets received and the
+// number the peer is assumed to have sent.
+//
+// The estimate slightly overstates loss, since senders may deliberately
+// skip packet numbers.
+func (s *connStats) receiveLoss() (loss float64, received, sent uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.packetsReceived == 0 {
+		return 0, 0, 0
+	}
+	sent = uint64(s.largestPN-s.firstPN) + 1
+	return 1 - float64(s.packetsReceived)/float64(sent), s.packetsReceived, sent
+}
+
+// printSpaces prints the packet counters for each packet number space, so
+// that trouble during the handshake can be told apart from steady state
+// loss.
+func (s *connStats) printSpaces() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, c := range s.spaces {
+		fmt.Printf("%s packets: %d sent, %d received, %d acknowledged, %d lost, %d PTOs\n",
+			spaceNames[i], c.sent, c.received, c.acked, c.lost, c.ptos)
+	}
+}
+
+// printHandshake prints how long the handshake took and the size of the
+// Initial and Handshake packets, which carry it.
+func (s *connStats) printHandshake() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	retry := ""
+	if s.retries > 0 {
+		retry = ", including a Retry round trip"
+	}
+	in, hs := s.spaces[spaceInitial], s.spaces[spaceHandshake]
+	fmt.Printf("Handshake: %.3f ms%s; sent %d Initial and %d Handshake packets (%d bytes), received %d and %d (%d bytes)\n",
+		float64(s.handshakeDone)/1e6, retry, in.sent, hs.sent, in.sentBytes+hs.sentBytes,
+		in.received, hs.received, in.receivedBytes+hs.receivedBytes)
+}
+
+// printMTU prints the path MTU at the start and end of the connection in
+// each direction, with the probes that found it.
+func (s *connStats) printMTU() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var probes []string
+	for _, p := range s.sentProbes {
+		probes = append(probes, fmt.Sprintf("%d bytes %s at %.3f s", p.size, p.outcome, p.at.Seconds()))
+	}
+	fmt.Printf("Path MTU to the server: %d bytes at the start, %d bytes at the end (%d probes%s)\n",
+		s.initialMTU, s.mtu, len(s.sentProbes), listSuffix(probes))
+
+	probes = probes[:0]
+	for _, p := range s.recvdProbes {
+		probes = append(probes, fmt.Sprintf("%d bytes at %.3f s", p.size, p.at.Seconds()))
+	}
+	fmt.Printf("Path MTU from the server: largest packet received %d bytes (%d probes received%s)\n",
+		s.largestRecvd, len(s.recvdProbes), listSuffix(probes))
+}
+
+// listSuffix formats items as a list to follow a count.
+func listSuffix(items []string) string {
+	if len(items) == 0 {
+		return ""
+	}
+	return ": " + strings.Join(items, ", ")
+}
+
+// statsTracer is a logging.Tracer that hands out the same connStats to
+// every connection, which is fine for the client since it only makes one.
+type statsTracer struct {
+	logging.NullTracer
+	stats *connStats
+}
+
+func (t statsTracer) TracerForConnection(context.Context, logging.Perspective, logging.ConnectionID) logging.ConnectionTracer {
+	return t.stats
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+const (
+	// maxRecentConns is how many finished connections the status API
+	// reports.
+	maxRecentConns = 100
+
+	// statusRateInterval is the interval the current send rate of each
+	// connection is measured over.
+	statusRateInterval = time.Second
+)
+
+// statusServer keeps track of the server's connections for the status
+// API, which serves them as JSON at /status and streams the active ones
+// at /events.
+type statusServer struct {
+	mu     sync.Mutex
+	active map[quic.Connection]*statusConn
+	recent []*statusConn
+}
+
+// statusConn is a connection tracked by the status API.
+type statusConn struct {
+	conn  quic.Connection
+	start time.Time
+	end   time.Time    // zero while the connection is open
+	sent  *sendCounter // nil until the bulk transfer starts
+
+	// The bytes sent when the current rate was last measured.
+	lastSent uint64
+	rate     float64
+}
+
+// serverStatus is the server's status API, or nil if -status isn't set.
+var serverStatus *statusServer
+
+func newStatusServer() *statusServer {
+	return &statusServer{active: make(map[quic.Connection]*statusConn)}
+}
+
+// serve serves the status API on addr until it fails.
+func (s *statusServer) serve(addr string) error {
+	go s.measureRates()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "use GET", http.StatusMethodNotAllowed)
+			return
+		}
+
+		s.mu.Lock()
+		resp := struct {
+			Active []connStatus `json:"active"`
+			Recent []connStatus `json:"recent"`
+			Tags   tagList      `json:"tags,omitempty"`
+		}{[]connStatus{}, []connStatus{}, resultTags}
+		for _, sc := range s.active {
+			resp.Active = append(resp.Active, sc.status())
+		}
+		for _, sc := range s.recent {
+			resp.Recent = append(resp.Recent, sc.status())
+		}
+		s.mu.Unlock()
+
+		writeJSON(w, resp)
+	})
+	mux.HandleFunc("/events", s.serveEvents)
+	return http.ListenAndServe(addr, mux)
+}
+
+// serveEvents streams the active connections to the client as server-sent
+// events, one every statusRateInterval, until the client goes away. Each
+// event's data is a JSON array like the active list of /status.
+func (s *statusServer) serveEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// Let dashboards served from elsewhere subscribe.
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	t := time.NewTicker(statusRateInterval)
+	defer t.Stop()
+	for {
+		s.mu.Lock()
+		active := []connStatus{}
+		for _, sc := range s.active {
+			active = append(active, sc.status())
+		}
+		s.mu.Unlock()
+
+		b, err := json.Marshal(active)
+		if err != nil {
+			return
+		}
+		if _, err := fmt.Fprintf(w, "data: %s\n\n", b); err != nil {
+			return
+		}
+		flusher.Flush()
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// add tracks conn until it's closed.
+func (s *statusServer) add(conn quic.Connection) {
+	sc := &statusConn{conn: conn, start: time.Now()}
+
+	s.mu.Lock()
+	s.active[conn] = sc
+	s.mu.Unlock()
+
+	go func() {
+		<-conn.Context().Done()
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		sc.end = time.Now()
+		delete(s.active, conn)
+		s.recent = append(s.recent, sc)
+		if len(s.recent) > maxRecentConns {
+			s.recent = s.recent[1:]
+		}
+	}()
+}
+
+// track records w as the counter of the bytes sent on conn.
+func (s *statusServer) track(conn quic.Connection, w *sendCounter) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if sc, ok := s.active[conn]; ok {
+		sc.sent = w
+	}
+}
+
+// measureRates updates the current send rate of every active connection
+// each statusRateInterval.
+func (s *statusServer) measureRates() {
+	for range time.Tick(statusRateInterval) {
+		s.mu.Lock()
+		for _, sc := range s.active {
+			if sc.sent == nil {
+				continue
+			}
+			n := sc.sent.bytes()
+			sc.rate = float64(n-sc.lastSent) * 8 / statusRateInterval.Seconds()
+			sc.lastSent = n
+		}
+		s.mu.Unlock()
+	}
+}
+
+// status describes sc. The statusServer's mutex must be held.
+func (sc *statusConn) status() connStatus {
+	end := sc.end
+	if end.IsZero() {
+		end = time.Now()
+	}
+	cs := connStatus{
+		Conn:        sc.conn.Context().Value(quic.ConnectionTracingKey).(uint64),
+		Remote:      sc.conn.RemoteAddr().String(),
+		Seconds:     end.Sub(sc.start).Seconds(),
+		Done:        !sc.end.IsZero(),
+		CurrentRate: sc.rate,
+	}
+	if sc.sent != nil {
+		cs.BytesSent = sc.sent.bytes()
+	}
+	if cs.Seconds > 0 {
+		cs.Throughput = float64(cs.BytesSent) * 8 / cs.Seconds
+	}
+	if cs.Done {
+		cs.CurrentRate = 0
+	}
+	return cs
+}
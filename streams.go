@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+// streamStats describes how the data on one of the parallel streams
+// arrived.
+type streamStats struct {
+	bytes uint64
+	// Time from the start of the test to the first byte and to the end
+	// of the stream, or of the test if the stream was still going.
+	firstByte, done time.Duration
+	complete        bool
+}
+
+// runStreams receives on -streams parallel streams for the duration of the
+// test, then prints how each stream fared alongside the aggregate. Each
+// stream is an RPC asking for as much data as the server can send.
+func runStreams(ctx context.Context, conn quic.Connection) testResult {
+	cancelBulkStream(ctx, conn)
+
+	// The largest response there is, which newRPCRequest can't ask for
+	// where int is 32 bits.
+	req := make([]byte, rpcHeaderLen)
+	binary.BigEndian.PutUint32(req, math.MaxUint32)
+
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(*durationInSecs)*time.Second)
+	defer cancel()
+
+	var (
+		stats = make([]streamStats, *streams)
+		wg    sync.WaitGroup
+	)
+	start := time.Now()
+	for i := range stats {
+		wg.Add(1)
+		go func(st *streamStats) {
+			defer wg.Done()
+			if err := receiveStream(ctx, conn, req, start, st); err != nil && ctx.Err() == nil {
+				log.Errorf("Error receiving stream from %s: %v", conn.RemoteAddr(), err)
+			}
+		}(&stats[i])
+	}
+	wg.Wait()
+	dur := time.Since(start)
+
+	var total uint64
+	for i, st := range stats {
+		total += st.bytes
+		state := "still sending at the end of the test"
+		if st.complete {
+			state = fmt.Sprintf("complete after %.3f seconds", st.done.Seconds())
+		}
+		fmt.Printf("Stream %d: %d bytes (%s), first byte after %.3f ms, %s\n",
+			i+1, st.bytes, rate(st.bytes, st.done-st.firstByte),
+			float64(st.firstByte)/1e6, state)
+	}
+	fmt.Printf("Received: %d bytes on %d streams in %.3f seconds (%s)\n",
+		total, len(stats), dur.Seconds(), rate(total, dur))
+
+	return testResult{bytes: total, duration: dur}
+}
+
+// receiveStream requests data with req and reads it until the server
+// finishes the stream or ctx is done, recording its arrival in st.
+func receiveStream(ctx context.Context, conn quic.Connection, req []byte, start time.Time, st *streamStats) error {
+	s, err := openRPC(ctx, conn, req)
+	if err != nil {
+		return err
+	}
+	defer func() { st.done = time.Since(start) }()
+
+	var buf [readChunkSize]byte
+	for {
+		n, err := s.Read(buf[:])
+		if n > 0 && st.bytes == 0 {
+			st.firstByte = time.Since(start)
+		}
+		st.bytes += uint64(n)
+		if err == io.EOF {
+			st.complete = true
+			return nil
+		}
+		if err != nil {
+			s.CancelRead(quic.StreamErrorCode(quic.NoError))
+			if e, ok := err.(net.Error); ok && e.Timeout() {
+				return nil
+			}
+			return err
+		}
+	}
+}
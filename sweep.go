@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+// sweepParam is a flag and the values a sweep sets it to in turn.
+type sweepParam struct {
+	name   string
+	values []string
+}
+
+// sweepResult is the outcome of one run of a sweep.
+type sweepResult struct {
+	Params     map[string]string `json:"params"`
+	Bytes      uint64            `json:"bytes"`
+	Seconds    float64           `json:"seconds"`
+	Throughput float64           `json:"throughput_bps"`
+	Loss       float64           `json:"loss"`
+	Tags       tagList           `json:"tags,omitempty"`
+	Env        *environment      `json:"env,omitempty"`
+}
+
+// parseSweep parses a -sweep specification of semicolon-separated
+// name=value,value,... terms, each naming a flag and the values to try.
+func parseSweep(spec string) ([]sweepParam, error) {
+	var params []sweepParam
+	for _, term := range strings.Split(spec, ";") {
+		term = strings.TrimSpace(term)
+		if term == "" {
+			continue
+		}
+		name, values, ok := strings.Cut(term, "=")
+		if !ok || values == "" {
+			return nil, fmt.Errorf("invalid sweep term %q, want name=value,value,...", term)
+		}
+		name = strings.TrimPrefix(strings.TrimSpace(name), "-")
+		if flag.Lookup(name) == nil {
+			return nil, fmt.Errorf("can't sweep unknown flag -%s", name)
+		}
+		params = append(params, sweepParam{name: name, values: strings.Split(values, ",")})
+	}
+	if len(params) == 0 {
+		return nil, fmt.Errorf("empty sweep %q", spec)
+	}
+	return params, nil
+}
+
+// runSweep runs the client once for every combination of the values in
+// -sweep, then prints a table of the results and, with -sweep-json,
+// writes them to a file as JSON. It reports whether every run met the
+// -min-throughput and -max-loss thresholds.
+func runSweep(ctx context.Context) bool {
+	params, err := parseSweep(*sweep)
+	if err != nil {
+		log.Exitf("Fatal error: %v", err)
+	}
+
+	var (
+		results []sweepResult
+		ok      = true
+	)
+	combo := make([]string, len(params))
+	var run func(i int)
+	run = func(i int) {
+		if i < len(params) {
+			for _, v := range params[i].values {
+				if err := flag.Set(params[i].name, strings.TrimSpace(v)); err != nil {
+					log.Exitf("Fatal error setting -%s for sweep: %v", params[i].name, err)
+				}
+				combo[i] = strings.TrimSpace(v)
+				run(i + 1)
+			}
+			return
+		}
+
+		res := sweepResult{Params: make(map[string]string), Tags: resultTags}
+		var desc []string
+		for j, p := range params {
+			res.Params[p.name] = combo[j]
+			desc = append(desc, fmt.Sprintf("-%s=%s", p.name, combo[j]))
+		}
+		fmt.Printf("=== Run %d: %s\n", len(results)+1, strings.Join(desc, " "))
+		r, err := clientMain(ctx)
+		if err != nil {
+			log.Exitf("Fatal error: %v", err)
+		}
+		if !checkThresholds(r) {
+			ok = false
+		}
+		res.Bytes, res.Seconds, res.Throughput, res.Loss = r.bytes, r.duration.Seconds(), r.throughput(), r.loss
+		res.Env = r.env
+		results = append(results, res)
+	}
+	run(0)
+
+	fmt.Println()
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	for _, p := range params {
+		fmt.Fprintf(tw, "%s\t", p.name)
+	}
+	fmt.Fprintln(tw, "bytes\tseconds\tthroughput\tloss")
+	for _, res := range results {
+		for _, p := range params {
+			fmt.Fprintf(tw, "%s\t", res.Params[p.name])
+		}
+		fmt.Fprintf(tw, "%d\t%.3f\t%s\t%.3f%%\n", res.Bytes, res.Seconds, formatRate(res.Throughput), res.Loss*100)
+	}
+	tw.Flush()
+
+	if *sweepJSON != "" {
+		b, err := json.MarshalIndent(results, "", "  ")
+		if err != nil {
+			log.Exitf("Fatal error encoding sweep results: %v", err)
+		}
+		if err := os.WriteFile(*sweepJSON, append(b, '\n'), 0o644); err != nil {
+			log.Exitf("Fatal error writing sweep results: %v", err)
+		}
+	}
+	return ok
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+)
+
+// listenFDsStart is the first file descriptor passed by systemd.
+const listenFDsStart = 3
+
+// systemdPacketConn returns the UDP socket passed to us by systemd socket
+// activation, or nil if we weren't socket activated. See sd_listen_fds(3).
+func systemdPacketConn() (*net.UDPConn, error) {
+	if os.Getenv("LISTEN_PID") != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n < 1 {
+		return nil, nil
+	}
+	if n > 1 {
+		return nil, fmt.Errorf("systemd passed %d sockets, want 1", n)
+	}
+
+	// Don't pass the sockets on to any children.
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	f := os.NewFile(listenFDsStart, "systemd socket")
+	defer f.Close()
+	c, err := net.FilePacketConn(f)
+	if err != nil {
+		return nil, fmt.Errorf("using socket passed by systemd: %w", err)
+	}
+	udpConn, ok := c.(*net.UDPConn)
+	if !ok {
+		c.Close()
+		return nil, fmt.Errorf("socket passed by systemd is not a UDP socket")
+	}
+	return udpConn, nil
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// tagList is a flag.Value holding the key=value pairs of every -tag flag
+// given, which are attached to the structured results.
+type tagList map[string]string
+
+func (t *tagList) String() string {
+	var s []string
+	for k, v := range *t {
+		s = append(s, k+"="+v)
+	}
+	sort.Strings(s)
+	return strings.Join(s, ",")
+}
+
+func (t *tagList) Set(s string) error {
+	k, v, ok := strings.Cut(s, "=")
+	k = strings.TrimSpace(k)
+	if !ok || k == "" {
+		return fmt.Errorf("invalid tag %q, want key=value", s)
+	}
+	if *t == nil {
+		*t = make(tagList)
+	}
+	(*t)[k] = strings.TrimSpace(v)
+	return nil
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strings"
+)
+
+// tls13CipherSuites are the TLS 1.3 cipher suites, which are the only
+// ones QUIC uses.
+var tls13CipherSuites = []uint16{
+	tls.TLS_AES_128_GCM_SHA256,
+	tls.TLS_AES_256_GCM_SHA384,
+	tls.TLS_CHACHA20_POLY1305_SHA256,
+}
+
+// keyExchangeGroups are the key exchange groups Go's TLS supports, by
+// the names accepted by -tls-groups.
+var keyExchangeGroups = map[string]tls.CurveID{
+	"X25519": tls.X25519,
+	"P256":   tls.CurveP256,
+	"P384":   tls.CurveP384,
+	"P521":   tls.CurveP521,
+}
+
+// cipherSuites is a flag.Value holding a comma-separated list of TLS 1.3
+// cipher suites in order of preference, named as in RFC 8446, e.g.
+// TLS_CHACHA20_POLY1305_SHA256.
+type cipherSuites []uint16
+
+func (c *cipherSuites) String() string {
+	var s []string
+	for _, id := range *c {
+		s = append(s, tls.CipherSuiteName(id))
+	}
+	return strings.Join(s, ",")
+}
+
+func (c *cipherSuites) Set(s string) error {
+	var ids []uint16
+	for _, f := range strings.Split(s, ",") {
+		name := strings.TrimSpace(f)
+		found := false
+		for _, id := range tls13CipherSuites {
+			if strings.EqualFold(name, tls.CipherSuiteName(id)) {
+				ids = append(ids, id)
+				found = true
+			}
+		}
+		if !found {
+			var names []string
+			for _, id := range tls13CipherSuites {
+				names = append(names, tls.CipherSuiteName(id))
+			}
+			return fmt.Errorf("unknown TLS 1.3 cipher suite %q, want one of %s", name, strings.Join(names, ", "))
+		}
+	}
+	*c = ids
+	return nil
+}
+
+// curveIDs is a flag.Value holding a comma-separated list of key exchange
+// groups in order of preference, e.g. X25519,P256.
+type curveIDs []tls.CurveID
+
+func (c *curveIDs) String() string {
+	var s []string
+	for _, id := range *c {
+		for name, g := range keyExchangeGroups {
+			if g == id {
+				s = append(s, name)
+			}
+		}
+	}
+	return strings.Join(s, ",")
+}
+
+func (c *curveIDs) Set(s string) error {
+	var ids []tls.CurveID
+	for _, f := range strings.Split(s, ",") {
+		name := strings.ToUpper(strings.TrimSpace(f))
+		id, ok := keyExchangeGroups[name]
+		if !ok && strings.Contains(name, "KEM") {
+			return fmt.Errorf("post-quantum key exchange group %q isn't supported by the TLS stack quic-go v0.32 uses", f)
+		}
+		if !ok {
+			return fmt.Errorf("unknown key exchange group %q, want X25519, P256, P384 or P521", f)
+		}
+		ids = append(ids, id)
+	}
+	*c = ids
+	return nil
+}
+
+// restrictTLS limits the cipher suites and key exchange groups c offers
+// or accepts to those given by -tls-ciphers and -tls-groups.
+func restrictTLS(c *tls.Config) {
+	if len(tlsCiphers) > 0 {
+		c.CipherSuites = tlsCiphers
+	}
+	if len(tlsGroups) > 0 {
+		c.CurvePreferences = tlsGroups
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/logging"
+)
+
+// transportParams is a flag.Value holding the transport parameters to
+// advertise, as a comma-separated list of name=value pairs using the names
+// from RFC 9000, e.g. initial_max_data=1048576,max_idle_timeout=30s.
+type transportParams map[string]string
+
+// quic-go's defaults for the receive windows that flow control
+// auto-tuning may grow to, used when the quic.Config fields are 0.
+const (
+	quicMaxStreamReceiveWindow     = 6 << 20
+	quicMaxConnectionReceiveWindow = 15 << 20
+)
+
+// raiseMaxWindow raises the maximum receive window *max, which is def
+// when 0, to n if n is larger, so that an initial window is never above
+// the maximum. A smaller n leaves it alone, keeping auto-tuning free to
+// grow the window past n.
+func raiseMaxWindow(max *uint64, def, n uint64) {
+	cur := *max
+	if cur == 0 {
+		cur = def
+	}
+	if n > cur {
+		*max = n
+	}
+}
+
+// settableTransportParams are the transport parameters quic-go lets us
+// set, with the quic.Config fields that set them.
+var settableTransportParams = map[string]func(*quic.Config, string) error{
+	"max_idle_timeout": func(c *quic.Config, v string) error {
+		d, err := time.ParseDuration(v)
+		c.MaxIdleTimeout = d
+		return err
+	},
+	"initial_max_data": func(c *quic.Config, v string) error {
+		n, err := strconv.ParseUint(v, 10, 62)
+		c.InitialConnectionReceiveWindow = n
+		raiseMaxWindow(&c.MaxConnectionReceiveWindow, quicMaxConnectionReceiveWindow, n)
+		return err
+	},
+	"initial_max_stream_data": func(c *quic.Config, v string) error {
+		n, err := strconv.ParseUint(v, 10, 62)
+		c.InitialStreamReceiveWindow = n
+		raiseMaxWindow(&c.MaxStreamReceiveWindow, quicMaxStreamReceiveWindow, n)
+		return err
+	},
+	"initial_max_streams_bidi": func(c *quic.Config, v string) error {
+		n, err := strconv.ParseInt(v, 10, 64)
+		c.MaxIncomingStreams = n
+		return err
+	},
+	"initial_max_streams_uni": func(c *quic.Config, v string) error {
+		n, err := strconv.ParseInt(v, 10, 64)
+		c.MaxIncomingUniStreams = n
+		return err
+	},
+}
+
+// fixedTransportParams are transport parameters that quic-go chooses
+// itself.
+var fixedTransportParams = map[string]bool{
+	"max_udp_payload_size":       true,
+	"ack_delay_exponent":         true,
+	"max_ack_delay":              true,
+	"active_connection_id_limit": true,
+	"disable_active_migration":   true,
+	"max_datagram_frame_size":    true,
+}
+
+func (p *transportParams) String() string {
+	var s []string
+	for name, v := range *p {
+		s = append(s, name+"="+v)
+	}
+	sort.Strings(s)
+	return strings.Join(s, ",")
+}
+
+func (p *transportParams) Set(s string) error {
+	params := make(transportParams)
+	for _, f := range strings.Split(s, ",") {
+		name, v, ok := strings.Cut(strings.TrimSpace(f), "=")
+		if !ok {
+			return fmt.Errorf("invalid transport parameter %q, want name=value", f)
+		}
+		set, ok := settableTransportParams[name]
+		if !ok {
+			if fixedTransportParams[name] {
+				return fmt.Errorf("quic-go doesn't let %s be set", name)
+			}
+			return fmt.Errorf("unknown transport parameter %q", name)
+		}
+		if err := set(&quic.Config{}, v); err != nil {
+			return fmt.Errorf("invalid %s %q", name, v)
+		}
+		params[name] = v
+	}
+	*p = params
+	return nil
+}
+
+// apply sets the transport parameters in c.
+func (p transportParams) apply(c *quic.Config) {
+	for name, v := range p {
+		// The values were checked by Set.
+		settableTransportParams[name](c, v)
+	}
+}
+
+// printTransportParams prints the transport parameters advertised by the
+// peer.
+func printTransportParams(tp *logging.TransportParameters) {
+	if tp == nil {
+		return
+	}
+	// quic-go marks a missing max_datagram_frame_size with -1.
+	var maxDatagram interface{} = tp.MaxDatagramFrameSize
+	if tp.MaxDatagramFrameSize < 0 {
+		maxDatagram = "absent (no datagram support)"
+	}
+
+	fmt.Println("Server transport parameters:")
+	for _, p := range []struct {
+		name  string
+		value interface{}
+	}{
+		{"max_idle_timeout", tp.MaxIdleTimeout},
+		{"max_udp_payload_size", tp.MaxUDPPayloadSize},
+		{"initial_max_data", tp.InitialMaxData},
+		{"initial_max_stream_data_bidi_local", tp.InitialMaxStreamDataBidiLocal},
+		{"initial_max_stream_data_bidi_remote", tp.InitialMaxStreamDataBidiRemote},
+		{"initial_max_stream_data_uni", tp.InitialMaxStreamDataUni},
+		{"initial_max_streams_bidi", tp.MaxBidiStreamNum},
+		{"initial_max_streams_uni", tp.MaxUniStreamNum},
+		{"ack_delay_exponent", tp.AckDelayExponent},
+		{"max_ack_delay", tp.MaxAckDelay},
+		{"disable_active_migration", tp.DisableActiveMigration},
+		{"active_connection_id_limit", tp.ActiveConnectionIDLimit},
+		{"max_datagram_frame_size", maxDatagram},
+	} {
+		fmt.Printf("  %s: %v\n", p.name, p.value)
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// udpCounters are the counters Linux keeps of UDP datagrams it dropped
+// rather than delivering them, as found in /proc/net/snmp for the whole
+// network namespace and in /proc/net/udp and /proc/net/udp6 for the
+// sockets on one port.
+type udpCounters struct {
+	inErrors, rcvbufErrors, sndbufErrors, memErrors uint64
+	socketDrops                                     uint64
+}
+
+// udpDrops compares the kernel's UDP drop counters at the start and end of
+// a test.
+type udpDrops struct {
+	port   int
+	before udpCounters
+}
+
+// snapshotUDPDrops reads the kernel's UDP drop counters for the start of a
+// test on the socket bound to laddr. It returns nil if they can't be read,
+// e.g. when not running on Linux.
+func snapshotUDPDrops(laddr net.Addr) *udpDrops {
+	udpAddr, ok := laddr.(*net.UDPAddr)
+	if !ok {
+		return nil
+	}
+	c, err := readUDPCounters(udpAddr.Port)
+	if err != nil {
+		return nil
+	}
+	return &udpDrops{port: udpAddr.Port, before: c}
+}
+
+// report prints how much the counters grew during the test.
+func (d *udpDrops) report() {
+	if d == nil {
+		return
+	}
+	after, err := readUDPCounters(d.port)
+	if err != nil {
+		log.Warningf("Error reading kernel UDP counters: %v", err)
+		return
+	}
+
+	fmt.Printf("Kernel UDP drops: %d by this socket; system-wide %d receive errors, %d receive buffer errors, %d send buffer errors, %d memory errors\n",
+		after.socketDrops-d.before.socketDrops,
+		after.inErrors-d.before.inErrors,
+		after.rcvbufErrors-d.before.rcvbufErrors,
+		after.sndbufErrors-d.before.sndbufErrors,
+		after.memErrors-d.before.memErrors)
+	if after.socketDrops > d.before.socketDrops {
+		fmt.Println("Warning: the kernel dropped packets because the socket's receive buffer was full; some of the loss happened on this host, not on the network")
+	}
+}
+
+func readUDPCounters(port int) (udpCounters, error) {
+	var c udpCounters
+	snmp, err := readSNMP("/proc/net/snmp", "Udp:")
+	if err != nil {
+		return c, err
+	}
+	c.inErrors = snmp["InErrors"]
+	c.rcvbufErrors = snmp["RcvbufErrors"]
+	c.sndbufErrors = snmp["SndbufErrors"]
+	c.memErrors = snmp["MemErrors"]
+
+	for _, path := range []string{"/proc/net/udp", "/proc/net/udp6"} {
+		drops, err := readSocketDrops(path, port)
+		if err != nil && !os.IsNotExist(err) {
+			return c, err
+		}
+		c.socketDrops += drops
+	}
+	return c, nil
+}
+
+// readSNMP returns the counters on the lines of the file at path starting
+// with prefix: a line naming them followed by a line with their values.
+func readSNMP(path, prefix string) (map[string]uint64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var names []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) == 0 || fields[0] != prefix {
+			continue
+		}
+		if names == nil {
+			names = fields[1:]
+			continue
+		}
+		counters := make(map[string]uint64)
+		for i, v := range fields[1:] {
+			if i < len(names) {
+				counters[names[i]], _ = strconv.ParseUint(v, 10, 64)
+			}
+		}
+		return counters, nil
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return nil, fmt.Errorf("no %s counters in %s", prefix, path)
+}
+
+// readSocketDrops returns the sum of the drop counters of the sockets in
+// the socket table at path, in the format of /proc/net/udp, bound to
+// port.
+func readSocketDrops(path string, port int) (uint64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	var drops uint64
+	sc := bufio.NewScanner(f)
+	sc.Scan() // the header
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 13 {
+			continue
+		}
+		// The local address is written as hexadecimal IP:port.
+		_, hexPort, ok := strings.Cut(fields[1], ":")
+		if !ok {
+			continue
+		}
+		if p, err := strconv.ParseUint(hexPort, 16, 16); err != nil || int(p) != port {
+			continue
+		}
+		d, _ := strconv.ParseUint(fields[len(fields)-1], 10, 64)
+		drops += d
+	}
+	return drops, sc.Err()
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// siPrefixes maps the SI prefixes accepted in rates to their multipliers.
+var siPrefixes = map[string]float64{
+	"":  1,
+	"k": 1e3,
+	"m": 1e6,
+	"g": 1e9,
+	"t": 1e12,
+}
+
+// bitRate is a flag.Value holding a rate in bits per second. It is written
+// as a number followed by an optional SI prefix and an optional "bps"
+// suffix, e.g. 500Mbps, 1.5G or 64k.
+type bitRate float64
+
+func (r *bitRate) String() string {
+	return fmt.Sprintf("%gbps", float64(*r))
+}
+
+func (r *bitRate) Set(s string) error {
+	v, err := parseBitRate(s)
+	if err != nil {
+		return err
+	}
+	*r = bitRate(v)
+	return nil
+}
+
+// parseBitRate parses a rate as described for bitRate.
+func parseBitRate(s string) (float64, error) {
+	t := strings.ToLower(strings.TrimSpace(s))
+	t = strings.TrimSuffix(t, "bps")
+	i := strings.IndexFunc(t, func(r rune) bool { return r >= 'a' && r <= 'z' })
+	num, prefix := t, ""
+	if i >= 0 {
+		num, prefix = t[:i], t[i:]
+	}
+
+	mult, ok := siPrefixes[prefix]
+	if !ok {
+		return 0, fmt.Errorf("invalid rate %q: unknown unit", s)
+	}
+	v, err := strconv.ParseFloat(num, 64)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("invalid rate %q", s)
+	}
+	return v * mult, nil
+}
+
+// bitRates is a flag.Value holding a comma-separated list of rates, each
+// written as described for bitRate.
+type bitRates []float64
+
+func (r *bitRates) String() string {
+	var s []string
+	for _, v := range *r {
+		s = append(s, fmt.Sprintf("%gbps", v))
+	}
+	return strings.Join(s, ",")
+}
+
+func (r *bitRates) Set(s string) error {
+	var rates []float64
+	for _, f := range strings.Split(s, ",") {
+		v, err := parseBitRate(f)
+		if err != nil {
+			return err
+		}
+		rates = append(rates, v)
+	}
+	*r = rates
+	return nil
+}
+
+// iecPrefixes maps the IEC prefixes accepted in sizes to their
+// multipliers.
+var iecPrefixes = map[string]float64{
+	"ki": 1 << 10,
+	"mi": 1 << 20,
+	"gi": 1 << 30,
+	"ti": 1 << 40,
+}
+
+// parseByteSize parses a size in bytes written as a number followed by an
+// optional SI or IEC prefix and an optional "B" suffix, e.g. 50KB, 1MiB
+// or 1500.
+func parseByteSize(s string) (uint64, error) {
+	t := strings.ToLower(strings.TrimSpace(s))
+	t = strings.TrimSuffix(t, "b")
+	i := strings.IndexFunc(t, func(r rune) bool { return r >= 'a' && r <= 'z' })
+	num, prefix := t, ""
+	if i >= 0 {
+		num, prefix = t[:i], t[i:]
+	}
+
+	mult, ok := siPrefixes[prefix]
+	if !ok {
+		mult, ok = iecPrefixes[prefix]
+	}
+	if !ok {
+		return 0, fmt.Errorf("invalid size %q: unknown unit", s)
+	}
+	v, err := strconv.ParseFloat(num, 64)
+	if err != nil || v <= 0 || v*mult > math.MaxUint64 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return uint64(v * mult), nil
+}
+
+// byteSizes is a flag.Value holding a comma-separated list of sizes in
+// bytes, each written as described for parseByteSize.
+type byteSizes []uint64
+
+func (z *byteSizes) String() string {
+	var s []string
+	for _, v := range *z {
+		s = append(s, strconv.FormatUint(v, 10))
+	}
+	return strings.Join(s, ",")
+}
+
+func (z *byteSizes) Set(s string) error {
+	var sizes []uint64
+	for _, f := range strings.Split(s, ",") {
+		v, err := parseByteSize(f)
+		if err != nil {
+			return err
+		}
+		sizes = append(sizes, v)
+	}
+	*z = sizes
+	return nil
+}
+
+// percentage is a flag.Value holding a percentage, written as a number
+// with an optional "%" suffix, e.g. 0.5%.
+type percentage struct {
+	value float64
+	set   bool
+}
+
+func (p *percentage) String() string {
+	if !p.set {
+		return ""
+	}
+	return fmt.Sprintf("%g%%", p.value)
+}
+
+func (p *percentage) Set(s string) error {
+	v, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(s), "%"), 64)
+	if err != nil || v < 0 || v > 100 {
+		return fmt.Errorf("invalid percentage %q", s)
+	}
+	p.value, p.set = v, true
+	return nil
+}
+
+// rate formats the rate of n bytes transferred in d as described for
+// formatRate.
+func rate(n uint64, d time.Duration) string {
+	return formatRate(float64(n) * 8 / d.Seconds())
+}
+
+// formatRate formats a rate given in bits per second in bits or bytes
+// according to -bytes, with the prefix chosen by -units: always kilo, or
+// whichever SI or IEC prefix keeps the number below 1000 (or 1024).
+func formatRate(bps float64) string {
+	v, unit := bps, "bits/s"
+	if *rateBytes {
+		v, unit = bps/8, "B/s"
+	}
+
+	base, prefixes := 1e3, []string{"", "K", "M", "G", "T"}
+	if *rateUnits == "iec" {
+		base, prefixes = 1024, []string{"", "Ki", "Mi", "Gi", "Ti"}
+	}
+	i := 0
+	if *rateUnits == "k" {
+		v, i = v/base, 1
+	} else {
+		for i < len(prefixes)-1 && math.Abs(v) >= base {
+			v /= base
+			i++
+		}
+	}
+	return fmt.Sprintf("%.3f %s%s", v, prefixes[i], unit)
+}
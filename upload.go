@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+// Directions of a test, as sent in a request on the control stream. For
+// an upload the client then sends its data on the same stream and closes
+// its side, and the server answers with the number of bytes it received
+// and the nanoseconds from the first to the last, both as big-endian 64
+// bit numbers.
+//
+// The server still opens the bulk transfer stream on every connection that
+// allows it, as older clients expect. A client testing only the upload
+// allows the server no unidirectional streams, so that no download
+// competes with the upload, whatever the version of the server.
+const (
+	directionDownload = 1 << iota
+	directionUpload
+	directionBoth = directionDownload | directionUpload
+)
+
+// errorCodeMaxBytes is the error code the server stops reading an upload
+// with once it has received -max-bytes of it, and resets RPC responses
+// and echoes with once a connection has been sent -max-bytes of them. It
+// still answers an upload with what it received.
+const errorCodeMaxBytes = quic.StreamErrorCode(1)
+
+var errMaxBytes = errors.New("the server stopped the stream at its -max-bytes limit")
+
+// isMaxBytes reports whether err is the server stopping or resetting a
+// stream with errorCodeMaxBytes.
+func isMaxBytes(err error) bool {
+	var streamErr *quic.StreamError
+	return errors.As(err, &streamErr) && streamErr.Remote && streamErr.ErrorCode == errorCodeMaxBytes
+}
+
+// uploadReplyLen is the length of the server's answer to an upload.
+const uploadReplyLen = 16
+
+// uploadDrainTimeout is how long the client waits, after it stops sending,
+// for the data in flight to arrive and the server to answer.
+const uploadDrainTimeout = 30 * time.Second
+
+var directionNames = map[string]byte{
+	"download": directionDownload,
+	"upload":   directionUpload,
+	"both":     directionBoth,
+}
+
+// serveUpload handles a request on the control stream s for a test in
+// the directions dir.
+func serveUpload(conn quic.Connection, s quic.Stream, clog *logger, dir byte) {
+	if dir&directionUpload == 0 {
+		s.CancelRead(quic.StreamErrorCode(quic.NoError))
+		return
+	}
+
+	clog.Infof("Receiving upload from client: %s", conn.RemoteAddr())
+	var (
+		buf         [readChunkSize]byte
+		n           uint64
+		first, last time.Time
+	)
+	for {
+		m, err := s.Read(buf[:])
+		if m > 0 {
+			if n == 0 {
+				first = time.Now()
+			}
+			last = time.Now()
+			n += uint64(m)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err == nil && *maxBytes > 0 && n >= uint64(*maxBytes) {
+			clog.Infof("Stopping the upload from client at -max-bytes: %s", conn.RemoteAddr())
+			s.CancelRead(errorCodeMaxBytes)
+			break
+		}
+		if err != nil {
+			if !closedByPeer(err) {
+				clog.Errorf("Error reading upload from client: %s: %v", conn.RemoteAddr(), err)
+			}
+			return
+		}
+	}
+	clog.Infof("Received %d bytes from client: %s", n, conn.RemoteAddr())
+
+	var reply [uploadReplyLen]byte
+	binary.BigEndian.PutUint64(reply[:8], n)
+	binary.BigEndian.PutUint64(reply[8:], uint64(last.Sub(first)))
+	if _, err := s.Write(reply[:]); err != nil && !closedByPeer(err) {
+		clog.Errorf("Error writing upload result to client: %s: %v", conn.RemoteAddr(), err)
+	}
+}
+
+// uploadResult is what the server says it received of an upload.
+type uploadResult struct {
+	sent, received uint64
+	duration       time.Duration
+	capped         bool // the server stopped reading at its -max-bytes
+}
+
+// runUpload sends data to the server on a control stream for -seconds and
+// returns what the server received.
+func runUpload(ctx context.Context, conn quic.Connection, dir byte) (uploadResult, error) {
+	var r uploadResult
+	s, _, err := openControl(ctx, conn)
+	if err != nil {
+		return r, err
+	}
+	if _, err := s.Write([]byte{dir}); err != nil {
+		return r, err
+	}
+
+	if err := s.SetWriteDeadline(time.Now().Add(time.Duration(*durationInSecs) * time.Second)); err != nil {
+		return r, err
+	}
+	for ctx.Err() == nil {
+		n, err := s.Write(data[:])
+		r.sent += uint64(n)
+		if e, ok := err.(net.Error); ok && e.Timeout() {
+			break
+		}
+		if isMaxBytes(err) {
+			r.capped = true
+			break
+		}
+		if err != nil {
+			return r, err
+		}
+	}
+	s.SetWriteDeadline(time.Time{})
+	s.Close()
+
+	s.SetReadDeadline(time.Now().Add(uploadDrainTimeout))
+	var reply [uploadReplyLen]byte
+	if _, err := io.ReadFull(s, reply[:]); err != nil {
+		return r, fmt.Errorf("reading the upload result: %v", err)
+	}
+	r.received = binary.BigEndian.Uint64(reply[:8])
+	r.duration = time.Duration(binary.BigEndian.Uint64(reply[8:]))
+	return r, nil
+}
+
+// print prints the upload result.
+func (r uploadResult) print() {
+	fmt.Printf("Sent: %d bytes\n", r.sent)
+	fmt.Printf("Server received: %d bytes in %.3f seconds (%s)\n",
+		r.received, r.duration.Seconds(), rate(r.received, r.duration))
+	if r.capped {
+		fmt.Println("The server stopped the upload at its -max-bytes limit")
+	}
+}
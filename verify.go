@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+)
+
+// In -verify mode the payload is sent in blocks of len(data) bytes, each
+// starting with a header made up of a big-endian 64 bit sequence number
+// and a big-endian CRC-32C checksum of the sequence number and the rest of
+// the block.
+const (
+	verifyBlockSize = len(data)
+	verifyHeaderLen = 12
+)
+
+// maxCorruptionReports bounds how many corrupt blocks are logged
+// individually, so that a badly broken path doesn't flood the logs.
+const maxCorruptionReports = 10
+
+var crc32c = crc32.MakeTable(crc32.Castagnoli)
+
+// blockChecksum returns the checksum of block, skipping the checksum field
+// itself.
+func blockChecksum(block []byte) uint32 {
+	c := crc32.Update(0, crc32c, block[:8])
+	return crc32.Update(c, crc32c, block[verifyHeaderLen:])
+}
+
+// sealBlock stamps block with seq and the checksum that covers it.
+func sealBlock(block []byte, seq uint64) {
+	binary.BigEndian.PutUint64(block, seq)
+	binary.BigEndian.PutUint32(block[8:], blockChecksum(block))
+}
+
+// blockVerifier is an io.Writer that reassembles the received stream into
+// blocks and checks each block's sequence number and checksum.
+type blockVerifier struct {
+	block [verifyBlockSize]byte
+	fill  int
+
+	nextSeq    uint64
+	blocks     uint64
+	corrupt    uint64
+	outOfOrder uint64
+}
+
+func (v *blockVerifier) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		c := copy(v.block[v.fill:], p)
+		v.fill += c
+		p = p[c:]
+		if v.fill == len(v.block) {
+			v.check()
+			v.fill = 0
+		}
+	}
+	return n, nil
+}
+
+func (v *blockVerifier) check() {
+	v.blocks++
+	seq := binary.BigEndian.Uint64(v.block[:8])
+	want := binary.BigEndian.Uint32(v.block[8:verifyHeaderLen])
+	if got := blockChecksum(v.block[:]); got != want {
+		v.corrupt++
+		if v.corrupt <= maxCorruptionReports {
+			log.Errorf("Block %d (sequence number %d) is corrupt: checksum is %08x, want %08x",
+				v.blocks-1, seq, got, want)
+		}
+		v.nextSeq++
+		return
+	}
+
+	if seq != v.nextSeq {
+		v.outOfOrder++
+		log.Errorf("Block %d has sequence number %d, want %d", v.blocks-1, seq, v.nextSeq)
+	}
+	v.nextSeq = seq + 1
+}
+
+// ok reports whether every block received so far was intact and in order.
+func (v *blockVerifier) ok() bool {
+	return v.corrupt == 0 && v.outOfOrder == 0
+}
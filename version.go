@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+)
+
+// quicGoModule is the module path of quic-go, whose version is reported
+// with qperf's.
+const quicGoModule = "github.com/quic-go/quic-go"
+
+// printVersion prints qperf's version and git commit, and the versions of
+// Go and quic-go it was built with, as recorded in the binary.
+func printVersion() {
+	version, commit, quicGo := "unknown", "unknown", "unknown"
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		version = bi.Main.Version
+		var revision, modified string
+		for _, s := range bi.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				revision = s.Value
+			case "vcs.modified":
+				modified = s.Value
+			}
+		}
+		if revision != "" {
+			commit = revision
+			if modified == "true" {
+				commit += " (modified)"
+			}
+		}
+		for _, dep := range bi.Deps {
+			if dep.Path != quicGoModule {
+				continue
+			}
+			quicGo = dep.Version
+			if dep.Replace != nil {
+				quicGo = fmt.Sprintf("%s (replaced by %s %s)", dep.Version, dep.Replace.Path, dep.Replace.Version)
+			}
+		}
+	}
+
+	fmt.Printf("qperf %s\n", version)
+	fmt.Printf("Commit: %s\n", commit)
+	fmt.Printf("Go: %s %s/%s\n", runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	fmt.Printf("quic-go: %s\n", quicGo)
+}
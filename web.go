@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+// runWeb loads pages of -web objects, with sizes drawn from -web-sizes,
+// one page after another for the duration of the test. The objects of a
+// page are requested at once, each on its own stream, as a browser loads
+// a page over HTTP/3, and the page is loaded when the last of them has
+// arrived. It then prints the latencies of the objects and pages and the
+// goodput while loading.
+func runWeb(ctx context.Context, conn quic.Connection) testResult {
+	cancelBulkStream(ctx, conn)
+
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(*durationInSecs)*time.Second)
+	defer cancel()
+
+	rng := newWorkloadRand()
+	var (
+		mu       sync.Mutex
+		objects  = newHistogram()
+		pages    = newHistogram()
+		received uint64
+		failed   int
+		busy     time.Duration
+	)
+	for ctx.Err() == nil {
+		var wg sync.WaitGroup
+		ok := true
+		start := time.Now()
+		for i := 0; i < *web; i++ {
+			req := newRPCRequest(*rpcRequestSize, webSizes.sample(rng))
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				t := time.Now()
+				n, err := doRPC(ctx, conn, req)
+
+				mu.Lock()
+				defer mu.Unlock()
+				received += n
+				if err != nil {
+					ok = false
+					if ctx.Err() == nil {
+						log.Errorf("Error requesting an object from %s: %v", conn.RemoteAddr(), err)
+						failed++
+					}
+					return
+				}
+				objects.record(time.Since(t))
+			}()
+		}
+		wg.Wait()
+		d := time.Since(start)
+		busy += d
+		if ok {
+			pages.record(d)
+		}
+	}
+
+	fmt.Printf("Loaded: %d pages of %d objects (%d objects failed)\n", pages.count(), *web, failed)
+	if pages.count() > 0 {
+		fmt.Printf("Page load time: p50 %.3f ms, p90 %.3f ms, p99 %.3f ms, max %.3f ms\n",
+			float64(pages.percentile(50))/1e6,
+			float64(pages.percentile(90))/1e6,
+			float64(pages.percentile(99))/1e6,
+			float64(pages.percentile(100))/1e6)
+	}
+	fmt.Printf("Received: %d bytes in %d objects, %.3f seconds busy (%s while busy)\n",
+		received, objects.count(), busy.Seconds(), rate(received, busy))
+	reportLatencies(objects)
+	return testResult{bytes: received, duration: busy}
+}
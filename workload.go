@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+// sizeDist is a distribution of object sizes in bytes, written as one of
+//
+//	fixed:N        always N bytes
+//	uniform:MIN-MAX uniformly distributed between MIN and MAX bytes
+//	exp:MEAN       exponentially distributed with a mean of MEAN bytes
+type sizeDist struct {
+	kind     string
+	min, max float64
+}
+
+func (d *sizeDist) String() string {
+	switch d.kind {
+	case "fixed":
+		return fmt.Sprintf("fixed:%.0f", d.min)
+	case "uniform":
+		return fmt.Sprintf("uniform:%.0f-%.0f", d.min, d.max)
+	case "exp":
+		return fmt.Sprintf("exp:%.0f", d.min)
+	}
+	return ""
+}
+
+func (d *sizeDist) Set(s string) error {
+	kind, params, ok := strings.Cut(s, ":")
+	if !ok {
+		return fmt.Errorf("invalid size distribution %q", s)
+	}
+
+	parse := func(v string) (float64, error) {
+		n, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			return 0, fmt.Errorf("invalid size %q in distribution %q", v, s)
+		}
+		return float64(n), nil
+	}
+
+	var err error
+	switch kind {
+	case "fixed", "exp":
+		d.min, err = parse(params)
+		d.max = d.min
+	case "uniform":
+		lo, hi, ok := strings.Cut(params, "-")
+		if !ok {
+			return fmt.Errorf("invalid uniform size distribution %q", s)
+		}
+		if d.min, err = parse(lo); err != nil {
+			return err
+		}
+		if d.max, err = parse(hi); err == nil && d.max < d.min {
+			err = fmt.Errorf("invalid uniform size distribution %q: MAX is less than MIN", s)
+		}
+	default:
+		return fmt.Errorf("unknown size distribution %q", kind)
+	}
+	d.kind = kind
+	return err
+}
+
+// sample draws a size from d using rng.
+func (d *sizeDist) sample(rng *rand.Rand) int {
+	var v float64
+	switch d.kind {
+	case "uniform":
+		v = d.min + rng.Float64()*(d.max-d.min+1)
+	case "exp":
+		v = rng.ExpFloat64() * d.min
+	default:
+		v = d.min
+	}
+	return int(math.Min(v, math.MaxInt32))
+}
+
+// newWorkloadRand returns the random source for client workloads, seeded
+// with -seed if it was given.
+func newWorkloadRand() *rand.Rand {
+	s := *seed
+	if s == 0 {
+		s = time.Now().UnixNano()
+	}
+	return rand.New(rand.NewSource(s))
+}
+
+// runScheduled starts a request at each offset from the start of the test
+// returned by next, asking for a response of the size returned with it.
+// It stops when next returns false or ctx is done, then waits for the
+// outstanding requests and prints their latencies.
+func runScheduled(ctx context.Context, conn quic.Connection, next func() (time.Duration, int, bool)) testResult {
+	var (
+		mu          sync.Mutex
+		latencies   = newHistogram()
+		received    uint64
+		failed      int
+		outstanding int
+		maxOut      int
+		maxLate     time.Duration
+		wg          sync.WaitGroup
+	)
+	start := time.Now()
+	issued := 0
+	for {
+		at, size, ok := next()
+		if !ok {
+			break
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(time.Until(start.Add(at))):
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		if late := time.Since(start) - at; late > maxLate {
+			maxLate = late
+		}
+
+		req := newRPCRequest(*rpcRequestSize, size)
+		issued++
+		mu.Lock()
+		outstanding++
+		if outstanding > maxOut {
+			maxOut = outstanding
+		}
+		mu.Unlock()
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			t := time.Now()
+			n, err := doRPC(ctx, conn, req)
+
+			mu.Lock()
+			defer mu.Unlock()
+			outstanding--
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Errorf("Error making request to %s: %v", conn.RemoteAddr(), err)
+					failed++
+				}
+				return
+			}
+			latencies.record(time.Since(t))
+			received += n
+		}()
+	}
+	wg.Wait()
+	dur := time.Since(start)
+
+	fmt.Printf("Completed: %d of %d requests in %.3f seconds (%.1f requests/s, %d failed, at most %d outstanding, started up to %.3f ms late)\n",
+		latencies.count(), issued, dur.Seconds(),
+		float64(latencies.count())/dur.Seconds(), failed, maxOut, float64(maxLate)/1e6)
+	fmt.Printf("Received: %d bytes (%s)\n", received, rate(received, dur))
+	reportLatencies(latencies)
+
+	return testResult{bytes: received, duration: dur}
+}